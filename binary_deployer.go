@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type s3ArtifactAPI interface {
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// artifactFile is one file of a release, relative to the release root.
+type artifactFile struct {
+	name string
+	data []byte
+}
+
+type binaryDeployer struct {
+	cfg          config
+	s3           s3ArtifactAPI
+	dial         func(addr string) (sshRunner, error)
+	pollInterval time.Duration // 0 means use default (2s)
+	pollTimeout  time.Duration // 0 means use default (120s)
+}
+
+// Release layout on the node:
+//
+//	<dir>/releases/<tag>/   uploaded artifact
+//	<dir>/current           symlink to releases/<tag>
+//	<dir>/previous-tag      tag that was live before the current one
+func (d *binaryDeployer) deploy(ctx context.Context, service, env, tag, oldTag string, _ deployParams) error {
+	svc := d.cfg.Services[service]
+	ec := svc.Env[env]
+	addr := d.cfg.Nodes[ec.Node]
+	dir := binaryDir(d.cfg.Project, service, ec)
+
+	client, err := d.dial(addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	defer client.close()
+
+	release := path.Join(dir, "releases", tag)
+	if _, err := client.run(ctx, "test -d "+shellQuote(release)); err != nil {
+		// Not on the node yet (rollbacks usually are): fetch and upload.
+		if err := d.uploadRelease(ctx, client, svc, tag, release); err != nil {
+			return err
+		}
+	}
+
+	reportProgress(ctx, "switching current release...")
+	if err := switchRelease(ctx, client, dir, tag); err != nil {
+		return err
+	}
+
+	if err := d.restartAndCheck(ctx, client, svc); err != nil {
+		if oldTag != "" {
+			// Put the previous release back so the unit isn't left broken (best-effort).
+			reportProgress(ctx, "restoring previous release...")
+			if switchRelease(ctx, client, dir, oldTag) == nil {
+				client.run(ctx, "systemctl restart "+shellQuote(svc.Unit))
+			}
+		}
+		return err
+	}
+
+	// Only a release that came up replaces the marker: after a restored
+	// release, oldTag is current again.
+	if oldTag != "" {
+		if _, err := client.run(ctx, fmt.Sprintf("printf '%%s' %s > %s", shellQuote(oldTag), shellQuote(path.Join(dir, "previous-tag")))); err != nil {
+			return fmt.Errorf("writing previous-tag marker: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d *binaryDeployer) uploadRelease(ctx context.Context, client sshRunner, svc serviceConfig, tag, release string) error {
+	reportProgress(ctx, "fetching artifact...")
+	var files []artifactFile
+	var err error
+	if svc.Artifact.Bucket != "" {
+		files, err = fetchS3Artifact(ctx, d.s3, svc.Artifact.Bucket, tag)
+	} else {
+		files, err = fetchLocalArtifact(filepath.Join(svc.Artifact.Dir, tag))
+	}
+	if err != nil {
+		return fmt.Errorf("fetching artifact: %w", err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("build not found: no artifact files for %s", tag)
+	}
+
+	found := false
+	for _, f := range files {
+		if f.name == svc.Binary {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("artifact for %s has no %q binary", tag, svc.Binary)
+	}
+
+	// Upload next to the final location and rename, so an interrupted upload
+	// never looks like a complete release.
+	staging := release + ".tmp"
+	client.run(ctx, "rm -rf "+shellQuote(staging))
+	for i, f := range files {
+		reportProgress(ctx, "uploading %s (%d/%d)...", f.name, i+1, len(files))
+		if err := client.upload(ctx, path.Join(staging, f.name), f.data); err != nil {
+			return fmt.Errorf("uploading %s: %w", f.name, err)
+		}
+	}
+	binary := shellQuote(path.Join(staging, svc.Binary))
+	if _, err := client.run(ctx, fmt.Sprintf("chmod 755 %s && mv -T %s %s", binary, shellQuote(staging), shellQuote(release))); err != nil {
+		return fmt.Errorf("finalizing release: %w", err)
+	}
+	return nil
+}
+
+func (d *binaryDeployer) restartAndCheck(ctx context.Context, client sshRunner, svc serviceConfig) error {
+	reportProgress(ctx, "restarting %s...", svc.Unit)
+	if _, err := client.run(ctx, "systemctl restart "+shellQuote(svc.Unit)); err != nil {
+		return fmt.Errorf("restarting unit: %w", err)
+	}
+
+	interval := d.pollInterval
+	if interval == 0 {
+		interval = 2 * time.Second
+	}
+	timeout := d.pollTimeout
+	if timeout == 0 {
+		timeout = 120 * time.Second
+	}
+
+	reportProgress(ctx, "waiting for healthcheck...")
+	if err := pollCommand(ctx, client, binaryHealthCmd(svc), interval, timeout); err != nil {
+		return fmt.Errorf("healthcheck failed: %w", err)
+	}
+	return nil
+}
+
+// binaryHealthCmd checks that the unit is active and, when the service has
+// an HTTP healthcheck, that it answers. Binaries run on the host network, so
+// localhost is the service itself; wget is a fallback for nodes without curl.
+func binaryHealthCmd(svc serviceConfig) string {
+	cmd := "systemctl is-active --quiet " + shellQuote(svc.Unit)
+	if svc.Healthcheck != "" {
+		url := shellQuote(fmt.Sprintf("http://localhost:%d%s", svc.Port, svc.Healthcheck))
+		cmd += fmt.Sprintf(" && { curl -sf --max-time 5 %s || wget -q -T 5 -O /dev/null %s; }", url, url)
+	}
+	return cmd
+}
+
+// switchRelease atomically points <dir>/current at releases/<tag> by
+// renaming a fresh symlink over the old one.
+func switchRelease(ctx context.Context, client sshRunner, dir, tag string) error {
+	tmp := path.Join(dir, "current.tmp")
+	cmd := fmt.Sprintf("ln -sfn %s %s && mv -T %s %s",
+		shellQuote(path.Join("releases", tag)), shellQuote(tmp), shellQuote(tmp), shellQuote(path.Join(dir, "current")))
+	if _, err := client.run(ctx, cmd); err != nil {
+		return fmt.Errorf("switching current release: %w", err)
+	}
+	return nil
+}
+
+// binaryDir is the releases root for a binary service on its node.
+func binaryDir(project, service string, ec envConfig) string {
+	if ec.Dir != "" {
+		return ec.Dir
+	}
+	return path.Join("/opt", project, service)
+}
+
+func fetchS3Artifact(ctx context.Context, api s3ArtifactAPI, bucket, tag string) ([]artifactFile, error) {
+	prefix := "builds/" + tag + "/"
+	input := &s3.ListObjectsV2Input{Bucket: &bucket, Prefix: &prefix}
+
+	var files []artifactFile
+	for {
+		out, err := api.ListObjectsV2(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, obj := range out.Contents {
+			if obj.Key == nil || strings.HasSuffix(*obj.Key, "/") {
+				continue
+			}
+			got, err := api.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: obj.Key})
+			if err != nil {
+				return nil, fmt.Errorf("downloading s3://%s/%s: %w", bucket, *obj.Key, err)
+			}
+			data, err := io.ReadAll(got.Body)
+			got.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("downloading s3://%s/%s: %w", bucket, *obj.Key, err)
+			}
+			files = append(files, artifactFile{name: strings.TrimPrefix(*obj.Key, prefix), data: data})
+		}
+		if out.IsTruncated == nil || !*out.IsTruncated {
+			break
+		}
+		input.ContinuationToken = out.NextContinuationToken
+	}
+	return files, nil
+}
+
+func fetchLocalArtifact(root string) ([]artifactFile, error) {
+	var files []artifactFile
+	err := filepath.WalkDir(root, func(p string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, artifactFile{name: filepath.ToSlash(rel), data: data})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type mockBinaryRemote struct {
+	mockSSHRunner
+	unhealthy bool // fail every healthcheck command
+}
+
+func (m *mockBinaryRemote) run(ctx context.Context, cmd string) (string, error) {
+	if m.unhealthy && strings.HasPrefix(cmd, "systemctl is-active") {
+		m.commands = append(m.commands, cmd)
+		return "", fmt.Errorf("inactive")
+	}
+	return m.mockSSHRunner.run(ctx, cmd)
+}
+
+// stubS3Artifact serves objects for both listing and download.
+type stubS3Artifact struct {
+	stubS3
+}
+
+func (s *stubS3Artifact) ListObjectsV2(_ context.Context, params *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	var keys []string
+	for k := range s.objects {
+		bucketKey := strings.TrimPrefix(k, *params.Bucket+"/")
+		if bucketKey != k && strings.HasPrefix(bucketKey, *params.Prefix) {
+			keys = append(keys, bucketKey)
+		}
+	}
+	return &s3.ListObjectsV2Output{Contents: s3Objects(keys...)}, nil
+}
+
+func binaryTestConfig() config {
+	cfg := testConfig()
+	cfg.Services["daemon"] = serviceConfig{
+		Type:        "binary",
+		Unit:        "daemon.service",
+		Binary:      "daemon",
+		Port:        9000,
+		Healthcheck: "/health",
+		Artifact:    artifactConfig{Bucket: "artifacts"},
+		Env: map[string]envConfig{
+			"staging": {Node: "web1"},
+		},
+	}
+	return cfg
+}
+
+func TestBinaryHealthCmd(t *testing.T) {
+	svc := serviceConfig{Unit: "daemon.service", Port: 9000, Healthcheck: "/health"}
+	want := "systemctl is-active --quiet daemon.service && { curl -sf --max-time 5 http://localhost:9000/health || wget -q -T 5 -O /dev/null http://localhost:9000/health; }"
+	if got := binaryHealthCmd(svc); got != want {
+		t.Errorf("binaryHealthCmd = %q, want %q", got, want)
+	}
+
+	svc = serviceConfig{Unit: "daemon.service"}
+	if got := binaryHealthCmd(svc); got != "systemctl is-active --quiet daemon.service" {
+		t.Errorf("binaryHealthCmd without healthcheck = %q", got)
+	}
+}
+
+func TestBinaryDeployHappyPath(t *testing.T) {
+	remote := &mockBinaryRemote{mockSSHRunner: mockSSHRunner{
+		responses: []mockRunResult{
+			{err: fmt.Errorf("exit status 1")}, // test -d: release not on node yet
+		},
+	}}
+	stub := &stubS3Artifact{stubS3{objects: map[string]stubS3Object{
+		"artifacts/builds/main-abc1234-20250101000000/daemon":      {body: "ELF"},
+		"artifacts/builds/main-abc1234-20250101000000/config.toml": {body: "x = 1"},
+	}}}
+
+	d := &binaryDeployer{
+		cfg:          binaryTestConfig(),
+		s3:           stub,
+		dial:         func(_ string) (sshRunner, error) { return remote, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  time.Second,
+	}
+
+	err := d.deploy(context.Background(), "daemon", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	staging := "/opt/myapp/daemon/releases/main-abc1234-20250101000000.tmp"
+	if remote.uploads[staging+"/daemon"] != "ELF" || remote.uploads[staging+"/config.toml"] != "x = 1" {
+		t.Errorf("uploads = %v", remote.uploads)
+	}
+
+	want := []string{
+		"test -d /opt/myapp/daemon/releases/main-abc1234-20250101000000",
+		"rm -rf /opt/myapp/daemon/releases/main-abc1234-20250101000000.tmp",
+		"chmod 755 /opt/myapp/daemon/releases/main-abc1234-20250101000000.tmp/daemon && " +
+			"mv -T /opt/myapp/daemon/releases/main-abc1234-20250101000000.tmp /opt/myapp/daemon/releases/main-abc1234-20250101000000",
+		"ln -sfn releases/main-abc1234-20250101000000 /opt/myapp/daemon/current.tmp && mv -T /opt/myapp/daemon/current.tmp /opt/myapp/daemon/current",
+		"systemctl restart daemon.service",
+		binaryHealthCmd(d.cfg.Services["daemon"]),
+		"printf '%s' main-old1234-20241231000000 > /opt/myapp/daemon/previous-tag",
+	}
+	if len(remote.commands) != len(want) {
+		t.Fatalf("commands = %v, want %v", remote.commands, want)
+	}
+	for i := range want {
+		if remote.commands[i] != want[i] {
+			t.Errorf("cmd[%d] = %q, want %q", i, remote.commands[i], want[i])
+		}
+	}
+}
+
+func TestBinaryDeployReusesExistingRelease(t *testing.T) {
+	remote := &mockBinaryRemote{}
+	d := &binaryDeployer{
+		cfg:          binaryTestConfig(),
+		dial:         func(_ string) (sshRunner, error) { return remote, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  time.Second,
+	}
+
+	err := d.deploy(context.Background(), "daemon", "staging", "main-old1234-20241231000000", "main-abc1234-20250101000000", deployParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(remote.uploads) != 0 {
+		t.Errorf("expected no uploads for a release already on the node, got %v", remote.uploads)
+	}
+}
+
+func TestBinaryDeployHealthcheckFailureRestoresPrevious(t *testing.T) {
+	remote := &mockBinaryRemote{unhealthy: true}
+	d := &binaryDeployer{
+		cfg:          binaryTestConfig(),
+		dial:         func(_ string) (sshRunner, error) { return remote, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  50 * time.Millisecond,
+	}
+
+	err := d.deploy(context.Background(), "daemon", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "healthcheck failed") {
+		t.Fatalf("expected healthcheck error, got: %v", err)
+	}
+
+	n := len(remote.commands)
+	if !strings.HasPrefix(remote.commands[n-2], "ln -sfn releases/main-old1234-20241231000000 ") {
+		t.Errorf("cmd[%d] = %q, want switch back to previous release", n-2, remote.commands[n-2])
+	}
+	if remote.commands[n-1] != "systemctl restart daemon.service" {
+		t.Errorf("cmd[%d] = %q, want unit restart", n-1, remote.commands[n-1])
+	}
+	// The previous release is current again, so previous-tag is untouched.
+	for _, cmd := range remote.commands {
+		if strings.Contains(cmd, "previous-tag") {
+			t.Errorf("unexpected %q after a failed deploy", cmd)
+		}
+	}
+}
+
+func TestBinaryDeployMissingBinary(t *testing.T) {
+	remote := &mockBinaryRemote{mockSSHRunner: mockSSHRunner{
+		responses: []mockRunResult{{err: fmt.Errorf("exit status 1")}},
+	}}
+	stub := &stubS3Artifact{stubS3{objects: map[string]stubS3Object{
+		"artifacts/builds/main-abc1234-20250101000000/README": {body: "hi"},
+	}}}
+	d := &binaryDeployer{
+		cfg:  binaryTestConfig(),
+		s3:   stub,
+		dial: func(_ string) (sshRunner, error) { return remote, nil },
+	}
+
+	err := d.deploy(context.Background(), "daemon", "staging", "main-abc1234-20250101000000", "", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), `no "daemon" binary`) {
+		t.Fatalf("expected missing binary error, got: %v", err)
+	}
+	if len(remote.uploads) != 0 {
+		t.Errorf("expected no uploads, got %v", remote.uploads)
+	}
+}
+
+func TestFetchLocalArtifact(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "static"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{"daemon": "ELF", "static/app.css": "body{}"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := fetchLocalArtifact(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := map[string]string{}
+	for _, f := range files {
+		got[f.name] = string(f.data)
+	}
+	if got["daemon"] != "ELF" || got["static/app.css"] != "body{}" || len(got) != 2 {
+		t.Errorf("files = %v", got)
+	}
+
+	files, err = fetchLocalArtifact(filepath.Join(root, "missing"))
+	if err != nil || len(files) != 0 {
+		t.Errorf("missing dir: files = %v, err = %v", files, err)
+	}
+}
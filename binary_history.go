@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type binaryHistoryProvider struct {
+	cfg config
+	run func(ctx context.Context, addr, cmd string) (string, error)
+	now func() time.Time
+}
+
+func (p *binaryHistoryProvider) current(ctx context.Context, service, env string) (deploy, error) {
+	ec := p.cfg.Services[service].Env[env]
+	current := shellQuote(path.Join(binaryDir(p.cfg.Project, service, ec), "current"))
+
+	// Prints the symlink target and its mtime (when it was switched), or
+	// nothing if the service was never deployed.
+	cmd := fmt.Sprintf("readlink %s 2>/dev/null && stat -c %%Y %s; true", current, current)
+	out, err := p.run(ctx, p.cfg.Nodes[ec.Node], cmd)
+	if err != nil {
+		return deploy{}, fmt.Errorf("reading current release: %w", err)
+	}
+	if out == "" {
+		return deploy{}, nil
+	}
+
+	lines := strings.Split(out, "\n")
+	tag := path.Base(strings.TrimSpace(lines[0]))
+
+	var uptime time.Duration
+	if len(lines) > 1 {
+		if secs, err := strconv.ParseInt(strings.TrimSpace(lines[1]), 10, 64); err == nil {
+			now := p.now
+			if now == nil {
+				now = time.Now
+			}
+			uptime = now().Sub(time.Unix(secs, 0))
+		}
+	}
+
+	return deploy{
+		Service: service,
+		Env:     env,
+		Tag:     tag,
+		Uptime:  uptime,
+	}, nil
+}
+
+func (p *binaryHistoryProvider) previous(ctx context.Context, service, env string) (deploy, error) {
+	ec := p.cfg.Services[service].Env[env]
+	marker := shellQuote(path.Join(binaryDir(p.cfg.Project, service, ec), "previous-tag"))
+
+	out, err := p.run(ctx, p.cfg.Nodes[ec.Node], fmt.Sprintf("cat %s 2>/dev/null; true", marker))
+	if err != nil {
+		return deploy{}, fmt.Errorf("reading previous-tag marker: %w", err)
+	}
+
+	tag := strings.TrimSpace(out)
+	if tag == "" {
+		return deploy{}, nil
+	}
+
+	return deploy{
+		Service: service,
+		Env:     env,
+		Tag:     tag,
+	}, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBinaryHistoryCurrent(t *testing.T) {
+	switched := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &binaryHistoryProvider{
+		cfg: binaryTestConfig(),
+		run: func(_ context.Context, addr, cmd string) (string, error) {
+			if addr != "10.0.0.1" {
+				t.Errorf("unexpected addr: %s", addr)
+			}
+			return "releases/main-abc1234-20250101000000\n1735689600", nil
+		},
+		now: func() time.Time { return switched.Add(5 * time.Hour) },
+	}
+
+	d, err := p.current(context.Background(), "daemon", "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Tag != "main-abc1234-20250101000000" {
+		t.Errorf("tag = %q, want %q", d.Tag, "main-abc1234-20250101000000")
+	}
+	if d.Uptime != 5*time.Hour {
+		t.Errorf("uptime = %v, want 5h", d.Uptime)
+	}
+}
+
+func TestBinaryHistoryCurrentNeverDeployed(t *testing.T) {
+	p := &binaryHistoryProvider{
+		cfg: binaryTestConfig(),
+		run: func(_ context.Context, _, _ string) (string, error) { return "", nil },
+	}
+
+	d, err := p.current(context.Background(), "daemon", "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Tag != "" {
+		t.Errorf("expected empty tag, got %q", d.Tag)
+	}
+}
+
+func TestBinaryHistoryPrevious(t *testing.T) {
+	p := &binaryHistoryProvider{
+		cfg: binaryTestConfig(),
+		run: func(_ context.Context, _, cmd string) (string, error) {
+			if cmd != "cat /opt/myapp/daemon/previous-tag 2>/dev/null; true" {
+				t.Errorf("unexpected command: %s", cmd)
+			}
+			return "main-old1234-20241231000000\n", nil
+		},
+	}
+
+	d, err := p.previous(context.Background(), "daemon", "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Tag != "main-old1234-20241231000000" {
+		t.Errorf("tag = %q, want %q", d.Tag, "main-old1234-20241231000000")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type binaryLogsProvider struct {
+	cfg config
+}
+
+func (p *binaryLogsProvider) tail(_ context.Context, service, env string, n int, since string) error {
+	svc := p.cfg.Services[service]
+	ec := svc.Env[env]
+
+	follow := n == 0 && since == ""
+	args := journalctlArgs(svc.Unit, since, n, follow)
+
+	// TODO: SSH into node and run journalctl
+	fmt.Printf("[%s] Would run on node %q: journalctl %s\n", service, ec.Node, strings.Join(args, " "))
+	return nil
+}
+
+func journalctlArgs(unit, since string, n int, follow bool) []string {
+	args := []string{"-u", unit}
+
+	if n > 0 {
+		args = append(args, "-n", strconv.Itoa(n))
+	}
+
+	if since != "" {
+		// journalctl doesn't take bare durations like docker does.
+		args = append(args, "--since", "-"+since)
+	}
+
+	if follow {
+		args = append(args, "-f")
+	}
+
+	return args
+}
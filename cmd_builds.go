@@ -14,6 +14,7 @@ func newBuildsCmd() *cobra.Command {
 		limit    int
 		cfgPath  string
 		services []string
+		env      string
 	)
 
 	cmd := &cobra.Command{
@@ -40,7 +41,7 @@ func newBuildsCmd() *cobra.Command {
 				}
 				allServices = services
 			}
-			bp := buildsForServices(cfg, p, allServices)
+			bp := buildsForServices(cfg, p, allServices, env)
 			if bp == nil {
 				return fmt.Errorf("no builds provider available")
 			}
@@ -65,24 +66,31 @@ func newBuildsCmd() *cobra.Command {
 	cmd.Flags().IntVar(&limit, "limit", 10, "maximum number of builds to show")
 	cmd.Flags().StringVarP(&cfgPath, "config", "c", "hoist.yml", "config file path")
 	cmd.Flags().StringSliceVarP(&services, "service", "s", nil, "filter by service (comma-separated)")
+	cmd.Flags().StringVarP(&env, "env", "e", "", "only list builds available in this environment")
 
 	return cmd
 }
 
+// enrichBuilds fills in commit message and author from the local git
+// checkout for builds whose provider didn't know them.
 func enrichBuilds(builds []build) {
 	for i, b := range builds {
-		out, err := gitOutput("git", "log", "-1", "--format=%s\n%an", b.SHA)
-		if err != nil {
+		if b.Message != "" || b.Author != "" {
 			continue
 		}
-		parts := strings.SplitN(out, "\n", 2)
-		if len(parts) >= 1 {
-			builds[i].Message = parts[0]
-		}
-		if len(parts) >= 2 {
-			builds[i].Author = parts[1]
-		}
+		builds[i].Message, builds[i].Author = gitCommitInfo(b.SHA)
+	}
+}
+
+// gitCommitInfo returns the subject and author of a commit, or empty
+// strings if it isn't in the local checkout.
+func gitCommitInfo(sha string) (message, author string) {
+	out, err := gitOutput("git", "log", "-1", "--format=%s\n%an", sha)
+	if err != nil {
+		return "", ""
 	}
+	message, author, _ = strings.Cut(out, "\n")
+	return message, author
 }
 
 func formatBuildTime(t time.Time) string {
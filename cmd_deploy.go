@@ -18,12 +18,17 @@ func addDeployToRoot(cmd *cobra.Command) {
 		build    string
 		yes      bool
 		cfgPath  string
+
+		skipPreflight bool
+		noWait        bool
 	)
 
 	cmd.Flags().StringSliceVarP(&services, "service", "s", nil, "services to deploy (comma-separated)")
 	cmd.Flags().StringVarP(&env, "env", "e", "", "target environment")
 	cmd.Flags().StringVarP(&build, "build", "b", "", "build tag or branch name")
 	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "skip confirmation prompt")
+	cmd.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "deploy even if preflight checks fail")
+	cmd.Flags().BoolVar(&noWait, "no-wait", false, "don't wait for CloudFront invalidations or origin switches to complete")
 	cmd.Flags().StringVarP(&cfgPath, "config", "c", "hoist.yml", "config file path")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
@@ -42,6 +47,9 @@ func addDeployToRoot(cmd *cobra.Command) {
 			Env:      env,
 			Build:    build,
 			Yes:      yes,
+
+			SkipPreflight: skipPreflight,
+			NoWait:        noWait,
 		}
 
 		return runDeploy(context.Background(), cfg, p, opts)
@@ -57,44 +65,98 @@ func newProviders(ctx context.Context, cfg config) (providers, error) {
 	ecrClient := ecr.NewFromConfig(awsCfg)
 	cfClient := cloudfront.NewFromConfig(awsCfg)
 
-	var serverRepo string
+	var serverRepo, workerRepo, composeRepo string
 	for _, svc := range cfg.Services {
-		if svc.Type == "server" {
+		if svc.Type == "server" && serverRepo == "" {
 			serverRepo = parseECRRepo(svc.Image)
-			break
+		}
+		if svc.Type == "worker" && workerRepo == "" {
+			workerRepo = parseECRRepo(svc.Image)
+		}
+		if svc.Type == "compose" && composeRepo == "" {
+			composeRepo = parseECRRepo(svc.Image)
 		}
 	}
 
-	var staticBucket string
-	for _, svc := range cfg.Services {
-		if svc.Type == "static" {
-			for _, ec := range svc.Env {
-				staticBucket = ec.Bucket
-				break
-			}
-			break
-		}
+	storage, err := newStorageClients(ctx, cfg, awsCfg)
+	if err != nil {
+		return providers{}, err
+	}
+
+	// Workers run on the same nodes as servers and are deployed and inspected
+	// the same way, minus routing and HTTP healthchecks.
+	// Nodes are logged into the image's registry before each pull, so they
+	// don't need long-lived registry credentials of their own.
+	ecrIn := func(region string) *ecr.Client {
+		return ecr.NewFromConfig(awsCfg, func(o *ecr.Options) { o.Region = region })
+	}
+	regional := func(region string) ecrDescribeImagesAPI { return ecrIn(region) }
+	auth := &registryAuth{
+		registries: cfg.Registries,
+		ecr:        func(region string) ecrAuthAPI { return ecrIn(region) },
+	}
+	sd := &serverDeployer{
+		cfg:  cfg,
+		dial: func(addr string) (sshRunner, error) { return sshDial(addr) },
+		auth: auth,
+	}
+	sh := &serverHistoryProvider{cfg: cfg, run: sshRun}
+	sl := &serverLogsProvider{cfg: cfg, history: sh}
+	sp := &serverPreflight{
+		cfg:    cfg,
+		images: &serverBuildsProvider{ecr: ecrClient, regional: regional},
+		dial:   func(addr string) (sshRunner, error) { return sshDial(addr) },
+		auth:   auth,
 	}
 
-	return providers{
+	p := providers{
 		builds: map[string]buildsProvider{
-			"server": &serverBuildsProvider{ecr: ecrClient, repoName: serverRepo},
-			"static": &staticBuildsProvider{s3: s3Client, bucket: staticBucket},
+			"server":  &serverBuildsProvider{ecr: ecrClient, repoName: serverRepo, regional: regional},
+			"worker":  &serverBuildsProvider{ecr: ecrClient, repoName: workerRepo, regional: regional},
+			"compose": &serverBuildsProvider{ecr: ecrClient, repoName: composeRepo, regional: regional},
+			"static":  &staticEnvBuildsProvider{cfg: cfg, s3: s3Client, storage: storageAs[s3ListObjectsAPI](storage)},
+			"binary":  &binaryBuildsProvider{cfg: cfg, s3: s3Client},
 		},
 		deployers: map[string]deployer{
-			"server": &serverDeployer{
+			"server": sd,
+			"worker": sd,
+			"compose": &composeDeployer{
+				cfg:  cfg,
+				dial: func(addr string) (sshRunner, error) { return sshDial(addr) },
+				auth: auth,
+			},
+			"binary": &binaryDeployer{
 				cfg:  cfg,
+				s3:   s3Client,
 				dial: func(addr string) (sshRunner, error) { return sshDial(addr) },
 			},
-			"static": &staticDeployer{cfg: cfg, s3: s3Client, cloudfront: cfClient},
+			"static": &staticDeployer{cfg: cfg, s3: s3Client, storage: storageAs[s3DeployAPI](storage), cloudfront: cfClient},
 		},
 		history: map[string]historyProvider{
-			"server": &serverHistoryProvider{cfg: cfg, run: sshRun},
-			"static": &staticHistoryProvider{cfg: cfg, s3: s3Client},
+			"server":  sh,
+			"worker":  sh,
+			"compose": &composeHistoryProvider{cfg: cfg, run: sshRun},
+			"binary":  &binaryHistoryProvider{cfg: cfg, run: sshRun},
+			"static":  &staticHistoryProvider{cfg: cfg, s3: s3Client, storage: storageAs[s3GetObjectAPI](storage)},
 		},
 		logs: map[string]logsProvider{
-			"server": &serverLogsProvider{cfg: cfg},
-			"static": &staticLogsProvider{cfg: cfg},
+			"server":  sl,
+			"worker":  sl,
+			"compose": &composeLogsProvider{cfg: cfg},
+			"binary":  &binaryLogsProvider{cfg: cfg},
+			"static":  &staticLogsProvider{cfg: cfg},
 		},
-	}, nil
+		preflight: map[string]preflighter{
+			"server":  sp,
+			"worker":  sp,
+			"compose": sp,
+			"binary": &binaryPreflight{
+				cfg:  cfg,
+				s3:   s3Client,
+				dial: func(addr string) (sshRunner, error) { return sshDial(addr) },
+			},
+			"static": &staticPreflight{cfg: cfg, s3: s3Client, storage: storageAs[s3ListAPI](storage), cloudfront: cfClient},
+		},
+	}
+	return p, nil
 }
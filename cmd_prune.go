@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+)
+
+func newPruneCmd() *cobra.Command {
+	var (
+		services []string
+		env      string
+		keep     int
+		dryRun   bool
+		cfgPath  string
+	)
+
+	cmd := &cobra.Command{
+		Use:           "prune",
+		Short:         "Remove old images and stopped containers from nodes",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cfgPath)
+			if err != nil {
+				return err
+			}
+
+			if !cmd.Flags().Changed("keep") {
+				keep = -1
+			}
+			return runPrune(context.Background(), cfg, func(addr string) (sshRunner, error) { return sshDial(addr) }, pruneOpts{
+				Services: services,
+				Env:      env,
+				Keep:     keep,
+				DryRun:   dryRun,
+			}, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&services, "service", "s", nil, "services to prune (comma-separated)")
+	cmd.Flags().StringVarP(&env, "env", "e", "", "only prune nodes of this environment")
+	cmd.Flags().IntVar(&keep, "keep", defaultPruneKeep, "old images to keep per service besides the running one (default: prune.keep or 2)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "show what would be removed without removing it")
+	cmd.Flags().StringVarP(&cfgPath, "config", "c", "hoist.yml", "config file path")
+
+	return cmd
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/cobra"
+)
+
+func newPublishCmd() *cobra.Command {
+	var (
+		service string
+		env     string
+		attempt int
+		cfgPath string
+	)
+
+	cmd := &cobra.Command{
+		Use:           "publish <dir>",
+		Short:         "Upload a static build as a new build tag",
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cfgPath)
+			if err != nil {
+				return err
+			}
+
+			branch, sha, err := resolveGitInfo()
+			if err != nil {
+				return err
+			}
+			message, author := gitCommitInfo(sha)
+
+			ctx := context.Background()
+			awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+			if err != nil {
+				return fmt.Errorf("loading AWS config: %w", err)
+			}
+			storage, err := newStorageClients(ctx, cfg, awsCfg)
+			if err != nil {
+				return err
+			}
+			p := &staticPublisher{s3: s3.NewFromConfig(awsCfg)}
+			if c, ok := storage[service]; ok {
+				p.s3 = c
+			}
+			return runPublish(ctx, cfg, p, publishOpts{
+				Service: service,
+				Env:     env,
+				Dir:     args[0],
+				Tag:     generateTag(branch, sha, time.Now(), attempt),
+				Git:     gitInfo{Branch: branch, SHA: sha, Author: author, Message: message},
+			}, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringVarP(&service, "service", "s", "", "static service to publish")
+	cmd.Flags().StringVarP(&env, "env", "e", "", "only upload to this environment's bucket")
+	cmd.Flags().IntVar(&attempt, "attempt", 0, "build attempt number")
+	cmd.Flags().StringVarP(&cfgPath, "config", "c", "hoist.yml", "config file path")
+	cmd.MarkFlagRequired("service")
+
+	return cmd
+}
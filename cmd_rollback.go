@@ -64,6 +64,9 @@ func newRollbackCmd() *cobra.Command {
 		services []string
 		yes      bool
 		cfgPath  string
+
+		skipPreflight bool
+		noWait        bool
 	)
 
 	cmd := &cobra.Command{
@@ -101,12 +104,17 @@ func newRollbackCmd() *cobra.Command {
 				Env:      env,
 				Tags:     res.tags,
 				Yes:      yes,
+
+				SkipPreflight: skipPreflight,
+				NoWait:        noWait,
 			})
 		},
 	}
 
 	cmd.Flags().StringSliceVarP(&services, "service", "s", nil, "services to rollback (comma-separated)")
 	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "skip confirmation prompt")
+	cmd.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "roll back even if preflight checks fail")
+	cmd.Flags().BoolVar(&noWait, "no-wait", false, "don't wait for CloudFront invalidations to complete")
 	cmd.Flags().StringVarP(&cfgPath, "config", "c", "hoist.yml", "config file path")
 
 	return cmd
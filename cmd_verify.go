@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/cobra"
+)
+
+func newVerifyCmd() *cobra.Command {
+	var (
+		services []string
+		env      string
+		cfgPath  string
+	)
+
+	cmd := &cobra.Command{
+		Use:           "verify",
+		Short:         "Check that live static objects match the build manifest and metadata rules",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cfgPath)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+			if err != nil {
+				return fmt.Errorf("loading AWS config: %w", err)
+			}
+			storage, err := newStorageClients(ctx, cfg, awsCfg)
+			if err != nil {
+				return err
+			}
+			d := &staticDeployer{cfg: cfg, s3: s3.NewFromConfig(awsCfg), storage: storageAs[s3DeployAPI](storage)}
+			return runVerify(ctx, cfg, d, verifyOpts{Services: services, Env: env}, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&services, "service", "s", nil, "static services to verify (comma-separated)")
+	cmd.Flags().StringVarP(&env, "env", "e", "", "only verify this environment")
+	cmd.Flags().StringVarP(&cfgPath, "config", "c", "hoist.yml", "config file path")
+
+	return cmd
+}
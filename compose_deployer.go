@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type composeDeployer struct {
+	cfg         config
+	dial        func(addr string) (sshRunner, error)
+	auth        *registryAuth                     // nil skips registry login
+	readFile    func(name string) ([]byte, error) // nil means os.ReadFile
+	waitTimeout time.Duration                     // 0 means use default (120s)
+}
+
+// composeTemplateData is available to compose files as {{.Tag}} etc.
+type composeTemplateData struct {
+	Project string
+	Service string
+	Env     string
+	Tag     string
+	Digest  string // image digest the tag was pinned to; empty if unknown
+}
+
+func (d *composeDeployer) deploy(ctx context.Context, service, env, tag, oldTag string, params deployParams) error {
+	svc := d.cfg.Services[service]
+	ec := svc.Env[env]
+	addr := d.cfg.Nodes[ec.Node]
+
+	readFile := d.readFile
+	if readFile == nil {
+		readFile = os.ReadFile
+	}
+	raw, err := readFile(svc.ComposeFile)
+	if err != nil {
+		return fmt.Errorf("reading compose file: %w", err)
+	}
+
+	composeYAML, err := renderComposeFile(raw, composeTemplateData{
+		Project: d.cfg.Project,
+		Service: service,
+		Env:     env,
+		Tag:     tag,
+		Digest:  params.digest,
+	})
+	if err != nil {
+		return err
+	}
+	override, err := composeOverride(composeYAML, tag, oldTag)
+	if err != nil {
+		return err
+	}
+
+	client, err := d.dial(addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	defer client.close()
+
+	dir := composeDir(d.cfg.Project, service, env)
+	reportProgress(ctx, "uploading compose file...")
+	if err := client.upload(ctx, path.Join(dir, "docker-compose.yml"), composeYAML); err != nil {
+		return fmt.Errorf("uploading compose file: %w", err)
+	}
+	if err := client.upload(ctx, path.Join(dir, "hoist.override.yml"), override); err != nil {
+		return fmt.Errorf("uploading compose override: %w", err)
+	}
+
+	if d.auth != nil {
+		if err := d.auth.login(ctx, client, svc.Image); err != nil {
+			return fmt.Errorf("logging in to registry: %w", err)
+		}
+	}
+
+	timeout := d.waitTimeout
+	if timeout == 0 {
+		timeout = 120 * time.Second
+	}
+
+	args := append(composeBaseArgs(composeProjectName(service, env), dir, ec.EnvFile),
+		"up", "-d", "--remove-orphans", "--wait", "--wait-timeout", fmt.Sprint(int(timeout.Seconds())))
+	reportProgress(ctx, "starting containers...")
+	w := &lineWriter{ctx: ctx}
+	err = client.stream(ctx, shellJoin(args), w)
+	w.flush()
+	if err != nil {
+		if tail := w.tail(); tail != "" {
+			return fmt.Errorf("docker compose up: %w\n%s", err, tail)
+		}
+		return fmt.Errorf("docker compose up: %w", err)
+	}
+
+	return nil
+}
+
+// composeDir is where a service's compose files live on the node, relative
+// to the SSH user's home directory.
+func composeDir(project, service, env string) string {
+	return path.Join(".hoist", project, service+"-"+env)
+}
+
+var composeProjectRe = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// composeProjectName derives a docker compose project name from the service
+// and environment. Compose only allows lowercase alphanumerics, - and _.
+func composeProjectName(service, env string) string {
+	return composeProjectRe.ReplaceAllString(strings.ToLower(service+"-"+env), "-")
+}
+
+func composeBaseArgs(projectName, dir, envFile string) []string {
+	args := []string{
+		"docker", "compose",
+		"-p", projectName,
+		"-f", path.Join(dir, "docker-compose.yml"),
+		"-f", path.Join(dir, "hoist.override.yml"),
+	}
+	if envFile != "" {
+		args = append(args, "--env-file", envFile)
+	}
+	return args
+}
+
+func renderComposeFile(raw []byte, data composeTemplateData) ([]byte, error) {
+	tmpl, err := template.New("compose").Option("missingkey=error").Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parsing compose template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("rendering compose template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// composeOverride builds an override file that labels every service in the
+// stack with the deployed and previous tags, so history can be read back
+// from the running containers.
+func composeOverride(composeYAML []byte, tag, oldTag string) ([]byte, error) {
+	var doc struct {
+		Services map[string]yaml.Node `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(composeYAML, &doc); err != nil {
+		return nil, fmt.Errorf("parsing compose file: %w", err)
+	}
+	if len(doc.Services) == 0 {
+		return nil, fmt.Errorf("compose file defines no services")
+	}
+
+	services := make(map[string]any, len(doc.Services))
+	for name := range doc.Services {
+		services[name] = map[string]any{
+			"labels": map[string]string{
+				"hoist.tag":      tag,
+				"hoist.previous": oldTag,
+			},
+		}
+	}
+	return yaml.Marshal(map[string]any{"services": services})
+}
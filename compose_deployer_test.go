@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testComposeFile = `services:
+  web:
+    image: myapp/web:{{.Tag}}
+    labels:
+      env: {{.Env}}
+  worker:
+    image: myapp/web:{{.Tag}}
+    command: ["./worker"]
+`
+
+func composeTestConfig() config {
+	cfg := testConfig()
+	cfg.Services["stack"] = serviceConfig{
+		Type:        "compose",
+		Image:       "myapp/web",
+		ComposeFile: "stack/docker-compose.yml",
+		Env: map[string]envConfig{
+			"staging": {Node: "web1", EnvFile: "/etc/stack/staging.env"},
+		},
+	}
+	return cfg
+}
+
+func TestComposeProjectName(t *testing.T) {
+	tests := []struct {
+		service, env, want string
+	}{
+		{"stack", "staging", "stack-staging"},
+		{"My.Stack", "Prod", "my-stack-prod"},
+		{"api_v2", "eu west", "api_v2-eu-west"},
+	}
+	for _, tt := range tests {
+		if got := composeProjectName(tt.service, tt.env); got != tt.want {
+			t.Errorf("composeProjectName(%q, %q) = %q, want %q", tt.service, tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestComposeOverride(t *testing.T) {
+	out, err := composeOverride([]byte(testComposeFile), "new-tag", "old-tag")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := string(out)
+	for _, want := range []string{"web:", "worker:", "hoist.tag: new-tag", "hoist.previous: old-tag"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("override missing %q:\n%s", want, got)
+		}
+	}
+
+	if _, err := composeOverride([]byte("version: '3'\n"), "t", ""); err == nil {
+		t.Error("expected error for compose file without services")
+	}
+}
+
+func TestComposeDeployHappyPath(t *testing.T) {
+	mock := &mockSSHRunner{}
+	var readPath string
+	d := &composeDeployer{
+		cfg:  composeTestConfig(),
+		dial: func(_ string) (sshRunner, error) { return mock, nil },
+		readFile: func(name string) ([]byte, error) {
+			readPath = name
+			return []byte(testComposeFile), nil
+		},
+	}
+
+	err := d.deploy(context.Background(), "stack", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if readPath != "stack/docker-compose.yml" {
+		t.Errorf("read %q, want stack/docker-compose.yml", readPath)
+	}
+
+	compose := mock.uploads[".hoist/myapp/stack-staging/docker-compose.yml"]
+	if !strings.Contains(compose, "image: myapp/web:main-abc1234-20250101000000") {
+		t.Errorf("compose file not templated with tag:\n%s", compose)
+	}
+	if !strings.Contains(compose, "env: staging") {
+		t.Errorf("compose file not templated with env:\n%s", compose)
+	}
+	if _, ok := mock.uploads[".hoist/myapp/stack-staging/hoist.override.yml"]; !ok {
+		t.Error("expected override file upload")
+	}
+
+	if len(mock.commands) != 1 {
+		t.Fatalf("expected 1 command, got %d: %v", len(mock.commands), mock.commands)
+	}
+	want := "docker compose -p stack-staging -f .hoist/myapp/stack-staging/docker-compose.yml -f .hoist/myapp/stack-staging/hoist.override.yml --env-file /etc/stack/staging.env up -d --remove-orphans --wait --wait-timeout 120"
+	if mock.commands[0] != want {
+		t.Errorf("command = %q, want %q", mock.commands[0], want)
+	}
+}
+
+func TestComposeDeployUpFailure(t *testing.T) {
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: "container stack-staging-web-1 is unhealthy", err: fmt.Errorf("exit status 1")},
+		},
+	}
+	d := &composeDeployer{
+		cfg:      composeTestConfig(),
+		dial:     func(_ string) (sshRunner, error) { return mock, nil },
+		readFile: func(string) ([]byte, error) { return []byte(testComposeFile), nil },
+	}
+
+	err := d.deploy(context.Background(), "stack", "staging", "main-abc1234-20250101000000", "", deployParams{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "docker compose up") || !strings.Contains(err.Error(), "is unhealthy") {
+		t.Errorf("expected compose up error with output, got: %v", err)
+	}
+}
+
+func TestComposeDeployTemplateError(t *testing.T) {
+	d := &composeDeployer{
+		cfg: composeTestConfig(),
+		dial: func(_ string) (sshRunner, error) {
+			t.Fatal("should not dial when the template is invalid")
+			return nil, nil
+		},
+		readFile: func(string) ([]byte, error) { return []byte("image: {{.Nope}}"), nil },
+	}
+
+	err := d.deploy(context.Background(), "stack", "staging", "main-abc1234-20250101000000", "", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "rendering compose template") {
+		t.Errorf("expected template error, got: %v", err)
+	}
+}
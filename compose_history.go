@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type composeHistoryProvider struct {
+	cfg config
+	run func(ctx context.Context, addr, cmd string) (string, error)
+}
+
+func (p *composeHistoryProvider) current(ctx context.Context, service, env string) (deploy, error) {
+	d, status, err := p.readLabel(ctx, service, env, "hoist.tag")
+	if err != nil || d.Tag == "" {
+		return d, err
+	}
+	d.Uptime = parseDockerUptime(status)
+	return d, nil
+}
+
+func (p *composeHistoryProvider) previous(ctx context.Context, service, env string) (deploy, error) {
+	d, _, err := p.readLabel(ctx, service, env, "hoist.previous")
+	return d, err
+}
+
+// readLabel reads a hoist label from the first running container in the
+// service's compose project.
+func (p *composeHistoryProvider) readLabel(ctx context.Context, service, env, label string) (deploy, string, error) {
+	addr := p.cfg.Nodes[p.cfg.Services[service].Env[env].Node]
+
+	cmd := fmt.Sprintf(`docker ps --filter "label=com.docker.compose.project=%s" --format "{{.Label \"%s\"}}\t{{.Status}}"`,
+		composeProjectName(service, env), label)
+	out, err := p.run(ctx, addr, cmd)
+	if err != nil {
+		return deploy{}, "", fmt.Errorf("listing compose containers: %w", err)
+	}
+	if out == "" {
+		return deploy{}, "", nil
+	}
+
+	line := strings.SplitN(out, "\n", 2)[0]
+	parts := strings.SplitN(line, "\t", 2)
+	if len(parts) != 2 {
+		return deploy{}, "", fmt.Errorf("unexpected docker ps output: %q", line)
+	}
+
+	tag := strings.TrimSpace(parts[0])
+	if tag == "" {
+		return deploy{}, "", nil
+	}
+	return deploy{Service: service, Env: env, Tag: tag}, parts[1], nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComposeHistoryCurrent(t *testing.T) {
+	p := &composeHistoryProvider{
+		cfg: composeTestConfig(),
+		run: func(_ context.Context, addr, cmd string) (string, error) {
+			if addr != "10.0.0.1" {
+				t.Errorf("unexpected addr: %s", addr)
+			}
+			if !strings.Contains(cmd, "label=com.docker.compose.project=stack-staging") {
+				t.Errorf("unexpected command: %s", cmd)
+			}
+			if !strings.Contains(cmd, `{{.Label \"hoist.tag\"}}`) {
+				t.Errorf("expected hoist.tag label lookup: %s", cmd)
+			}
+			return "main-abc1234-20250101000000\tUp 2 hours (healthy)\nmain-abc1234-20250101000000\tUp 2 hours", nil
+		},
+	}
+
+	d, err := p.current(context.Background(), "stack", "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Tag != "main-abc1234-20250101000000" {
+		t.Errorf("tag = %q, want %q", d.Tag, "main-abc1234-20250101000000")
+	}
+	if d.Uptime != 2*time.Hour {
+		t.Errorf("uptime = %v, want %v", d.Uptime, 2*time.Hour)
+	}
+}
+
+func TestComposeHistoryPrevious(t *testing.T) {
+	p := &composeHistoryProvider{
+		cfg: composeTestConfig(),
+		run: func(_ context.Context, _, cmd string) (string, error) {
+			if !strings.Contains(cmd, `{{.Label \"hoist.previous\"}}`) {
+				t.Errorf("expected hoist.previous label lookup: %s", cmd)
+			}
+			return "main-old1234-20241231000000\tUp 2 hours", nil
+		},
+	}
+
+	d, err := p.previous(context.Background(), "stack", "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Tag != "main-old1234-20241231000000" {
+		t.Errorf("tag = %q, want %q", d.Tag, "main-old1234-20241231000000")
+	}
+}
+
+func TestComposeHistoryNoContainers(t *testing.T) {
+	p := &composeHistoryProvider{
+		cfg: composeTestConfig(),
+		run: func(_ context.Context, _, _ string) (string, error) { return "", nil },
+	}
+
+	d, err := p.current(context.Background(), "stack", "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Tag != "" {
+		t.Errorf("expected empty tag, got %q", d.Tag)
+	}
+}
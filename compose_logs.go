@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type composeLogsProvider struct {
+	cfg config
+}
+
+func (p *composeLogsProvider) tail(_ context.Context, service, env string, n int, since string) error {
+	ec := p.cfg.Services[service].Env[env]
+
+	follow := n == 0 && since == ""
+	// docker logs and docker compose logs share flags; drop the container
+	// argument, compose selects containers by project.
+	args := dockerLogsArgs("", since, n, follow)
+	args = args[:len(args)-1]
+
+	// TODO: SSH into node and run docker compose logs
+	fmt.Printf("[%s] Would run on node %q: docker compose -p %s %s\n", service, ec.Node, composeProjectName(service, env), strings.Join(args, " "))
+	return nil
+}
@@ -2,15 +2,49 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type config struct {
-	Project  string                   `yaml:"project"`
-	Nodes    map[string]string        `yaml:"nodes"`
-	Services map[string]serviceConfig `yaml:"services"`
+	Project    string                    `yaml:"project"`
+	Nodes      map[string]string         `yaml:"nodes"`
+	Proxies    map[string]proxyConfig    `yaml:"proxies"`    // keyed by node; unlisted nodes use traefik
+	Registries map[string]registryConfig `yaml:"registries"` // keyed by host; ECR needs no entry
+	Services   map[string]serviceConfig  `yaml:"services"`
+}
+
+// registryConfig says where to find a registry's credentials: a password in
+// the config, an environment variable, or a docker credential helper such as
+// osxkeychain or secretservice.
+type registryConfig struct {
+	Username         string `yaml:"username"`
+	Password         string `yaml:"password"`
+	PasswordEnv      string `yaml:"password_env"`
+	CredentialHelper string `yaml:"credential_helper"`
+}
+
+// proxyConfig selects the reverse proxy that routes to server containers on
+// a node.
+type proxyConfig struct {
+	Type    string `yaml:"type"`     // traefik (default), caddy or nginx
+	ConfDir string `yaml:"conf_dir"` // nginx: where upstream configs go; default /etc/nginx/conf.d
+	Reload  string `yaml:"reload"`   // nginx: test-and-reload command run on the node
+	// Container is the caddy-docker-proxy container whose admin API drains
+	// go through. Default caddy.
+	Container string `yaml:"container"`
+
+	// Throttle is how long Traefik takes to apply a change it has seen,
+	// its providersThrottleDuration; drains wait that long after the old
+	// container turns unhealthy. 0 means 2s.
+	Throttle time.Duration `yaml:"throttle"`
 }
 
 type serviceConfig struct {
@@ -18,17 +52,177 @@ type serviceConfig struct {
 	Image       string               `yaml:"image"`
 	Port        int                  `yaml:"port"`
 	Healthcheck string               `yaml:"healthcheck"`
+	StableFor   time.Duration        `yaml:"stable_for"` // worker readiness; 0 means default (10s)
+	ComposeFile string               `yaml:"compose_file"`
+	Unit        string               `yaml:"unit"`
+	Binary      string               `yaml:"binary"`
+	Artifact    artifactConfig       `yaml:"artifact"`
+	Drain       drainConfig          `yaml:"drain"`
+	Container   containerConfig      `yaml:"container"`
+	Log         logConfig            `yaml:"log"`
+	Hooks       hooksConfig          `yaml:"hooks"`
+	Prune       pruneConfig          `yaml:"prune"`
+	Metadata    []metadataRule       `yaml:"metadata"`
+	Manifest    string               `yaml:"manifest"` // static: "required" refuses builds without a manifest; default "optional"
+	Storage     storageConfig        `yaml:"storage"`  // static: S3-compatible storage; empty means AWS S3 with the default credentials
 	Env         map[string]envConfig `yaml:"env"`
 }
 
+// artifactConfig says where binary services fetch build artifacts from:
+// either s3://<bucket>/builds/<tag>/ or a local <dir>/<tag>/.
+type artifactConfig struct {
+	Bucket string `yaml:"bucket"`
+	Dir    string `yaml:"dir"`
+}
+
+// hooksConfig lists commands run around a server deploy. Pre-deploy hooks
+// run in one-off containers of the new image before it is started; a failing
+// pre-deploy hook aborts the deploy.
+type hooksConfig struct {
+	PreDeploy  []hookConfig `yaml:"pre_deploy"`
+	PostDeploy []hookConfig `yaml:"post_deploy"`
+}
+
+type hookConfig struct {
+	Name    string        `yaml:"name"`
+	Command []string      `yaml:"command"`
+	Exec    bool          `yaml:"exec"` // run inside the new container (post_deploy only)
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// pruneConfig controls how many old images hoist prune keeps for a server
+// or worker service, and whether to prune after every deploy.
+type pruneConfig struct {
+	Keep        *int `yaml:"keep"` // images kept besides the running one; nil means 2
+	AfterDeploy bool `yaml:"after_deploy"`
+}
+
+// storageConfig points a static service at S3-compatible storage such as
+// MinIO or Cloudflare R2. Profile selects credentials from the shared AWS
+// config files.
+type storageConfig struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Profile   string `yaml:"profile"`
+	PathStyle bool   `yaml:"path_style"` // address buckets as <endpoint>/<bucket> instead of <bucket>.<endpoint>
+}
+
+// purgeConfig selects how a static env's CDN cache is purged after a
+// deploy: "cloudfront" (the default) invalidates the env's distribution,
+// "webhook" POSTs the changed paths to URL, and "none" skips purging.
+// Header values may reference environment variables as ${NAME}.
+type purgeConfig struct {
+	Type    string            `yaml:"type"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// metadataRule sets metadata on the objects of a static build whose key
+// (relative to the build root) matches a glob; see globMatch. Rules apply in
+// order, later rules overriding fields set by earlier ones. Headers other
+// than Content-Disposition and Content-Language become x-amz-meta-* user
+// metadata.
+type metadataRule struct {
+	Match           string            `yaml:"match"`
+	CacheControl    string            `yaml:"cache_control"`
+	ContentType     string            `yaml:"content_type"`
+	ContentEncoding string            `yaml:"content_encoding"`
+	Headers         map[string]string `yaml:"headers"`
+}
+
+// drainConfig controls how the old container is retired after the new one
+// passes its healthcheck. A zero Period skips draining and stops immediately.
+type drainConfig struct {
+	Period      time.Duration `yaml:"period"`
+	StopTimeout time.Duration `yaml:"stop_timeout"`
+	StopSignal  string        `yaml:"stop_signal"`
+}
+
+// containerConfig holds docker run options for server services. It can be
+// set per service and overridden per environment; see mergeContainer.
+type containerConfig struct {
+	Volumes    []string          `yaml:"volumes"`
+	Networks   []string          `yaml:"networks"`
+	CPUs       string            `yaml:"cpus"`
+	Memory     string            `yaml:"memory"`
+	Ulimits    map[string]string `yaml:"ulimits"`
+	User       string            `yaml:"user"`
+	Entrypoint string            `yaml:"entrypoint"`
+	Command    []string          `yaml:"command"`
+	Env        map[string]string `yaml:"env"`
+	Labels     map[string]string `yaml:"labels"`
+	Init       *bool             `yaml:"init"`
+}
+
+// logConfig selects the docker log driver for server and worker containers.
+// Option values may use ${project}, ${env}, ${service} and ${tag}; see
+// resolveLogConfig for defaults and how env overrides apply.
+type logConfig struct {
+	Driver  string            `yaml:"driver"`
+	Options map[string]string `yaml:"options"`
+}
+
+// routingConfig describes how the proxy routes requests to a server service.
+// Hosts replaces the single host field when more than one name is needed;
+// PathPrefix lets services share a host. TLS, entrypoints and middlewares
+// are Traefik-only.
+type routingConfig struct {
+	Hosts        []string           `yaml:"hosts"`
+	PathPrefix   string             `yaml:"path_prefix"`
+	EntryPoints  []string           `yaml:"entrypoints"`
+	TLS          bool               `yaml:"tls"`
+	CertResolver string             `yaml:"certresolver"` // implies tls
+	Middlewares  []middlewareConfig `yaml:"middlewares"`
+}
+
+// middlewareConfig either defines a Traefik middleware inline (name, type
+// and options, rendered as traefik.http.middlewares.<service>-<name>.<type>.<option>
+// labels) or references one defined elsewhere, e.g. ref: auth@file.
+type middlewareConfig struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"`
+	Options map[string]string `yaml:"options"`
+	Ref     string            `yaml:"ref"`
+}
+
+// smokeCheck is a request hoist makes after a deploy switches traffic, to
+// check the service the way users reach it. Redirects aren't followed, so
+// status can expect a 301.
+type smokeCheck struct {
+	URL     string            `yaml:"url"`
+	Status  int               `yaml:"status"`  // 0 means 200
+	Body    string            `yaml:"body"`    // text the response body must contain
+	Headers map[string]string `yaml:"headers"` // request headers; values may use ${VAR}
+	Host    string            `yaml:"host"`    // Host header and TLS server name, e.g. to check a node before DNS points at it
+}
+
 type envConfig struct {
+	// Smoke runs after server and static deploys; a failing check fails
+	// the deploy.
+	Smoke []smokeCheck `yaml:"smoke"`
 	// Server fields
-	Node    string `yaml:"node"`
-	Host    string `yaml:"host"`
-	EnvFile string `yaml:"envfile"`
+	Node      string          `yaml:"node"`
+	Host      string          `yaml:"host"`
+	EnvFile   string          `yaml:"envfile"`
+	Container containerConfig `yaml:"container"`
+	Log       logConfig       `yaml:"log"`
+	Routing   routingConfig   `yaml:"routing"`
+	// Binary fields
+	Dir string `yaml:"dir"` // releases root; empty means /opt/<project>/<service>
 	// Static fields
-	Bucket     string `yaml:"bucket"`
-	CloudFront string `yaml:"cloudfront"`
+	Bucket      string        `yaml:"bucket"`
+	Prefix      string        `yaml:"prefix"` // key prefix for builds, current/ and markers, so services and envs can share a bucket
+	CloudFront  string        `yaml:"cloudfront"`
+	Strategy    string        `yaml:"strategy"`     // "copy" (default) or "origin_path"
+	OriginID    string        `yaml:"origin_id"`    // origin_path: origin to switch; empty means the bucket's origin
+	DeleteGrace time.Duration `yaml:"delete_grace"` // copy: keep files removed from the build this long; 0 deletes on deploy
+	// InvalidationMaxPaths caps the paths a deploy invalidates before they
+	// are collapsed into wildcards; 0 means 15.
+	InvalidationMaxPaths int `yaml:"invalidation_max_paths"`
+	// InvalidationTimeout is how long a deploy waits for its invalidation
+	// to complete; 0 means 15m.
+	InvalidationTimeout time.Duration `yaml:"invalidation_timeout"`
+	Purge               purgeConfig   `yaml:"purge"`
 }
 
 func loadConfig(path string) (config, error) {
@@ -58,21 +252,105 @@ func validateConfig(cfg config) error {
 		return fmt.Errorf("no services defined")
 	}
 
+	for node, pc := range cfg.Proxies {
+		if _, ok := cfg.Nodes[node]; !ok {
+			return fmt.Errorf("proxies: node %q not defined in nodes", node)
+		}
+		if err := validateProxy(pc); err != nil {
+			return fmt.Errorf("proxies: node %q: %w", node, err)
+		}
+	}
+
+	for host, rc := range cfg.Registries {
+		if err := validateRegistry(rc); err != nil {
+			return fmt.Errorf("registries: %s: %w", host, err)
+		}
+	}
+
 	for name, svc := range cfg.Services {
-		if svc.Type != "server" && svc.Type != "static" {
-			return fmt.Errorf("service %q: unknown type %q (must be \"server\" or \"static\")", name, svc.Type)
+		if svc.Type != "server" && svc.Type != "static" && svc.Type != "worker" && svc.Type != "compose" && svc.Type != "binary" {
+			return fmt.Errorf("service %q: unknown type %q (must be \"server\", \"worker\", \"compose\", \"binary\" or \"static\")", name, svc.Type)
 		}
 
-		if svc.Type == "server" {
+		if svc.Type == "server" || svc.Type == "worker" {
 			if svc.Image == "" {
 				return fmt.Errorf("service %q: missing image", name)
 			}
+			if err := validateDrain(svc.Drain); err != nil {
+				return fmt.Errorf("service %q: %w", name, err)
+			}
+			if err := validateHooks(svc.Hooks); err != nil {
+				return fmt.Errorf("service %q: %w", name, err)
+			}
+			if svc.Prune.Keep != nil && *svc.Prune.Keep < 0 {
+				return fmt.Errorf("service %q: prune.keep must not be negative", name)
+			}
+		} else if svc.Prune != (pruneConfig{}) {
+			return fmt.Errorf("service %q: prune is only supported for server and worker services", name)
+		}
+		if len(svc.Metadata) > 0 && svc.Type != "static" {
+			return fmt.Errorf("service %q: metadata is only supported for static services", name)
+		}
+		for i, r := range svc.Metadata {
+			if err := validateMetadataRule(r); err != nil {
+				return fmt.Errorf("service %q: metadata[%d]: %w", name, i, err)
+			}
+		}
+		if svc.Storage != (storageConfig{}) {
+			if svc.Type != "static" {
+				return fmt.Errorf("service %q: storage is only supported for static services", name)
+			}
+			if svc.Storage.Endpoint != "" {
+				if !isHTTPURL(svc.Storage.Endpoint) {
+					return fmt.Errorf("service %q: storage.endpoint must be an http or https URL", name)
+				}
+			}
+		}
+		switch svc.Manifest {
+		case "", "optional":
+		case "required":
+			if svc.Type != "static" {
+				return fmt.Errorf("service %q: manifest is only supported for static services", name)
+			}
+		default:
+			return fmt.Errorf("service %q: manifest must be optional or required, got %q", name, svc.Manifest)
+		}
+
+		switch svc.Type {
+		case "server":
 			if svc.Port == 0 {
 				return fmt.Errorf("service %q: missing port", name)
 			}
 			if svc.Healthcheck == "" {
 				return fmt.Errorf("service %q: missing healthcheck", name)
 			}
+		case "compose":
+			if svc.Image == "" {
+				return fmt.Errorf("service %q: missing image", name)
+			}
+			if svc.ComposeFile == "" {
+				return fmt.Errorf("service %q: missing compose_file", name)
+			}
+		case "binary":
+			if svc.Unit == "" {
+				return fmt.Errorf("service %q: missing unit", name)
+			}
+			if svc.Binary == "" {
+				return fmt.Errorf("service %q: missing binary", name)
+			}
+			if (svc.Artifact.Bucket == "") == (svc.Artifact.Dir == "") {
+				return fmt.Errorf("service %q: artifact needs exactly one of bucket or dir", name)
+			}
+			if (svc.Port == 0) != (svc.Healthcheck == "") {
+				return fmt.Errorf("service %q: port and healthcheck must be set together", name)
+			}
+		case "worker":
+			if svc.StableFor < 0 {
+				return fmt.Errorf("service %q: stable_for must not be negative", name)
+			}
+			if svc.Drain.Period > 0 {
+				return fmt.Errorf("service %q: drain.period is not supported for workers (use drain.stop_timeout)", name)
+			}
 		}
 
 		if len(svc.Env) == 0 {
@@ -80,30 +358,503 @@ func validateConfig(cfg config) error {
 		}
 
 		for envName, env := range svc.Env {
+			if len(env.Smoke) > 0 && svc.Type != "server" && svc.Type != "static" {
+				return fmt.Errorf("service %q env %q: smoke is only supported for server and static services", name, envName)
+			}
+			for i, c := range env.Smoke {
+				if err := validateSmokeCheck(c); err != nil {
+					return fmt.Errorf("service %q env %q: smoke[%d]: %w", name, envName, i, err)
+				}
+			}
 			switch svc.Type {
-			case "server":
+			case "server", "worker":
 				if env.Node == "" {
 					return fmt.Errorf("service %q env %q: missing node", name, envName)
 				}
 				if _, ok := cfg.Nodes[env.Node]; !ok {
 					return fmt.Errorf("service %q env %q: node %q not defined in nodes", name, envName, env.Node)
 				}
-				if env.Host == "" {
-					return fmt.Errorf("service %q env %q: missing host", name, envName)
+				if svc.Type == "server" {
+					if env.Host == "" && len(env.Routing.Hosts) == 0 {
+						return fmt.Errorf("service %q env %q: missing host", name, envName)
+					}
+					if env.Host != "" && len(env.Routing.Hosts) > 0 {
+						return fmt.Errorf("service %q env %q: set host or routing.hosts, not both", name, envName)
+					}
+					if err := validateRouting(env.Routing, cfg.Proxies[env.Node].Type); err != nil {
+						return fmt.Errorf("service %q env %q: routing: %w", name, envName, err)
+					}
+					// nginx reaches the container by name through Docker's DNS.
+					if cfg.Proxies[env.Node].Type == "nginx" && len(mergeContainer(svc.Container, env.Container).Networks) == 0 {
+						return fmt.Errorf("service %q env %q: container.networks is required on nginx nodes, so nginx can reach the container by name", name, envName)
+					}
 				}
 				if env.EnvFile == "" {
 					return fmt.Errorf("service %q env %q: missing envfile", name, envName)
 				}
+				if err := validateContainer(mergeContainer(svc.Container, env.Container)); err != nil {
+					return fmt.Errorf("service %q env %q: container: %w", name, envName, err)
+				}
+				if err := validateLog(mergeLog(svc.Log, env.Log)); err != nil {
+					return fmt.Errorf("service %q env %q: log: %w", name, envName, err)
+				}
+			case "compose", "binary":
+				if env.Node == "" {
+					return fmt.Errorf("service %q env %q: missing node", name, envName)
+				}
+				if _, ok := cfg.Nodes[env.Node]; !ok {
+					return fmt.Errorf("service %q env %q: node %q not defined in nodes", name, envName, env.Node)
+				}
+				if env.Dir != "" && !strings.HasPrefix(env.Dir, "/") {
+					return fmt.Errorf("service %q env %q: dir must be an absolute path", name, envName)
+				}
 			case "static":
 				if env.Bucket == "" {
 					return fmt.Errorf("service %q env %q: missing bucket", name, envName)
 				}
-				if env.CloudFront == "" {
-					return fmt.Errorf("service %q env %q: missing cloudfront", name, envName)
+				if !bucketPrefixRe.MatchString(bucketPrefix(env)) {
+					return fmt.Errorf("service %q env %q: invalid prefix %q", name, envName, env.Prefix)
+				}
+				switch purgeType(env) {
+				case "cloudfront":
+					if env.CloudFront == "" {
+						return fmt.Errorf("service %q env %q: missing cloudfront", name, envName)
+					}
+				case "webhook", "none":
+					if env.CloudFront != "" {
+						return fmt.Errorf("service %q env %q: cloudfront requires purge type cloudfront", name, envName)
+					}
+					if env.Strategy == "origin_path" {
+						return fmt.Errorf("service %q env %q: strategy origin_path requires purge type cloudfront", name, envName)
+					}
+				default:
+					return fmt.Errorf("service %q env %q: unknown purge type %q (must be \"cloudfront\", \"webhook\" or \"none\")", name, envName, env.Purge.Type)
+				}
+				if purgeType(env) == "webhook" {
+					if !isHTTPURL(env.Purge.URL) {
+						return fmt.Errorf("service %q env %q: purge.url must be an http or https URL", name, envName)
+					}
+				} else if env.Purge.URL != "" || len(env.Purge.Headers) > 0 {
+					return fmt.Errorf("service %q env %q: purge url and headers require purge type webhook", name, envName)
+				}
+				if env.InvalidationTimeout < 0 {
+					return fmt.Errorf("service %q env %q: invalidation_timeout must not be negative", name, envName)
 				}
+				if env.InvalidationMaxPaths < 0 {
+					return fmt.Errorf("service %q env %q: invalidation_max_paths must not be negative", name, envName)
+				}
+				if env.DeleteGrace < 0 {
+					return fmt.Errorf("service %q env %q: delete_grace must not be negative", name, envName)
+				}
+				switch env.Strategy {
+				case "", "copy":
+					if env.OriginID != "" {
+						return fmt.Errorf("service %q env %q: origin_id requires strategy origin_path", name, envName)
+					}
+				case "origin_path":
+					if env.DeleteGrace != 0 {
+						return fmt.Errorf("service %q env %q: delete_grace only applies to strategy copy", name, envName)
+					}
+				default:
+					return fmt.Errorf("service %q env %q: unknown strategy %q (must be \"copy\" or \"origin_path\")", name, envName, env.Strategy)
+				}
+			}
+		}
+	}
+
+	return validateBucketPrefixes(cfg)
+}
+
+// bucketPrefixRe matches a normalized key prefix: empty, or path segments
+// ending in /. "." and ".." segments aren't allowed.
+var bucketPrefixRe = regexp.MustCompile(`^(?:[A-Za-z0-9_-][A-Za-z0-9._-]*/)*$`)
+
+// bucketPrefix returns the env's key prefix, ending in / unless empty.
+func bucketPrefix(ec envConfig) string {
+	p := strings.Trim(ec.Prefix, "/")
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
+
+// bucketKey returns key under the env's prefix.
+func bucketKey(ec envConfig, key string) string {
+	return bucketPrefix(ec) + key
+}
+
+// validateBucketPrefixes rejects static envs sharing a bucket unless their
+// prefixes are disjoint: if one prefix contains another, a sync of one env
+// would delete the other's files. Buckets on different storage endpoints
+// are different buckets.
+func validateBucketPrefixes(cfg config) error {
+	type user struct{ name, prefix string }
+	byBucket := map[string][]user{}
+	for _, name := range sortedServiceNames(cfg) {
+		svc := cfg.Services[name]
+		if svc.Type != "static" {
+			continue
+		}
+		envs := make([]string, 0, len(svc.Env))
+		for env := range svc.Env {
+			envs = append(envs, env)
+		}
+		sort.Strings(envs)
+		for _, env := range envs {
+			ec := svc.Env[env]
+			u := user{name: fmt.Sprintf("service %q env %q", name, env), prefix: bucketPrefix(ec)}
+			bucket := svc.Storage.Endpoint + " " + ec.Bucket
+			for _, other := range byBucket[bucket] {
+				if strings.HasPrefix(u.prefix, other.prefix) || strings.HasPrefix(other.prefix, u.prefix) {
+					return fmt.Errorf("%s: bucket %s prefix %q overlaps %s prefix %q; give each a distinct prefix",
+						u.name, ec.Bucket, u.prefix, other.name, other.prefix)
+				}
+			}
+			byBucket[bucket] = append(byBucket[bucket], u)
+		}
+	}
+	return nil
+}
+
+func validateSmokeCheck(c smokeCheck) error {
+	if !isHTTPURL(c.URL) {
+		return fmt.Errorf("url must be an http or https URL, got %q", c.URL)
+	}
+	if c.Status != 0 && (c.Status < 100 || c.Status > 599) {
+		return fmt.Errorf("invalid status %d", c.Status)
+	}
+	if c.Host != "" {
+		if u, err := url.Parse("//" + c.Host); err != nil || u.Host != c.Host || u.Hostname() == "" {
+			return fmt.Errorf("invalid host %q (want a name or name:port)", c.Host)
+		}
+	}
+	for name := range c.Headers {
+		if name == "" || strings.ContainsAny(name, " :\t") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.EqualFold(name, "host") {
+			return fmt.Errorf("set the Host header with host, not headers")
+		}
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// purgeType returns how an env's CDN cache is purged.
+func purgeType(ec envConfig) string {
+	if ec.Purge.Type == "" {
+		return "cloudfront"
+	}
+	return ec.Purge.Type
+}
+
+var credentialHelperRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func validateRegistry(rc registryConfig) error {
+	sources := 0
+	for _, s := range []string{rc.Password, rc.PasswordEnv, rc.CredentialHelper} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("set exactly one of password, password_env or credential_helper")
+	}
+	if rc.CredentialHelper != "" {
+		if !credentialHelperRe.MatchString(rc.CredentialHelper) {
+			return fmt.Errorf("invalid credential_helper %q", rc.CredentialHelper)
+		}
+		return nil
+	}
+	if rc.Username == "" {
+		return fmt.Errorf("missing username")
+	}
+	return nil
+}
+
+var headerNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+func validateMetadataRule(r metadataRule) error {
+	if r.Match == "" {
+		return fmt.Errorf("missing match")
+	}
+	if _, err := globRegexp(r.Match); err != nil {
+		return fmt.Errorf("invalid match: %w", err)
+	}
+	if r.CacheControl == "" && r.ContentType == "" && r.ContentEncoding == "" && len(r.Headers) == 0 {
+		return fmt.Errorf("rule for %q sets nothing", r.Match)
+	}
+	for name := range r.Headers {
+		if !headerNameRe.MatchString(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		switch strings.ToLower(name) {
+		case "cache-control", "content-type", "content-encoding":
+			return fmt.Errorf("set %s with its own field, not headers", name)
+		}
+	}
+	return nil
+}
+
+func validateProxy(pc proxyConfig) error {
+	switch pc.Type {
+	case "", "traefik", "caddy":
+		if pc.ConfDir != "" || pc.Reload != "" {
+			return fmt.Errorf("conf_dir and reload only apply to nginx")
+		}
+		if pc.Container != "" && pc.Type != "caddy" {
+			return fmt.Errorf("container only applies to caddy")
+		}
+		if pc.Container != "" && !networkNameRe.MatchString(pc.Container) {
+			return fmt.Errorf("invalid container name %q", pc.Container)
+		}
+		if pc.Throttle != 0 && pc.Type == "caddy" {
+			return fmt.Errorf("throttle only applies to traefik")
+		}
+	case "nginx":
+		if pc.Throttle != 0 {
+			return fmt.Errorf("throttle only applies to traefik")
+		}
+		if pc.Container != "" {
+			return fmt.Errorf("container only applies to caddy")
+		}
+		if pc.ConfDir != "" && !strings.HasPrefix(pc.ConfDir, "/") {
+			return fmt.Errorf("conf_dir must be an absolute path")
+		}
+	default:
+		return fmt.Errorf("unknown type %q (must be \"traefik\", \"caddy\" or \"nginx\")", pc.Type)
+	}
+	return nil
+}
+
+var (
+	hostnameRe       = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+	entryPointRe     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+	middlewareNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	middlewareRefRe  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*(@[a-z]+)?$`)
+	middlewareOptRe  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9.-]*$`)
+)
+
+var middlewareTypes = map[string]bool{
+	"addprefix": true, "basicauth": true, "buffering": true, "chain": true,
+	"circuitbreaker": true, "compress": true, "digestauth": true, "errors": true,
+	"forwardauth": true, "headers": true, "ipallowlist": true, "ipwhitelist": true,
+	"inflightreq": true, "passtlsclientcert": true, "ratelimit": true,
+	"redirectregex": true, "redirectscheme": true, "replacepath": true,
+	"replacepathregex": true, "retry": true, "stripprefix": true, "stripprefixregex": true,
+}
+
+func validateRouting(rc routingConfig, proxyType string) error {
+	for _, h := range rc.Hosts {
+		if !hostnameRe.MatchString(h) {
+			return fmt.Errorf("invalid host %q", h)
+		}
+	}
+	if rc.PathPrefix != "" && (!strings.HasPrefix(rc.PathPrefix, "/") || strings.ContainsAny(rc.PathPrefix, "` ")) {
+		return fmt.Errorf("invalid path_prefix %q (want e.g. /api)", rc.PathPrefix)
+	}
+
+	traefikOnly := len(rc.EntryPoints) > 0 || rc.TLS || rc.CertResolver != "" || len(rc.Middlewares) > 0
+	switch proxyType {
+	case "", "traefik":
+	case "caddy":
+		if traefikOnly {
+			return fmt.Errorf("entrypoints, tls, certresolver and middlewares are only supported with traefik")
+		}
+	case "nginx":
+		if len(rc.Hosts) > 0 || rc.PathPrefix != "" || traefikOnly {
+			return fmt.Errorf("routing for nginx nodes is managed in the nginx server blocks")
+		}
+	}
+
+	for _, ep := range rc.EntryPoints {
+		if !entryPointRe.MatchString(ep) {
+			return fmt.Errorf("invalid entrypoint %q", ep)
+		}
+	}
+	if rc.CertResolver != "" && !entryPointRe.MatchString(rc.CertResolver) {
+		return fmt.Errorf("invalid certresolver %q", rc.CertResolver)
+	}
+
+	seen := map[string]bool{}
+	for i, m := range rc.Middlewares {
+		if m.Ref != "" {
+			if m.Name != "" || m.Type != "" || len(m.Options) > 0 {
+				return fmt.Errorf("middlewares[%d]: ref cannot be combined with name, type or options", i)
+			}
+			if !middlewareRefRe.MatchString(m.Ref) {
+				return fmt.Errorf("middlewares[%d]: invalid ref %q", i, m.Ref)
 			}
+			continue
+		}
+		if !middlewareNameRe.MatchString(m.Name) {
+			return fmt.Errorf("middlewares[%d]: invalid or missing name %q", i, m.Name)
+		}
+		if seen[m.Name] {
+			return fmt.Errorf("middlewares[%d]: duplicate name %q", i, m.Name)
+		}
+		seen[m.Name] = true
+		if !middlewareTypes[m.Type] {
+			return fmt.Errorf("middleware %q: unknown type %q", m.Name, m.Type)
 		}
+		for k := range m.Options {
+			if !middlewareOptRe.MatchString(k) {
+				return fmt.Errorf("middleware %q: invalid option %q", m.Name, k)
+			}
+		}
+	}
+	return nil
+}
+
+var stopSignalRe = regexp.MustCompile(`^[A-Z0-9+]+$`)
+
+func validateDrain(d drainConfig) error {
+	if d.Period < 0 {
+		return fmt.Errorf("drain period must not be negative")
+	}
+	if d.StopTimeout < 0 {
+		return fmt.Errorf("drain stop_timeout must not be negative")
+	}
+	if d.StopSignal != "" && !stopSignalRe.MatchString(d.StopSignal) {
+		return fmt.Errorf("drain stop_signal %q is not a valid signal name", d.StopSignal)
+	}
+	return nil
+}
+
+// mergeContainer applies env-level overrides on top of service-level
+// container options. Scalars and lists are replaced when set; maps are merged
+// key by key.
+func mergeContainer(base, override containerConfig) containerConfig {
+	c := base
+	if override.Volumes != nil {
+		c.Volumes = override.Volumes
+	}
+	if override.Networks != nil {
+		c.Networks = override.Networks
 	}
+	if override.CPUs != "" {
+		c.CPUs = override.CPUs
+	}
+	if override.Memory != "" {
+		c.Memory = override.Memory
+	}
+	if override.User != "" {
+		c.User = override.User
+	}
+	if override.Entrypoint != "" {
+		c.Entrypoint = override.Entrypoint
+	}
+	if override.Command != nil {
+		c.Command = override.Command
+	}
+	if override.Init != nil {
+		c.Init = override.Init
+	}
+	c.Ulimits = mergeMaps(base.Ulimits, override.Ulimits)
+	c.Env = mergeMaps(base.Env, override.Env)
+	c.Labels = mergeMaps(base.Labels, override.Labels)
+	return c
+}
 
+func mergeMaps(base, override map[string]string) map[string]string {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		m[k] = v
+	}
+	for k, v := range override {
+		m[k] = v
+	}
+	return m
+}
+
+var hookNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func validateHooks(h hooksConfig) error {
+	seen := map[string]bool{}
+	check := func(kind string, hooks []hookConfig) error {
+		for i, hook := range hooks {
+			if !hookNameRe.MatchString(hook.Name) {
+				return fmt.Errorf("hooks.%s[%d]: invalid or missing name %q", kind, i, hook.Name)
+			}
+			if seen[hook.Name] {
+				return fmt.Errorf("hooks.%s[%d]: duplicate hook name %q", kind, i, hook.Name)
+			}
+			seen[hook.Name] = true
+			if len(hook.Command) == 0 {
+				return fmt.Errorf("hook %q: missing command", hook.Name)
+			}
+			if hook.Timeout < 0 {
+				return fmt.Errorf("hook %q: timeout must not be negative", hook.Name)
+			}
+		}
+		return nil
+	}
+	if err := check("pre_deploy", h.PreDeploy); err != nil {
+		return err
+	}
+	for _, hook := range h.PreDeploy {
+		if hook.Exec {
+			return fmt.Errorf("hook %q: exec is only supported for post_deploy hooks", hook.Name)
+		}
+	}
+	return check("post_deploy", h.PostDeploy)
+}
+
+var (
+	envKeyRe      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	networkNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	memoryRe      = regexp.MustCompile(`^[0-9]+[bkmgBKMG]?$`)
+	ulimitNameRe  = regexp.MustCompile(`^[a-z]+$`)
+	ulimitValueRe = regexp.MustCompile(`^-?[0-9]+(:-?[0-9]+)?$`)
+)
+
+func validateContainer(c containerConfig) error {
+	for _, v := range c.Volumes {
+		parts := strings.Split(v, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || !strings.HasPrefix(parts[1], "/") {
+			return fmt.Errorf("invalid volume %q (want source:/target[:options])", v)
+		}
+	}
+	for _, n := range c.Networks {
+		if !networkNameRe.MatchString(n) {
+			return fmt.Errorf("invalid network name %q", n)
+		}
+	}
+	if c.CPUs != "" {
+		if f, err := strconv.ParseFloat(c.CPUs, 64); err != nil || f <= 0 {
+			return fmt.Errorf("invalid cpus %q (want a positive number)", c.CPUs)
+		}
+	}
+	if c.Memory != "" && !memoryRe.MatchString(c.Memory) {
+		return fmt.Errorf("invalid memory %q (want e.g. 512m or 2g)", c.Memory)
+	}
+	for name, value := range c.Ulimits {
+		if !ulimitNameRe.MatchString(name) {
+			return fmt.Errorf("invalid ulimit name %q", name)
+		}
+		if !ulimitValueRe.MatchString(value) {
+			return fmt.Errorf("invalid ulimit %s value %q (want soft[:hard])", name, value)
+		}
+	}
+	for k := range c.Env {
+		if !envKeyRe.MatchString(k) {
+			return fmt.Errorf("invalid env var name %q", k)
+		}
+	}
+	for k := range c.Labels {
+		if k == "" {
+			return fmt.Errorf("empty label name")
+		}
+		if strings.HasPrefix(k, "hoist.") {
+			return fmt.Errorf("label %q uses the reserved hoist. prefix", k)
+		}
+	}
 	return nil
 }
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -388,6 +390,596 @@ services:
 	}
 }
 
+func TestLoadConfigDrain(t *testing.T) {
+	yaml := `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  api:
+    type: server
+    image: api:latest
+    port: 8080
+    healthcheck: /health
+    drain:
+      period: 30s
+      stop_timeout: 1m
+      stop_signal: SIGQUIT
+    env:
+      prod:
+        node: n1
+        host: api.com
+        envfile: .env
+`
+	cfg, err := loadConfig(writeTemp(t, yaml))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := drainConfig{Period: 30 * time.Second, StopTimeout: time.Minute, StopSignal: "SIGQUIT"}
+	if diff := cmp.Diff(want, cfg.Services["api"].Drain); diff != "" {
+		t.Errorf("drain mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadConfigDrainCaddy(t *testing.T) {
+	yaml := `
+project: test
+nodes:
+  n1: 10.0.0.1
+proxies:
+  n1:
+    type: caddy
+services:
+  api:
+    type: server
+    image: api:latest
+    port: 8080
+    healthcheck: /health
+    drain:
+      DRAIN
+    env:
+      prod:
+        node: n1
+        host: api.com
+        envfile: .env
+`
+	// Caddy drains through its admin API.
+	if _, err := loadConfig(writeTemp(t, strings.Replace(yaml, "DRAIN", "period: 30s", 1))); err != nil {
+		t.Errorf("period on a caddy node: unexpected error: %v", err)
+	}
+	if _, err := loadConfig(writeTemp(t, strings.Replace(yaml, "DRAIN", "stop_timeout: 30s", 1))); err != nil {
+		t.Errorf("stop_timeout on a caddy node: unexpected error: %v", err)
+	}
+}
+
+func TestLoadConfigInvalidDrainSignal(t *testing.T) {
+	yaml := `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  api:
+    type: server
+    image: api:latest
+    port: 8080
+    healthcheck: /health
+    drain:
+      stop_signal: "TERM; rm -rf /"
+    env:
+      prod:
+        node: n1
+        host: api.com
+        envfile: .env
+`
+	_, err := loadConfig(writeTemp(t, yaml))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "not a valid signal name") {
+		t.Errorf("error = %q, want it to contain %q", err.Error(), "not a valid signal name")
+	}
+}
+
+func TestLoadConfigContainerEnvOverride(t *testing.T) {
+	yaml := `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  api:
+    type: server
+    image: api:latest
+    port: 8080
+    healthcheck: /health
+    container:
+      memory: 512m
+      init: true
+      volumes: ["/srv/data:/data"]
+      env:
+        LOG_LEVEL: info
+        REGION: eu
+    env:
+      prod:
+        node: n1
+        host: api.com
+        envfile: .env
+        container:
+          memory: 2g
+          env:
+            LOG_LEVEL: warn
+`
+	cfg, err := loadConfig(writeTemp(t, yaml))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	svc := cfg.Services["api"]
+	got := mergeContainer(svc.Container, svc.Env["prod"].Container)
+	init := true
+	want := containerConfig{
+		Volumes: []string{"/srv/data:/data"},
+		Memory:  "2g",
+		Env:     map[string]string{"LOG_LEVEL": "warn", "REGION": "eu"},
+		Init:    &init,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("merged container mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateContainer(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       containerConfig
+		wantErr string
+	}{
+		{"valid", containerConfig{Volumes: []string{"data:/data:ro"}, CPUs: "1.5", Memory: "512m", Ulimits: map[string]string{"nofile": "1024:4096"}}, ""},
+		{"relative volume target", containerConfig{Volumes: []string{"/srv:data"}}, "invalid volume"},
+		{"bad network", containerConfig{Networks: []string{"net; reboot"}}, "invalid network name"},
+		{"zero cpus", containerConfig{CPUs: "0"}, "invalid cpus"},
+		{"bad memory", containerConfig{Memory: "lots"}, "invalid memory"},
+		{"bad ulimit", containerConfig{Ulimits: map[string]string{"nofile": "many"}}, "invalid ulimit"},
+		{"bad env name", containerConfig{Env: map[string]string{"1BAD": "x"}}, "invalid env var name"},
+		{"reserved label", containerConfig{Labels: map[string]string{"hoist.previous": "x"}}, "reserved hoist. prefix"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateContainer(tt.c)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		lc      logConfig
+		wantErr string
+	}{
+		{"default driver", logConfig{}, ""},
+		{"json-file", logConfig{Driver: "json-file", Options: map[string]string{"max-size": "10m"}}, ""},
+		{"awslogs template", logConfig{Options: map[string]string{"awslogs-stream": "${service}-${tag}"}}, ""},
+		{"unknown driver", logConfig{Driver: "splunkish"}, "unknown driver"},
+		{"unknown placeholder", logConfig{Options: map[string]string{"awslogs-stream": "${host}"}}, "unknown placeholder"},
+		{"bad option name", logConfig{Options: map[string]string{"a=b": "x"}}, "invalid option name"},
+		{"loki without url", logConfig{Driver: "loki"}, "requires the loki-url option"},
+		{"none with options", logConfig{Driver: "none", Options: map[string]string{"x": "y"}}, "takes no options"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLog(tt.lc)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergeLog(t *testing.T) {
+	base := logConfig{Options: map[string]string{"awslogs-region": "eu-west-1"}}
+
+	same := mergeLog(base, logConfig{Driver: "awslogs", Options: map[string]string{"awslogs-stream": "x"}})
+	if same.Options["awslogs-region"] != "eu-west-1" || same.Options["awslogs-stream"] != "x" {
+		t.Errorf("same driver: options = %v, want merged", same.Options)
+	}
+
+	switched := mergeLog(base, logConfig{Driver: "json-file"})
+	if switched.Driver != "json-file" || len(switched.Options) != 0 {
+		t.Errorf("switched driver = %+v, want json-file without awslogs options", switched)
+	}
+}
+
+func TestValidateHooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       hooksConfig
+		wantErr string
+	}{
+		{"valid", hooksConfig{
+			PreDeploy:  []hookConfig{{Name: "migrate", Command: []string{"migrate"}}},
+			PostDeploy: []hookConfig{{Name: "warm", Command: []string{"warm"}, Exec: true}},
+		}, ""},
+		{"missing name", hooksConfig{PreDeploy: []hookConfig{{Command: []string{"migrate"}}}}, "invalid or missing name"},
+		{"missing command", hooksConfig{PostDeploy: []hookConfig{{Name: "warm"}}}, "missing command"},
+		{"duplicate name", hooksConfig{
+			PreDeploy:  []hookConfig{{Name: "x", Command: []string{"a"}}},
+			PostDeploy: []hookConfig{{Name: "x", Command: []string{"b"}}},
+		}, "duplicate hook name"},
+		{"exec pre-deploy", hooksConfig{PreDeploy: []hookConfig{{Name: "migrate", Command: []string{"migrate"}, Exec: true}}}, "only supported for post_deploy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHooks(tt.h)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigProxies(t *testing.T) {
+	base := `
+project: test
+nodes:
+  n1: 10.0.0.1
+  n2: 10.0.0.2
+services:
+  api:
+    type: server
+    image: api
+    port: 8080
+    healthcheck: /health
+    container:
+      networks: [web]
+    env:
+      prod:
+        node: n1
+        host: api.example.com
+        envfile: .env
+`
+	cfg, err := loadConfig(writeTemp(t, base+`proxies:
+  n1:
+    type: nginx
+    conf_dir: /etc/nginx/sites
+  n2:
+    type: caddy
+    container: caddy-proxy
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.Proxies["n1"]; got.Type != "nginx" || got.ConfDir != "/etc/nginx/sites" {
+		t.Errorf("proxies[n1] = %+v", got)
+	}
+	if got := cfg.Proxies["n2"]; got.Container != "caddy-proxy" {
+		t.Errorf("proxies[n2] = %+v", got)
+	}
+
+	tests := []struct {
+		name    string
+		proxies string
+		wantErr string
+	}{
+		{"unknown node", "proxies:\n  n9:\n    type: caddy\n", "not defined in nodes"},
+		{"unknown type", "proxies:\n  n1:\n    type: haproxy\n", "unknown type"},
+		{"relative conf_dir", "proxies:\n  n1:\n    type: nginx\n    conf_dir: nginx\n", "absolute path"},
+		{"reload on traefik", "proxies:\n  n1:\n    reload: nginx -s reload\n", "only apply to nginx"},
+		{"throttle on nginx", "proxies:\n  n1:\n    type: nginx\n    throttle: 5s\n", "only applies to traefik"},
+		{"container on traefik", "proxies:\n  n1:\n    container: caddy\n", "only applies to caddy"},
+		{"invalid container", "proxies:\n  n1:\n    type: caddy\n    container: 'a b'\n", "invalid container name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeTemp(t, base+tt.proxies))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// nginx reaches containers by name, which needs a shared network.
+	noNetwork := strings.Replace(base, "    container:\n      networks: [web]\n", "", 1)
+	_, err = loadConfig(writeTemp(t, noNetwork+"proxies:\n  n1:\n    type: nginx\n"))
+	if err == nil || !strings.Contains(err.Error(), "container.networks is required on nginx nodes") {
+		t.Errorf("error = %v, want networks required on nginx", err)
+	}
+}
+
+func TestValidateRouting(t *testing.T) {
+	tests := []struct {
+		name    string
+		rc      routingConfig
+		proxy   string
+		wantErr string
+	}{
+		{"valid traefik", routingConfig{
+			Hosts: []string{"a.example.com", "*.example.com"}, PathPrefix: "/api", CertResolver: "le",
+			Middlewares: []middlewareConfig{{Name: "auth", Type: "basicauth", Options: map[string]string{"users": "u:p"}}, {Ref: "hsts@file"}},
+		}, "", ""},
+		{"bad host", routingConfig{Hosts: []string{"a.com`) || Host(`b.com"}}, "", "invalid host"},
+		{"relative prefix", routingConfig{PathPrefix: "api"}, "", "invalid path_prefix"},
+		{"unknown middleware type", routingConfig{Middlewares: []middlewareConfig{{Name: "x", Type: "magic"}}}, "", "unknown type"},
+		{"duplicate middleware", routingConfig{Middlewares: []middlewareConfig{{Name: "x", Type: "compress"}, {Name: "x", Type: "compress"}}}, "", "duplicate name"},
+		{"ref with type", routingConfig{Middlewares: []middlewareConfig{{Ref: "a@file", Type: "compress"}}}, "", "ref cannot be combined"},
+		{"header option", routingConfig{Middlewares: []middlewareConfig{
+			{Name: "headers", Type: "headers", Options: map[string]string{"customResponseHeaders.X-Frame-Options": "DENY"}},
+		}}, "", ""},
+		{"bad option", routingConfig{Middlewares: []middlewareConfig{{Name: "x", Type: "headers", Options: map[string]string{"-x=y": "z"}}}}, "", "invalid option"},
+		{"caddy hosts", routingConfig{Hosts: []string{"a.com"}, PathPrefix: "/api"}, "caddy", ""},
+		{"caddy certresolver", routingConfig{CertResolver: "le"}, "caddy", "only supported with traefik"},
+		{"nginx hosts", routingConfig{Hosts: []string{"a.com"}}, "nginx", "nginx server blocks"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRouting(tt.rc, tt.proxy)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRoutingHosts(t *testing.T) {
+	yaml := `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  api:
+    type: server
+    image: api
+    port: 8080
+    healthcheck: /health
+    env:
+      prod:
+        node: n1
+        envfile: .env
+        routing:
+          hosts: [api.example.com, www.example.com]
+          certresolver: le
+`
+	cfg, err := loadConfig(writeTemp(t, yaml))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.Services["api"].Env["prod"].Routing.Hosts; len(got) != 2 {
+		t.Errorf("routing.hosts = %v", got)
+	}
+
+	both := strings.Replace(yaml, "envfile: .env", "envfile: .env\n        host: api.example.com", 1)
+	if _, err := loadConfig(writeTemp(t, both)); err == nil || !strings.Contains(err.Error(), "not both") {
+		t.Errorf("expected host/routing.hosts conflict, got %v", err)
+	}
+}
+
+func TestLoadConfigPrune(t *testing.T) {
+	base := `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  jobs:
+    type: worker
+    image: jobs
+    prune:
+      keep: KEEP
+      after_deploy: true
+    env:
+      prod:
+        node: n1
+        envfile: .env
+`
+	cfg, err := loadConfig(writeTemp(t, strings.Replace(base, "KEEP", "0", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := cfg.Services["jobs"].Prune; p.Keep == nil || *p.Keep != 0 || !p.AfterDeploy {
+		t.Errorf("prune = %+v", p)
+	}
+
+	if _, err := loadConfig(writeTemp(t, strings.Replace(base, "KEEP", "-1", 1))); err == nil || !strings.Contains(err.Error(), "must not be negative") {
+		t.Errorf("expected negative keep error, got %v", err)
+	}
+}
+
+func TestLoadConfigWorker(t *testing.T) {
+	yaml := `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  jobs:
+    type: worker
+    image: jobs:latest
+    stable_for: 15s
+    env:
+      prod:
+        node: n1
+        envfile: .env
+`
+	cfg, err := loadConfig(writeTemp(t, yaml))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.Services["jobs"].StableFor; got != 15*time.Second {
+		t.Errorf("stable_for = %s, want 15s", got)
+	}
+}
+
+func TestLoadConfigWorkerDrainPeriod(t *testing.T) {
+	yaml := `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  jobs:
+    type: worker
+    image: jobs:latest
+    drain:
+      period: 30s
+    env:
+      prod:
+        node: n1
+        envfile: .env
+`
+	_, err := loadConfig(writeTemp(t, yaml))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "not supported for workers") {
+		t.Errorf("error = %q, want it to contain %q", err.Error(), "not supported for workers")
+	}
+}
+
+func TestLoadConfigCompose(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			yaml: `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  stack:
+    type: compose
+    image: stack
+    compose_file: docker-compose.yml
+    env:
+      prod:
+        node: n1
+`,
+		},
+		{
+			name: "missing compose_file",
+			yaml: `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  stack:
+    type: compose
+    image: stack
+    env:
+      prod:
+        node: n1
+`,
+			wantErr: "missing compose_file",
+		},
+		{
+			name: "missing node",
+			yaml: `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  stack:
+    type: compose
+    image: stack
+    compose_file: docker-compose.yml
+    env:
+      prod: {}
+`,
+			wantErr: "missing node",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeTemp(t, tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigBinary(t *testing.T) {
+	base := `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  daemon:
+    type: binary
+    unit: daemon.service
+    binary: daemon
+%s
+    env:
+      prod:
+        node: n1
+%s
+`
+	tests := []struct {
+		name    string
+		svc     string
+		env     string
+		wantErr string
+	}{
+		{"s3 artifact", "    artifact: {bucket: artifacts}", "", ""},
+		{"local artifact with healthcheck", "    artifact: {dir: ./dist}\n    port: 9000\n    healthcheck: /health", "        dir: /srv/daemon", ""},
+		{"no artifact source", "", "", "exactly one of bucket or dir"},
+		{"both artifact sources", "    artifact: {bucket: a, dir: b}", "", "exactly one of bucket or dir"},
+		{"port without healthcheck", "    artifact: {bucket: a}\n    port: 9000", "", "port and healthcheck must be set together"},
+		{"relative dir", "    artifact: {bucket: a}", "        dir: srv/daemon", "dir must be an absolute path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeTemp(t, fmt.Sprintf(base, tt.svc, tt.env)))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestLoadConfigFileNotFound(t *testing.T) {
 	_, err := loadConfig("/nonexistent/path/hoist.yml")
 	if err == nil {
@@ -406,3 +998,362 @@ services:
 		t.Fatal("expected error, got nil")
 	}
 }
+
+func TestLoadConfigRegistries(t *testing.T) {
+	base := `
+project: test
+nodes:
+  n1: 10.0.0.1
+registries:
+  ghcr.io:
+REGISTRY
+services:
+  jobs:
+    type: worker
+    image: ghcr.io/acme/jobs
+    env:
+      prod:
+        node: n1
+        envfile: .env
+`
+	cfg, err := loadConfig(writeTemp(t, strings.Replace(base, "REGISTRY", "    username: deploy\n    password_env: GHCR_TOKEN", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rc := cfg.Registries["ghcr.io"]; rc.Username != "deploy" || rc.PasswordEnv != "GHCR_TOKEN" {
+		t.Errorf("registry = %+v", rc)
+	}
+
+	if _, err := loadConfig(writeTemp(t, strings.Replace(base, "REGISTRY", "    credential_helper: osxkeychain", 1))); err != nil {
+		t.Errorf("credential helper without username: unexpected error: %v", err)
+	}
+
+	bad := map[string]string{
+		"no source":   "    username: deploy",
+		"two sources": "    username: deploy\n    password: x\n    password_env: Y",
+		"no username": "    password_env: GHCR_TOKEN",
+		"bad helper":  "    credential_helper: ../evil",
+	}
+	for name, reg := range bad {
+		if _, err := loadConfig(writeTemp(t, strings.Replace(base, "REGISTRY", reg, 1))); err == nil || !strings.Contains(err.Error(), "registries: ghcr.io") {
+			t.Errorf("%s: expected registry error, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfigStaticStrategy(t *testing.T) {
+	base := `
+project: test
+services:
+  site:
+    type: static
+    env:
+      prod:
+        bucket: site-prod
+        cloudfront: E123
+EXTRA
+`
+	cfg, err := loadConfig(writeTemp(t, strings.Replace(base, "EXTRA", "        strategy: origin_path\n        origin_id: s3-site", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ec := cfg.Services["site"].Env["prod"]; ec.Strategy != "origin_path" || ec.OriginID != "s3-site" {
+		t.Errorf("env = %+v", ec)
+	}
+	cfg, err = loadConfig(writeTemp(t, strings.Replace(base, "EXTRA", "        invalidation_max_paths: 30", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := invalidationMaxPaths(cfg.Services["site"].Env["prod"]); n != 30 {
+		t.Errorf("invalidationMaxPaths = %d, want 30", n)
+	}
+
+	bad := map[string]string{
+		"unknown strategy":    "        strategy: rsync",
+		"origin_id with copy": "        origin_id: s3-site",
+		"grace with origin":   "        strategy: origin_path\n        delete_grace: 1h",
+		"negative max paths":  "        invalidation_max_paths: -1",
+		"negative timeout":    "        invalidation_timeout: -1m",
+	}
+	for name, extra := range bad {
+		if _, err := loadConfig(writeTemp(t, strings.Replace(base, "EXTRA", extra, 1))); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoadConfigManifest(t *testing.T) {
+	base := `
+project: test
+services:
+  SERVICE
+    manifest: MODE
+    env:
+      prod:
+        bucket: site-prod
+        cloudfront: E123
+`
+	load := func(service, mode string) (config, error) {
+		yml := strings.Replace(strings.Replace(base, "SERVICE", service, 1), "MODE", mode, 1)
+		return loadConfig(writeTemp(t, yml))
+	}
+	cfg, err := load("site:\n    type: static", "required")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Services["site"].Manifest != "required" {
+		t.Errorf("manifest = %q, want required", cfg.Services["site"].Manifest)
+	}
+	if _, err := load("site:\n    type: static", "always"); err == nil {
+		t.Error("expected error for unknown manifest mode")
+	}
+	_, err = loadConfig(writeTemp(t, `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  api:
+    type: server
+    image: api:latest
+    port: 8080
+    healthcheck: /health
+    manifest: required
+    env:
+      prod:
+        node: n1
+        host: api.com
+        envfile: .env
+`))
+	if err == nil || !strings.Contains(err.Error(), "manifest is only supported for static services") {
+		t.Errorf("err = %v, want manifest rejected on a server service", err)
+	}
+}
+
+func TestLoadConfigStaticStorageAndPurge(t *testing.T) {
+	base := `
+project: test
+services:
+  site:
+    type: static
+STORAGE
+    env:
+      prod:
+        bucket: site-prod
+ENV
+`
+	load := func(storage, env string) (config, error) {
+		yml := strings.Replace(strings.Replace(base, "STORAGE", storage, 1), "ENV", env, 1)
+		return loadConfig(writeTemp(t, yml))
+	}
+
+	cfg, err := load(`    storage:
+      endpoint: https://acct.r2.cloudflarestorage.com
+      region: auto
+      profile: r2
+      path_style: true`, `        purge:
+          type: webhook
+          url: https://purge.example.com/hook
+          headers:
+            Authorization: Bearer ${TOKEN}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	svc := cfg.Services["site"]
+	if svc.Storage != (storageConfig{Endpoint: "https://acct.r2.cloudflarestorage.com", Region: "auto", Profile: "r2", PathStyle: true}) {
+		t.Errorf("storage = %+v", svc.Storage)
+	}
+	if ec := svc.Env["prod"]; purgeType(ec) != "webhook" || ec.Purge.Headers["Authorization"] != "Bearer ${TOKEN}" {
+		t.Errorf("purge = %+v", ec.Purge)
+	}
+
+	cfg, err = load("", "        cloudfront: E123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if purgeType(cfg.Services["site"].Env["prod"]) != "cloudfront" {
+		t.Error("purge type should default to cloudfront")
+	}
+	if _, err := load("", "        purge:\n          type: none"); err != nil {
+		t.Errorf("purge none without cloudfront: unexpected error: %v", err)
+	}
+
+	bad := map[string][2]string{
+		"bad endpoint":         {"    storage:\n      endpoint: minio:9000", "        cloudfront: E123"},
+		"missing cloudfront":   {"", ""},
+		"unknown purge":        {"", "        purge:\n          type: fastly"},
+		"webhook without url":  {"", "        purge:\n          type: webhook"},
+		"cloudfront with none": {"", "        cloudfront: E123\n        purge:\n          type: none"},
+		"url with cloudfront":  {"", "        cloudfront: E123\n        purge:\n          url: https://x.example.com"},
+		"origin_path no cf":    {"", "        strategy: origin_path\n        purge:\n          type: none"},
+	}
+	for name, c := range bad {
+		if _, err := load(c[0], c[1]); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoadConfigMetadataRules(t *testing.T) {
+	base := `
+project: test
+services:
+  site:
+    type: static
+    metadata:
+RULES
+    env:
+      prod:
+        bucket: site-prod
+        cloudfront: E123
+`
+	cfg, err := loadConfig(writeTemp(t, strings.Replace(base, "RULES", "      - match: \"assets/**\"\n        cache_control: \"public, max-age=31536000, immutable\"\n      - match: \"*.html\"\n        headers:\n          X-Robots-Tag: noindex", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rules := cfg.Services["site"].Metadata; len(rules) != 2 || rules[1].Headers["X-Robots-Tag"] != "noindex" {
+		t.Errorf("metadata = %+v", rules)
+	}
+
+	bad := map[string]string{
+		"no match":     "      - cache_control: no-cache",
+		"sets nothing": "      - match: \"*.html\"",
+		"bad glob":     "      - match: \"*.{js\"\n        cache_control: no-cache",
+		"header field": "      - match: \"*.html\"\n        headers:\n          Cache-Control: no-cache",
+		"bad header":   "      - match: \"*.html\"\n        headers:\n          \"X Bad\": x",
+	}
+	for name, rules := range bad {
+		if _, err := loadConfig(writeTemp(t, strings.Replace(base, "RULES", rules, 1))); err == nil || !strings.Contains(err.Error(), "metadata[0]") {
+			t.Errorf("%s: expected metadata error, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfigBucketPrefix(t *testing.T) {
+	base := `
+project: test
+services:
+  site:
+    type: static
+    env:
+      prod:
+        bucket: shared
+        cloudfront: E1
+PROD
+      staging:
+        bucket: shared
+        cloudfront: E2
+STAGING
+  docs:
+    type: static
+    env:
+      prod:
+        bucket: shared
+        cloudfront: E3
+DOCS
+`
+	load := func(prod, staging, docs string) (config, error) {
+		yml := strings.NewReplacer("PROD", prod, "STAGING", staging, "DOCS", docs).Replace(base)
+		return loadConfig(writeTemp(t, yml))
+	}
+	prefix := func(p string) string { return "        prefix: " + p }
+
+	cfg, err := load(prefix("site/prod"), prefix("/site/staging/"), prefix("docs"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := bucketKey(cfg.Services["site"].Env["staging"], "current-tag"); got != "site/staging/current-tag" {
+		t.Errorf("staging current-tag key = %q", got)
+	}
+	if got := bucketKey(envConfig{}, "current-tag"); got != "current-tag" {
+		t.Errorf("unprefixed key = %q", got)
+	}
+
+	overlapping := map[string][3]string{
+		"same prefix":     {prefix("site"), prefix("site"), prefix("docs")},
+		"nested prefix":   {prefix("site"), prefix("site/staging"), prefix("docs")},
+		"no prefix":       {"", prefix("site/staging"), prefix("docs")},
+		"across services": {prefix("site/prod"), prefix("site/staging"), prefix("site")},
+	}
+	for name, c := range overlapping {
+		_, err := load(c[0], c[1], c[2])
+		if err == nil || !strings.Contains(err.Error(), "overlaps") {
+			t.Errorf("%s: err = %v, want overlap error", name, err)
+		}
+	}
+	// A prefix that merely starts with the same letters doesn't overlap.
+	if _, err := load(prefix("site"), prefix("site-staging"), prefix("docs")); err != nil {
+		t.Errorf("site and site-staging: unexpected error: %v", err)
+	}
+	for _, p := range []string{"../site", "site/./prod", "site//prod", "site prod"} {
+		_, err := load(prefix(`"`+p+`"`), prefix("staging"), prefix("docs"))
+		if err == nil || !strings.Contains(err.Error(), "invalid prefix") {
+			t.Errorf("prefix %q: err = %v, want invalid prefix", p, err)
+		}
+	}
+}
+
+func TestLoadConfigSmoke(t *testing.T) {
+	base := `
+project: test
+services:
+  site:
+    type: static
+    env:
+      prod:
+        bucket: site-prod
+        cloudfront: E123
+        smoke:
+SMOKE
+`
+	load := func(smoke string) (config, error) {
+		return loadConfig(writeTemp(t, strings.Replace(base, "SMOKE", smoke, 1)))
+	}
+
+	cfg, err := load(`          - url: https://example.com/
+            body: Welcome
+          - url: http://10.0.0.1/health
+            status: 204
+            host: example.com:8080
+            headers:
+              Authorization: Bearer ${TOKEN}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checks := cfg.Services["site"].Env["prod"].Smoke
+	if len(checks) != 2 || checks[0].Body != "Welcome" || checks[1].Status != 204 || checks[1].Host != "example.com:8080" ||
+		checks[1].Headers["Authorization"] != "Bearer ${TOKEN}" {
+		t.Errorf("smoke = %+v", checks)
+	}
+
+	bad := map[string]string{
+		"missing url":    "          - body: Welcome",
+		"relative url":   "          - url: /health",
+		"bad status":     "          - url: https://example.com/\n            status: 99",
+		"host with path": "          - url: https://example.com/\n            host: example.com/x",
+		"host header":    "          - url: https://example.com/\n            headers:\n              Host: example.com",
+	}
+	for name, smoke := range bad {
+		if _, err := load(smoke); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	_, err = loadConfig(writeTemp(t, `
+project: test
+nodes:
+  n1: 10.0.0.1
+services:
+  jobs:
+    type: worker
+    image: jobs:latest
+    env:
+      prod:
+        node: n1
+        envfile: .env
+        smoke:
+          - url: https://example.com/
+`))
+	if err == nil || !strings.Contains(err.Error(), "smoke is only supported for server and static services") {
+		t.Errorf("err = %v, want smoke rejected on a worker", err)
+	}
+}
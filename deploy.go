@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"sort"
 	"sync"
 	"time"
@@ -20,12 +21,14 @@ type build struct {
 	Time    time.Time
 	Message string
 	Author  string
+	Digest  string // image manifest digest, when builds are images
 }
 
 type deploy struct {
 	Service string
 	Env     string
 	Tag     string
+	Digest  string // digest the running image was pinned to, if recorded
 	Uptime  time.Duration
 }
 
@@ -43,7 +46,49 @@ type buildsProvider interface {
 }
 
 type deployer interface {
-	deploy(ctx context.Context, service, env, tag, oldTag string) error
+	deploy(ctx context.Context, service, env, tag, oldTag string, params deployParams) error
+}
+
+// deployParams are per-run settings for one service's deploy that don't come
+// from the config.
+type deployParams struct {
+	digest string // image digest to pull and run; "" means use the tag
+	noWait bool   // don't wait for work that finishes on its own, like CloudFront invalidations
+}
+
+// progressKey is the context key for a deploy's progress callback.
+type progressKey struct{}
+
+// withProgress returns a context whose deploys report progress to fn.
+func withProgress(ctx context.Context, fn func(detail string)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// reportProgress sends a short status line to the progress callback in ctx,
+// if any. Deployers call it between phases so the rollout UI can show more
+// than a spinner.
+func reportProgress(ctx context.Context, format string, args ...any) {
+	if fn, ok := ctx.Value(progressKey{}).(func(string)); ok {
+		fn(fmt.Sprintf(format, args...))
+	}
+}
+
+// summaryKey is the context key for a deploy's summary callback.
+type summaryKey struct{}
+
+// withSummary returns a context whose deploys report a one-line summary of
+// what they changed to fn.
+func withSummary(ctx context.Context, fn func(summary string)) context.Context {
+	return context.WithValue(ctx, summaryKey{}, fn)
+}
+
+// reportSummary sends a summary line, e.g. "3 added, 1 removed", to the
+// summary callback in ctx, if any. Unlike progress it stays visible once
+// the deploy is done.
+func reportSummary(ctx context.Context, format string, args ...any) {
+	if fn, ok := ctx.Value(summaryKey{}).(func(string)); ok {
+		fn(fmt.Sprintf(format, args...))
+	}
 }
 
 type historyProvider interface {
@@ -60,6 +105,8 @@ type providers struct {
 	deployers map[string]deployer
 	history   map[string]historyProvider
 	logs      map[string]logsProvider
+	preflight map[string]preflighter
+	smoke     *smokeTester // nil means the default client
 }
 
 type deployOpts struct {
@@ -68,6 +115,9 @@ type deployOpts struct {
 	Build    string
 	Tags     map[string]string // pre-resolved per-service tags (skips build select)
 	Yes      bool
+
+	SkipPreflight bool
+	NoWait        bool // don't wait for CloudFront invalidations or origin switches to complete
 }
 
 // deployResult holds the outcome of a parallel deploy.
@@ -155,7 +205,7 @@ func runDeploy(ctx context.Context, cfg config, p providers, opts deployOpts) er
 		}
 		previousTags = prevTags
 	} else {
-		bp := buildsForServices(cfg, p, services)
+		bp := buildsForServices(cfg, p, services, env)
 
 		var buildTag string
 		if opts.Build != "" {
@@ -196,6 +246,23 @@ func runDeploy(ctx context.Context, cfg config, p providers, opts deployOpts) er
 		}
 	}
 
+	if !opts.SkipPreflight {
+		if err := runPreflight(ctx, cfg, p, services, env, tags, os.Stdout); err != nil {
+			return err
+		}
+	}
+
+	// Pin digests now so a tag re-pushed while the user confirms is caught
+	// before anything is deployed.
+	pinned, err := resolveDigests(ctx, cfg, p, services, tags)
+	if err != nil {
+		return err
+	}
+	params := make(map[string]deployParams, len(services))
+	for _, svc := range services {
+		params[svc] = deployParams{digest: pinned[svc], noWait: opts.NoWait}
+	}
+
 	if !opts.Yes {
 		var changes []serviceChange
 		for _, svc := range services {
@@ -215,12 +282,12 @@ func runDeploy(ctx context.Context, cfg config, p providers, opts deployOpts) er
 		}
 	}
 
-	return deployAllWithUI(ctx, cfg, p, services, env, tags, previousTags)
+	return deployAllWithUI(ctx, cfg, p, services, env, tags, previousTags, params)
 }
 
 // deployAllWithUI runs parallel deploys with TUI progress display.
 // tags maps each service to the tag it should be deployed to.
-func deployAllWithUI(ctx context.Context, cfg config, p providers, services []string, env string, tags map[string]string, previousTags map[string]string) error {
+func deployAllWithUI(ctx context.Context, cfg config, p providers, services []string, env string, tags map[string]string, previousTags map[string]string, params map[string]deployParams) error {
 	deployCtx, cancelDeploy := context.WithCancel(ctx)
 	defer cancelDeploy()
 
@@ -233,7 +300,13 @@ func deployAllWithUI(ctx context.Context, cfg config, p providers, services []st
 		go func(svc string) {
 			defer wg.Done()
 			oldTag := previousTags[svc]
-			err := deployService(deployCtx, cfg, p, svc, env, tags[svc], oldTag)
+			svcCtx := withProgress(deployCtx, func(detail string) {
+				prog.Send(serviceProgressMsg{service: svc, detail: detail})
+			})
+			svcCtx = withSummary(svcCtx, func(summary string) {
+				prog.Send(serviceSummaryMsg{service: svc, summary: summary})
+			})
+			err := deployService(svcCtx, cfg, p, svc, env, tags[svc], oldTag, params[svc])
 			prog.Send(serviceStatusMsg{service: svc, err: err})
 		}(svc)
 	}
@@ -284,12 +357,14 @@ func deployAllWithUI(ctx context.Context, cfg config, p providers, services []st
 	}
 
 	var rollbackTargets []string
+	rollbackParams := make(map[string]deployParams, len(rollbackTags))
 	for svc := range rollbackTags {
 		rollbackTargets = append(rollbackTargets, svc)
+		rollbackParams[svc] = deployParams{noWait: params[svc].noWait}
 	}
 
 	fmt.Printf("Rolling back %d service(s)...\n", len(rollbackTargets))
-	result, err := deployAll(ctx, cfg, p, rollbackTargets, env, rollbackTags, tags)
+	result, err := deployAll(ctx, cfg, p, rollbackTargets, env, rollbackTags, tags, rollbackParams)
 	if err != nil {
 		return fmt.Errorf("rollback: %w", err)
 	}
@@ -302,7 +377,7 @@ func deployAllWithUI(ctx context.Context, cfg config, p providers, services []st
 
 // deployAll runs parallel deploys without TUI. Returns results for the caller to handle.
 // tags maps each service to the tag it should be deployed to.
-func deployAll(ctx context.Context, cfg config, p providers, services []string, env string, tags map[string]string, previousTags map[string]string) (deployResult, error) {
+func deployAll(ctx context.Context, cfg config, p providers, services []string, env string, tags map[string]string, previousTags map[string]string, params map[string]deployParams) (deployResult, error) {
 	type result struct {
 		service string
 		err     error
@@ -310,13 +385,16 @@ func deployAll(ctx context.Context, cfg config, p providers, services []string,
 
 	results := make(chan result, len(services))
 	var wg sync.WaitGroup
+	printer := &progressPrinter{w: os.Stdout}
 
 	for _, svc := range services {
 		wg.Add(1)
 		go func(svc string) {
 			defer wg.Done()
 			oldTag := previousTags[svc]
-			err := deployService(ctx, cfg, p, svc, env, tags[svc], oldTag)
+			svcCtx := withProgress(ctx, func(detail string) { printer.print(svc, detail) })
+			svcCtx = withSummary(svcCtx, func(summary string) { printer.print(svc, summary) })
+			err := deployService(svcCtx, cfg, p, svc, env, tags[svc], oldTag, params[svc])
 			results <- result{service: svc, err: err}
 		}(svc)
 	}
@@ -336,7 +414,9 @@ func deployAll(ctx context.Context, cfg config, p providers, services []string,
 	}, nil
 }
 
-func deployService(ctx context.Context, cfg config, p providers, service, env, tag, oldTag string) error {
+// deployService deploys one service. A digest in params is the one the user
+// confirmed; the deploy fails if the tag has moved since.
+func deployService(ctx context.Context, cfg config, p providers, service, env, tag, oldTag string, params deployParams) error {
 	svc := cfg.Services[service]
 
 	d, ok := p.deployers[svc.Type]
@@ -344,7 +424,49 @@ func deployService(ctx context.Context, cfg config, p providers, service, env, t
 		return fmt.Errorf("no deployer for service type %q", svc.Type)
 	}
 
-	return d.deploy(ctx, service, env, tag, oldTag)
+	if dr, ok := p.builds[svc.Type].(digestResolver); ok && svc.Image != "" {
+		digest, err := dr.resolveDigest(ctx, svc.Image, tag)
+		if err != nil {
+			return fmt.Errorf("resolving image digest: %w", err)
+		}
+		if want := params.digest; want != "" && want != digest {
+			return fmt.Errorf("%s:%s changed since confirmation (now %s, confirmed %s)", svc.Image, tag, shortDigest(digest), shortDigest(want))
+		}
+		params.digest = digest
+	}
+
+	checks := svc.Env[env].Smoke
+	if len(checks) == 0 {
+		return d.deploy(ctx, service, env, tag, oldTag, params)
+	}
+
+	// Smoke checks run once the deploy has switched traffic. Their result
+	// is appended to the deployer's summary rather than replacing it.
+	var summary string
+	deployCtx := withSummary(ctx, func(s string) {
+		summary = s
+		reportSummary(ctx, "%s", s)
+	})
+	if err := d.deploy(deployCtx, service, env, tag, oldTag, params); err != nil {
+		return err
+	}
+	var result string
+	if svc.Type == "static" && params.noWait {
+		// The CDN may still serve the old release until the invalidation
+		// completes, so the checks would prove nothing.
+		result = pluralize(len(checks), "smoke check") + " skipped (--no-wait)"
+	} else {
+		var err error
+		result, err = p.smoke.run(ctx, checks)
+		if err != nil {
+			return fmt.Errorf("smoke: %w", err)
+		}
+	}
+	if summary != "" {
+		result = summary + ", " + result
+	}
+	reportSummary(ctx, "%s", result)
+	return nil
 }
 
 
@@ -377,6 +499,15 @@ func sortedServiceNames(cfg config) []string {
 	return names
 }
 
+func sortedEnvNames(svc serviceConfig) []string {
+	names := make([]string, 0, len(svc.Env))
+	for name := range svc.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func envIntersection(cfg config, services []string) []string {
 	if len(services) == 0 {
 		return nil
@@ -405,20 +536,39 @@ func envIntersection(cfg config, services []string) []string {
 	return result
 }
 
-// buildsForServices returns a builds provider for the selected services.
-// When services use different provider types, it returns a merged provider
-// that intersects results — only builds present in all providers are returned.
-func buildsForServices(cfg config, p providers, services []string) buildsProvider {
-	seen := map[string]bool{}
+// envBuildsProvider is implemented by builds providers whose builds live
+// per service and env, like static builds in each env's bucket.
+type envBuildsProvider interface {
+	forEnv(service, env string) buildsProvider
+}
+
+// buildsForServices returns a builds provider for the selected services in
+// env, or in every env of a service when env is empty. When services use
+// different providers, it returns a merged provider that intersects
+// results — only builds present in all providers are returned.
+func buildsForServices(cfg config, p providers, services []string, env string) buildsProvider {
+	seen := map[buildsProvider]bool{}
 	var unique []buildsProvider
+	add := func(bp buildsProvider) {
+		if !seen[bp] {
+			seen[bp] = true
+			unique = append(unique, bp)
+		}
+	}
 	for _, svc := range services {
-		t := cfg.Services[svc].Type
-		if seen[t] {
+		bp, ok := p.builds[cfg.Services[svc].Type]
+		if !ok {
 			continue
 		}
-		seen[t] = true
-		if bp, ok := p.builds[t]; ok {
-			unique = append(unique, bp)
+		ep, ok := bp.(envBuildsProvider)
+		if !ok {
+			add(bp)
+			continue
+		}
+		for _, e := range sortedEnvNames(cfg.Services[svc]) {
+			if env == "" || e == env {
+				add(ep.forEnv(svc, e))
+			}
 		}
 	}
 	if len(unique) == 0 {
@@ -88,7 +88,7 @@ func (m *mockHistoryProvider) previous(_ context.Context, service, env string) (
 	return d, nil
 }
 
-func (m *mockDeployer) deploy(ctx context.Context, service, env, tag, oldTag string) error {
+func (m *mockDeployer) deploy(ctx context.Context, service, env, tag, oldTag string, _ deployParams) error {
 	if m.delay > 0 {
 		select {
 		case <-ctx.Done():
@@ -184,7 +184,7 @@ func TestDeployAllHappyPath(t *testing.T) {
 		"backend":  tag,
 		"frontend": tag,
 	}
-	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, previousTags)
+	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, previousTags, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -226,7 +226,7 @@ func TestDeployAllPartialFailure(t *testing.T) {
 
 	partialTag := "main-abc1234-20250101000000"
 	tags := map[string]string{"backend": partialTag, "frontend": partialTag}
-	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, nil)
+	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, nil, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -247,7 +247,7 @@ func TestDeployServiceServer(t *testing.T) {
 	cfg := testConfig()
 	p, md := testProviders(nil, nil)
 
-	err := deployService(context.Background(), cfg, p, "backend", "staging", "main-abc1234-20250101000000", "old-tag")
+	err := deployService(context.Background(), cfg, p, "backend", "staging", "main-abc1234-20250101000000", "old-tag", deployParams{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -271,7 +271,7 @@ func TestDeployServiceStatic(t *testing.T) {
 	cfg := testConfig()
 	p, md := testProviders(nil, nil)
 
-	err := deployService(context.Background(), cfg, p, "frontend", "staging", "main-abc1234-20250101000000", "")
+	err := deployService(context.Background(), cfg, p, "frontend", "staging", "main-abc1234-20250101000000", "", deployParams{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -299,7 +299,7 @@ func TestDeployAllWithPerServiceTags(t *testing.T) {
 		"frontend": "main-cur2222-20250101000000",
 	}
 
-	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, currentTags)
+	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, currentTags, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -428,7 +428,7 @@ func TestRunDeployNonInteractive(t *testing.T) {
 		"backend": "main-old1234-20241231000000",
 	}
 	tags := map[string]string{"backend": tag}
-	result, err := deployAll(context.Background(), cfg, p, []string{"backend"}, "staging", tags, previousTags)
+	result, err := deployAll(context.Background(), cfg, p, []string{"backend"}, "staging", tags, previousTags, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -451,7 +451,7 @@ func TestDeployAllMultipleServices(t *testing.T) {
 
 	tag := "main-abc1234-20250101000000"
 	tags := map[string]string{"backend": tag, "frontend": tag}
-	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, nil)
+	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, nil, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -569,7 +569,7 @@ func TestDeployAllAllFail(t *testing.T) {
 
 	tag := "main-abc1234-20250101000000"
 	tags := map[string]string{"backend": tag, "frontend": tag}
-	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, nil)
+	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", tags, nil, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -620,7 +620,7 @@ func TestDeployAllContextCancellation(t *testing.T) {
 
 	tag := "main-abc1234-20250101000000"
 	tags := map[string]string{"backend": tag, "frontend": tag}
-	result, err := deployAll(ctx, cfg, p, []string{"backend", "frontend"}, "staging", tags, nil)
+	result, err := deployAll(ctx, cfg, p, []string{"backend", "frontend"}, "staging", tags, nil, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -657,7 +657,7 @@ func TestBuildsForServicesIntersection(t *testing.T) {
 		},
 	}
 
-	bp := buildsForServices(cfg, p, []string{"backend", "frontend"})
+	bp := buildsForServices(cfg, p, []string{"backend", "frontend"}, "")
 	builds, err := bp.listBuilds(context.Background(), 10, 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -698,7 +698,7 @@ func TestBuildsForServicesSingleType(t *testing.T) {
 		builds: map[string]buildsProvider{"server": bp},
 	}
 
-	result := buildsForServices(cfg, p, []string{"api", "workers"})
+	result := buildsForServices(cfg, p, []string{"api", "workers"}, "")
 	// When all services use the same provider, no intersection needed — return it directly
 	builds, err := result.listBuilds(context.Background(), 10, 0)
 	if err != nil {
@@ -715,7 +715,7 @@ func TestDeployAllParallelExecution(t *testing.T) {
 
 	parallelTag := "main-abc1234-20250101000000"
 	parallelTags := map[string]string{"backend": parallelTag, "frontend": parallelTag}
-	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", parallelTags, nil)
+	result, err := deployAll(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", parallelTags, nil, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
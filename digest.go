@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// digestResolver is implemented by builds providers whose builds are
+// container images, so deploys can pin an image by its manifest digest
+// instead of a mutable tag. resolveDigest returns "" for images it can't
+// pin, which are deployed by tag.
+type digestResolver interface {
+	resolveDigest(ctx context.Context, image, tag string) (string, error)
+}
+
+// resolveDigests looks up the digest behind each service's tag before the
+// deploy is confirmed, keyed by service. Services whose builds aren't images
+// are skipped, and images that can't be pinned get an empty digest.
+func resolveDigests(ctx context.Context, cfg config, p providers, services []string, tags map[string]string) (map[string]string, error) {
+	pinned := make(map[string]string)
+	for _, svc := range services {
+		svcCfg := cfg.Services[svc]
+		dr, ok := p.builds[svcCfg.Type].(digestResolver)
+		if !ok || svcCfg.Image == "" {
+			continue
+		}
+		digest, err := dr.resolveDigest(ctx, svcCfg.Image, tags[svc])
+		if err != nil {
+			return nil, fmt.Errorf("resolving digest for %s: %w", svc, err)
+		}
+		pinned[svc] = digest
+	}
+	return pinned, nil
+}
+
+// imageRef returns the reference to pull and run: image@digest when the
+// digest is known, image:tag otherwise.
+func imageRef(image, tag, digest string) string {
+	if digest != "" {
+		return image + "@" + digest
+	}
+	return image + ":" + tag
+}
+
+// shortDigest abbreviates a digest for display like docker does.
+func shortDigest(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return hex
+}
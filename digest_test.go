@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type stubDigestBuilds struct {
+	mockBuildsProvider
+	digests map[string]string // keyed by "image:tag"
+}
+
+func (s *stubDigestBuilds) resolveDigest(_ context.Context, image, tag string) (string, error) {
+	return s.digests[image+":"+tag], nil
+}
+
+type digestRecordingDeployer struct {
+	digest string
+}
+
+func (d *digestRecordingDeployer) deploy(_ context.Context, _, _, _, _ string, params deployParams) error {
+	d.digest = params.digest
+	return nil
+}
+
+func TestDeployServicePinsDigest(t *testing.T) {
+	cfg := testConfig()
+	tag := "main-abc1234-20250101000000"
+	builds := &stubDigestBuilds{digests: map[string]string{"myapp/backend:" + tag: "sha256:aaaa"}}
+	dep := &digestRecordingDeployer{}
+	p := providers{
+		builds:    map[string]buildsProvider{"server": builds},
+		deployers: map[string]deployer{"server": dep},
+	}
+
+	pinned, err := resolveDigests(context.Background(), cfg, p, []string{"backend"}, map[string]string{"backend": tag})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := context.Background()
+	params := deployParams{digest: pinned["backend"]}
+
+	if err := deployService(ctx, cfg, p, "backend", "staging", tag, "", params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dep.digest != "sha256:aaaa" {
+		t.Errorf("deployer digest = %q, want %q", dep.digest, "sha256:aaaa")
+	}
+
+	// The tag is re-pushed between confirmation and execution.
+	builds.digests["myapp/backend:"+tag] = "sha256:bbbb"
+	dep.digest = ""
+	err = deployService(ctx, cfg, p, "backend", "staging", tag, "", params)
+	if err == nil || !strings.Contains(err.Error(), "changed since confirmation") {
+		t.Fatalf("expected digest change error, got %v", err)
+	}
+	if dep.digest != "" {
+		t.Error("deployer should not run when the digest changed")
+	}
+}
+
+func TestImageRef(t *testing.T) {
+	if got := imageRef("myapp/backend", "t1", ""); got != "myapp/backend:t1" {
+		t.Errorf("imageRef without digest = %q", got)
+	}
+	if got := imageRef("myapp/backend", "t1", "sha256:abc"); got != "myapp/backend@sha256:abc" {
+		t.Errorf("imageRef with digest = %q", got)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// runHooks runs each hook in order on the node, streaming its output as
+// deploy progress. It stops at the first failing hook. Hook containers run
+// the image at digest when it's set.
+func runHooks(ctx context.Context, client sshRunner, phase string, hooks []hookConfig, service, tag, digest string, svc serviceConfig, ec envConfig) error {
+	for _, hook := range hooks {
+		if err := runHook(ctx, client, phase, hook, service, tag, digest, svc, ec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, client sshRunner, phase string, hook hookConfig, service, tag, digest string, svc serviceConfig, ec envConfig) error {
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		defer cancel()
+	}
+
+	var cmd string
+	name := hookContainerName(service, tag, hook)
+	if hook.Exec {
+		cmd = "docker exec " + shellJoin(append([]string{service + "-" + tag}, hook.Command...))
+	} else {
+		cmd = "docker run " + shellJoin(buildHookRunArgs(name, imageRef(svc.Image, tag, digest), hook, svc, ec))
+	}
+
+	reportProgress(ctx, "%s hook %s...", phase, hook.Name)
+	w := &lineWriter{ctx: ctx, prefix: fmt.Sprintf("%s hook %s: ", phase, hook.Name)}
+	err := client.stream(ctx, cmd, w)
+	w.flush()
+	if err != nil {
+		if !hook.Exec {
+			// --rm doesn't fire if the run was interrupted (best-effort).
+			client.run(context.WithoutCancel(ctx), fmt.Sprintf("docker rm -f %s", name))
+		}
+		if tail := w.tail(); tail != "" {
+			return fmt.Errorf("%s hook %s failed: %w\n%s", phase, hook.Name, err, tail)
+		}
+		return fmt.Errorf("%s hook %s failed: %w", phase, hook.Name, err)
+	}
+	return nil
+}
+
+func hookContainerName(service, tag string, hook hookConfig) string {
+	return fmt.Sprintf("%s-%s-hook-%s", service, tag, hook.Name)
+}
+
+// buildHookRunArgs returns docker run arguments for a one-off hook container.
+// It shares the service's envfile, network, volumes, env and user so hooks
+// like migrations see the same environment as the service itself.
+func buildHookRunArgs(name, image string, hook hookConfig, svc serviceConfig, ec envConfig) []string {
+	cc := mergeContainer(svc.Container, ec.Container)
+
+	args := []string{
+		"--rm",
+		"--name", name,
+		"--env-file", ec.EnvFile,
+	}
+	if len(cc.Networks) > 0 {
+		args = append(args, "--network", cc.Networks[0])
+	}
+	for _, v := range cc.Volumes {
+		args = append(args, "--volume", v)
+	}
+	for _, k := range sortedKeys(cc.Env) {
+		args = append(args, "--env", k+"="+cc.Env[k])
+	}
+	if cc.User != "" {
+		args = append(args, "--user", cc.User)
+	}
+	if cc.Entrypoint != "" {
+		args = append(args, "--entrypoint", cc.Entrypoint)
+	}
+	args = append(args, image)
+	return append(args, hook.Command...)
+}
+
+// lineWriter reports each line written to it as deploy progress and keeps
+// the last few lines for error messages. It is safe for concurrent use, as
+// a command's stdout and stderr are copied to it from separate goroutines.
+type lineWriter struct {
+	ctx    context.Context
+	prefix string
+
+	mu    sync.Mutex
+	buf   []byte
+	lines []string
+}
+
+const lineWriterTail = 10
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.line(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) line(s string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return
+	}
+	reportProgress(w.ctx, "%s%s", w.prefix, s)
+	w.lines = append(w.lines, s)
+	if len(w.lines) > lineWriterTail {
+		w.lines = w.lines[1:]
+	}
+}
+
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.line(string(w.buf))
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) tail() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return strings.Join(w.lines, "\n")
+}
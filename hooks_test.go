@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func hookTestConfig() config {
+	cfg := testConfig()
+	svc := cfg.Services["backend"]
+	svc.Hooks = hooksConfig{
+		PreDeploy:  []hookConfig{{Name: "migrate", Command: []string{"./app", "migrate", "up"}}},
+		PostDeploy: []hookConfig{{Name: "warm", Command: []string{"./app", "warm-cache"}, Exec: true}},
+	}
+	cfg.Services["backend"] = svc
+	return cfg
+}
+
+func TestBuildHookRunArgs(t *testing.T) {
+	svc := serviceConfig{
+		Image:     "myapp/backend",
+		Container: containerConfig{Networks: []string{"db", "web"}, Env: map[string]string{"MODE": "migrate"}},
+	}
+	ec := envConfig{EnvFile: "/etc/backend/prod.env"}
+	hook := hookConfig{Name: "migrate", Command: []string{"./app", "migrate", "up"}}
+
+	args := buildHookRunArgs("backend-t-hook-migrate", imageRef(svc.Image, "t", ""), hook, svc, ec)
+	got := shellJoin(args)
+	want := "--rm --name backend-t-hook-migrate --env-file /etc/backend/prod.env --network db --env MODE=migrate myapp/backend:t ./app migrate up"
+	if got != want {
+		t.Errorf("hook args = %q, want %q", got, want)
+	}
+}
+
+func TestServerDeployRunsHooks(t *testing.T) {
+	mock := &mockSSHRunner{}
+	d := &serverDeployer{
+		cfg:          hookTestConfig(),
+		dial:         func(_ string) (sshRunner, error) { return mock, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  1 * time.Second,
+	}
+
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(mock.commands[1], "docker run --rm --name backend-main-abc1234-20250101000000-hook-migrate") {
+		t.Errorf("cmd[1] = %q, want pre-deploy hook before the new container starts", mock.commands[1])
+	}
+	if !strings.HasPrefix(mock.commands[2], "docker run -d") {
+		t.Errorf("cmd[2] = %q, want docker run of new container", mock.commands[2])
+	}
+	last := mock.commands[len(mock.commands)-1]
+	if last != "docker exec backend-main-abc1234-20250101000000 ./app warm-cache" {
+		t.Errorf("last command = %q, want post-deploy exec hook", last)
+	}
+}
+
+func TestServerDeployPreHookFailureAborts(t *testing.T) {
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: ""}, // docker pull
+			{output: "migration 42 failed: column exists", err: fmt.Errorf("exit status 1")}, // hook
+		},
+	}
+	d := &serverDeployer{
+		cfg:  hookTestConfig(),
+		dial: func(_ string) (sshRunner, error) { return mock, nil },
+	}
+
+	var progress []string
+	ctx := withProgress(context.Background(), func(detail string) {
+		progress = append(progress, detail)
+	})
+
+	err := d.deploy(ctx, "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "pre-deploy hook migrate failed") {
+		t.Errorf("expected hook failure error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "column exists") {
+		t.Errorf("expected hook output in error, got: %v", err)
+	}
+
+	// Only pull, hook and hook cleanup: the new container never starts.
+	if len(mock.commands) != 3 {
+		t.Fatalf("expected 3 commands, got %d: %v", len(mock.commands), mock.commands)
+	}
+	if mock.commands[2] != "docker rm -f backend-main-abc1234-20250101000000-hook-migrate" {
+		t.Errorf("cmd[2] = %q, want hook container cleanup", mock.commands[2])
+	}
+
+	found := false
+	for _, p := range progress {
+		if p == "pre-deploy hook migrate: migration 42 failed: column exists" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected streamed hook output in progress, got %v", progress)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	ctx := withProgress(context.Background(), func(detail string) {
+		lines = append(lines, detail)
+	})
+
+	w := &lineWriter{ctx: ctx, prefix: "> "}
+	fmt.Fprint(w, "one\ntw")
+	fmt.Fprint(w, "o\r\n\nthree")
+	w.flush()
+
+	want := []string{"> one", "> two", "> three"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("lines = %v, want %v", lines, want)
+	}
+	if w.tail() != "one\ntwo\nthree" {
+		t.Errorf("tail = %q", w.tail())
+	}
+}
+
+func TestLineWriterConcurrentWrites(t *testing.T) {
+	var mu sync.Mutex
+	var lines []string
+	ctx := withProgress(context.Background(), func(detail string) {
+		mu.Lock()
+		lines = append(lines, detail)
+		mu.Unlock()
+	})
+
+	// Like an SSH session copying stdout and stderr to the same writer.
+	w := &lineWriter{ctx: ctx}
+	var wg sync.WaitGroup
+	for _, stream := range []string{"out", "err"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				fmt.Fprintf(w, "%s %d\n", stream, i)
+			}
+		}()
+	}
+	wg.Wait()
+	w.flush()
+
+	if len(lines) != 200 {
+		t.Errorf("got %d lines, want 200", len(lines))
+	}
+	if n := len(strings.Split(w.tail(), "\n")); n != lineWriterTail {
+		t.Errorf("tail has %d lines, want %d", n, lineWriterTail)
+	}
+}
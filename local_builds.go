@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// binaryBuildsProvider lists the builds of binary services, each from its
+// own artifact bucket or directory.
+type binaryBuildsProvider struct {
+	cfg config
+	s3  s3ListObjectsAPI
+
+	mu        sync.Mutex
+	providers map[artifactConfig]buildsProvider
+}
+
+// forEnv returns the provider of a service's builds, which are the same in
+// every env. Services sharing an artifact location share a provider.
+func (p *binaryBuildsProvider) forEnv(service, _ string) buildsProvider {
+	ac := p.cfg.Services[service].Artifact
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bp, ok := p.providers[ac]; ok {
+		return bp
+	}
+	if p.providers == nil {
+		p.providers = map[artifactConfig]buildsProvider{}
+	}
+	var bp buildsProvider = &localBuildsProvider{dir: ac.Dir}
+	if ac.Bucket != "" {
+		bp = &staticBuildsProvider{s3: p.s3, bucket: ac.Bucket}
+	}
+	p.providers[ac] = bp
+	return bp
+}
+
+// listBuilds lists the builds present for every binary service.
+func (p *binaryBuildsProvider) listBuilds(ctx context.Context, limit, offset int) ([]build, error) {
+	var services []string
+	for _, name := range sortedServiceNames(p.cfg) {
+		if p.cfg.Services[name].Type == "binary" {
+			services = append(services, name)
+		}
+	}
+	bp := buildsForServices(p.cfg, providers{builds: map[string]buildsProvider{"binary": p}}, services, "")
+	if bp == nil {
+		return nil, nil
+	}
+	return bp.listBuilds(ctx, limit, offset)
+}
+
+// localBuildsProvider lists builds from a local directory with one
+// subdirectory per build tag.
+type localBuildsProvider struct {
+	dir string
+}
+
+func (p *localBuildsProvider) listBuilds(_ context.Context, limit, offset int) ([]build, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing local builds in %s: %w", p.dir, err)
+	}
+
+	var all []build
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		t, err := parseTag(e.Name())
+		if err != nil {
+			continue
+		}
+		all = append(all, buildFromTag(t))
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Time.After(all[j].Time)
+	})
+
+	if offset >= len(all) {
+		return nil, nil
+	}
+	all = all[offset:]
+
+	if limit < len(all) {
+		all = all[:limit]
+	}
+
+	return all, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalBuildsProvider(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"main-abc1234-20250101000000",
+		"main-def5678-20250102000000",
+		"not-a-tag",
+	} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main-fff0000-20250103000000"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &localBuildsProvider{dir: dir}
+	builds, err := p.listBuilds(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(builds) != 2 {
+		t.Fatalf("expected 2 builds, got %d: %v", len(builds), builds)
+	}
+	if builds[0].Tag != "main-def5678-20250102000000" {
+		t.Errorf("builds[0] = %q, want newest first", builds[0].Tag)
+	}
+}
+
+func TestLocalBuildsProviderMissingDir(t *testing.T) {
+	p := &localBuildsProvider{dir: filepath.Join(t.TempDir(), "missing")}
+	if _, err := p.listBuilds(context.Background(), 10, 0); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBinaryBuildsPerService(t *testing.T) {
+	dirs := map[string]string{"agent": t.TempDir(), "daemon": t.TempDir()}
+	cfg := config{Services: map[string]serviceConfig{}}
+	for name, dir := range dirs {
+		cfg.Services[name] = serviceConfig{Type: "binary", Artifact: artifactConfig{Dir: dir}, Env: map[string]envConfig{"staging": {}}}
+	}
+	if err := os.Mkdir(filepath.Join(dirs["agent"], "main-abc1234-20250101000000"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dirs["daemon"], "main-def5678-20250102000000"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := providers{builds: map[string]buildsProvider{"binary": &binaryBuildsProvider{cfg: cfg}}}
+
+	for service, want := range map[string]string{
+		"agent":  "main-abc1234-20250101000000",
+		"daemon": "main-def5678-20250102000000",
+	} {
+		builds, err := buildsForServices(cfg, p, []string{service}, "staging").listBuilds(context.Background(), 10, 0)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", service, err)
+		}
+		if len(builds) != 1 || builds[0].Tag != want {
+			t.Errorf("%s: builds = %v, want %s", service, builds, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultLogDriver is used when neither the service nor the env picks one.
+// Containers shipped to CloudWatch before the driver was configurable, so
+// existing configs keep doing that.
+const defaultLogDriver = "awslogs"
+
+var logDrivers = map[string]bool{
+	"awslogs":   true,
+	"json-file": true,
+	"local":     true,
+	"journald":  true,
+	"fluentd":   true,
+	"loki":      true,
+	"syslog":    true,
+	"none":      true,
+}
+
+// mergeLog applies an env-level log config on top of the service's. Options
+// are merged key by key while the driver stays the same; switching drivers
+// drops the service-level options, which belong to the other driver.
+func mergeLog(base, override logConfig) logConfig {
+	driver := base.Driver
+	if driver == "" {
+		driver = defaultLogDriver
+	}
+	if override.Driver != "" && override.Driver != driver {
+		return logConfig{Driver: override.Driver, Options: override.Options}
+	}
+	return logConfig{Driver: base.Driver, Options: mergeMaps(base.Options, override.Options)}
+}
+
+func validateLog(lc logConfig) error {
+	driver := lc.Driver
+	if driver == "" {
+		driver = defaultLogDriver
+	}
+	if !logDrivers[driver] {
+		return fmt.Errorf("unknown driver %q", driver)
+	}
+	if driver == "none" && len(lc.Options) > 0 {
+		return fmt.Errorf("driver none takes no options")
+	}
+	for k, v := range lc.Options {
+		if k == "" || strings.ContainsAny(k, "= ") {
+			return fmt.Errorf("invalid option name %q", k)
+		}
+		if strings.Contains(logVars("p", "s", "e", "t").Replace(v), "${") {
+			return fmt.Errorf("option %s: unknown placeholder in %q (want ${project}, ${env}, ${service} or ${tag})", k, v)
+		}
+	}
+	if driver == "loki" && lc.Options["loki-url"] == "" {
+		return fmt.Errorf("driver loki requires the loki-url option")
+	}
+	return nil
+}
+
+func logVars(project, service, env, tag string) *strings.Replacer {
+	return strings.NewReplacer(
+		"${project}", project,
+		"${service}", service,
+		"${env}", env,
+		"${tag}", tag,
+	)
+}
+
+// resolveLogConfig returns the effective log config for a service in an env
+// with placeholders expanded. awslogs defaults its group to
+// /<project>/<env>/<service> and names streams after the container, and loki
+// streams get hoist_service and hoist_env labels, so logs can be found again
+// across deploys whatever the container's tag.
+func resolveLogConfig(project, service, env, tag string, svc serviceConfig, ec envConfig) logConfig {
+	lc := mergeLog(svc.Log, ec.Log)
+	if lc.Driver == "" {
+		lc.Driver = defaultLogDriver
+	}
+
+	vars := logVars(project, service, env, tag)
+	opts := make(map[string]string, len(lc.Options)+1)
+	for k, v := range lc.Options {
+		opts[k] = vars.Replace(v)
+	}
+	if lc.Driver == "awslogs" && opts["awslogs-group"] == "" {
+		opts["awslogs-group"] = fmt.Sprintf("/%s/%s/%s", project, env, service)
+	}
+	if lc.Driver == "awslogs" && opts["awslogs-stream"] == "" && opts["tag"] == "" {
+		opts["tag"] = "{{.Name}}"
+	}
+	if lc.Driver == "loki" {
+		labels := opts["loki-external-labels"]
+		if labels == "" {
+			// The driver's own default, which setting the option replaces.
+			labels = "container_name={{.Name}}"
+		}
+		opts["loki-external-labels"] = fmt.Sprintf("%s,hoist_service=%s,hoist_env=%s", labels, service, env)
+	}
+	lc.Options = opts
+	return lc
+}
+
+func logDriverArgs(lc logConfig) []string {
+	args := []string{"--log-driver", lc.Driver}
+	for _, k := range sortedKeys(lc.Options) {
+		args = append(args, "--log-opt", k+"="+lc.Options[k])
+	}
+	return args
+}
+
+// logsCommand picks how to read a container's logs back for its log driver.
+// Drivers docker can read (natively or through its dual-logging cache) use
+// docker logs on the node. awslogs and loki are queried at the source from
+// the local machine, since the node's cache only holds recent history.
+// container is only used on the node and may be empty for the others.
+func logsCommand(lc logConfig, service, env, container, since string, n int, follow bool) (onNode bool, cmd []string, err error) {
+	switch lc.Driver {
+	case "none":
+		return false, nil, fmt.Errorf("logging is disabled (log driver none)")
+	case "awslogs":
+		return false, awsLogsTailArgs(lc, service, since, follow), nil
+	case "loki":
+		return false, logcliArgs(lc, service, env, since, n, follow), nil
+	default:
+		return true, append([]string{"docker"}, dockerLogsArgs(container, since, n, follow)...), nil
+	}
+}
+
+// logsOnNode reports whether a driver's logs are read with docker logs on the
+// node, which needs the running container's name.
+func logsOnNode(driver string) bool {
+	return driver != "none" && driver != "awslogs" && driver != "loki"
+}
+
+// awsLogsTailArgs builds an `aws logs tail` invocation. The stream option is
+// matched by prefix because ${tag} expands to nothing when reading logs.
+// Streams named after the container (<service>-<tag>) are matched on the
+// service. aws logs tail has no line limit, so n is not used.
+func awsLogsTailArgs(lc logConfig, service, since string, follow bool) []string {
+	args := []string{"aws", "logs", "tail", lc.Options["awslogs-group"]}
+	if region := lc.Options["awslogs-region"]; region != "" {
+		args = append(args, "--region", region)
+	}
+	if stream := lc.Options["awslogs-stream"]; stream != "" {
+		args = append(args, "--log-stream-name-prefix", stream)
+	} else if lc.Options["tag"] == "{{.Name}}" {
+		args = append(args, "--log-stream-name-prefix", service+"-")
+	}
+	if since != "" {
+		args = append(args, "--since", since)
+	}
+	if follow {
+		args = append(args, "--follow")
+	}
+	return args
+}
+
+// logcliArgs builds a Loki logcli query for the service's streams, using the
+// labels resolveLogConfig adds rather than container_name, which changes with
+// every tag.
+func logcliArgs(lc logConfig, service, env, since string, n int, follow bool) []string {
+	addr := strings.TrimSuffix(lc.Options["loki-url"], "/loki/api/v1/push")
+	args := []string{"logcli", "query", "--addr", addr}
+	if n > 0 {
+		args = append(args, "--limit", strconv.Itoa(n))
+	}
+	if since != "" {
+		args = append(args, "--since", since)
+	}
+	if follow {
+		args = append(args, "--tail")
+	}
+	return append(args, fmt.Sprintf(`{hoist_service=%q,hoist_env=%q}`, service, env))
+}
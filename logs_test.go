@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"strings"
 	"testing"
 )
@@ -63,3 +64,98 @@ func TestDockerLogsArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestJournalctlArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		since  string
+		n      int
+		follow bool
+		want   string
+	}{
+		{name: "follow mode", follow: true, want: "-u daemon.service -f"},
+		{name: "tail N lines", n: 50, want: "-u daemon.service -n 50"},
+		{name: "since duration", since: "1h", want: "-u daemon.service --since -1h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(journalctlArgs("daemon.service", tt.since, tt.n, tt.follow), " ")
+			if got != tt.want {
+				t.Errorf("journalctlArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogsCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		lc         logConfig
+		since      string
+		n          int
+		follow     bool
+		wantOnNode bool
+		want       string
+	}{
+		{
+			name:       "json-file reads from docker",
+			lc:         logConfig{Driver: "json-file"},
+			n:          100,
+			wantOnNode: true,
+			want:       "docker logs --tail 100 backend-main-abc1234-20250101000000",
+		},
+		{
+			name:   "awslogs tails CloudWatch",
+			lc:     logConfig{Driver: "awslogs", Options: map[string]string{"awslogs-group": "/myapp/staging/backend", "awslogs-region": "eu-west-1", "awslogs-stream": "backend-"}},
+			since:  "1h",
+			follow: true,
+			want:   "aws logs tail /myapp/staging/backend --region eu-west-1 --log-stream-name-prefix backend- --since 1h --follow",
+		},
+		{
+			name: "awslogs streams named after the container",
+			lc:   logConfig{Driver: "awslogs", Options: map[string]string{"awslogs-group": "/myapp", "tag": "{{.Name}}"}},
+			want: "aws logs tail /myapp --log-stream-name-prefix backend-",
+		},
+		{
+			name: "loki queries logcli",
+			lc:   logConfig{Driver: "loki", Options: map[string]string{"loki-url": "http://loki:3100/loki/api/v1/push"}},
+			n:    50,
+			want: `logcli query --addr http://loki:3100 --limit 50 {hoist_service="backend",hoist_env="staging"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			onNode, cmd, err := logsCommand(tt.lc, "backend", "staging", "backend-main-abc1234-20250101000000", tt.since, tt.n, tt.follow)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if onNode != tt.wantOnNode {
+				t.Errorf("onNode = %v, want %v", onNode, tt.wantOnNode)
+			}
+			if got := strings.Join(cmd, " "); got != tt.want {
+				t.Errorf("logsCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogsCommandDriverNone(t *testing.T) {
+	if _, _, err := logsCommand(logConfig{Driver: "none"}, "backend", "staging", "", "", 0, true); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestServerLogsNoRunningContainer(t *testing.T) {
+	cfg := testConfig()
+	backend := cfg.Services["backend"]
+	backend.Log = logConfig{Driver: "json-file"}
+	cfg.Services["backend"] = backend
+
+	p := &serverLogsProvider{cfg: cfg, history: &mockHistoryProvider{}}
+	err := p.tail(context.Background(), "backend", "staging", 100, "")
+	if err == nil || !strings.Contains(err.Error(), "no running container") {
+		t.Fatalf("expected no running container error, got: %v", err)
+	}
+}
@@ -21,6 +21,9 @@ func newRootCmd() *cobra.Command {
 	cmd.AddCommand(newBuildsCmd())
 	cmd.AddCommand(newRollbackCmd())
 	cmd.AddCommand(newLogsCmd())
+	cmd.AddCommand(newPruneCmd())
+	cmd.AddCommand(newVerifyCmd())
+	cmd.AddCommand(newPublishCmd())
 	return cmd
 }
 
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// preflighter checks that a service can be deployed to an env without
+// changing anything, so problems surface before a parallel rollout starts.
+type preflighter interface {
+	preflight(ctx context.Context, service, env, tag string) []preflightCheck
+}
+
+type preflightCheck struct {
+	name   string
+	detail string // shown next to passing checks, e.g. "12.3 GiB free"
+	err    error
+}
+
+type preflightResult struct {
+	service string
+	checks  []preflightCheck
+}
+
+// minFreeDisk is the free space a node needs for a deploy to go ahead.
+const minFreeDisk = 1 << 30
+
+// runPreflight runs the preflight checks for every service in parallel,
+// prints them as a table to w and fails if any check failed.
+func runPreflight(ctx context.Context, cfg config, p providers, services []string, env string, tags map[string]string, w io.Writer) error {
+	results := make([]preflightResult, len(services))
+	var wg sync.WaitGroup
+	for i, svc := range services {
+		pf, ok := p.preflight[cfg.Services[svc].Type]
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, svc string) {
+			defer wg.Done()
+			results[i] = preflightResult{service: svc, checks: pf.preflight(ctx, svc, env, tags[svc])}
+		}(i, svc)
+	}
+	wg.Wait()
+
+	var ran []preflightResult
+	var failed []string
+	for _, r := range results {
+		if r.service == "" {
+			continue
+		}
+		ran = append(ran, r)
+		for _, c := range r.checks {
+			if c.err != nil {
+				failed = append(failed, r.service)
+				break
+			}
+		}
+	}
+	if len(ran) == 0 {
+		return nil
+	}
+
+	fmt.Fprint(w, formatPreflightTable(ran))
+	if len(failed) > 0 {
+		return fmt.Errorf("preflight checks failed for %s (use --skip-preflight to deploy anyway)", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func formatPreflightTable(results []preflightResult) string {
+	svcW, checkW := len("SERVICE"), len("CHECK")
+	for _, r := range results {
+		if len(r.service) > svcW {
+			svcW = len(r.service)
+		}
+		for _, c := range r.checks {
+			if len(c.name) > checkW {
+				checkW = len(c.name)
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-*s  %-*s  %s\n", svcW, "SERVICE", checkW, "CHECK", "RESULT")
+	for _, r := range results {
+		for _, c := range r.checks {
+			result := "ok"
+			if c.err != nil {
+				result = "FAIL: " + c.err.Error()
+			} else if c.detail != "" {
+				result += " (" + c.detail + ")"
+			}
+			fmt.Fprintf(&b, "%-*s  %-*s  %s\n", svcW, r.service, checkW, c.name, result)
+		}
+	}
+	return b.String()
+}
+
+// serverPreflight checks server, worker and compose services: the image tag
+// exists, the node is reachable and runs docker, the envfile exists and
+// docker has room for another image. Servers that drain get their image
+// pulled to check that it can carry the drain healthcheck.
+type serverPreflight struct {
+	cfg    config
+	images digestResolver
+	dial   func(addr string) (sshRunner, error)
+	auth   *registryAuth // nil skips registry login
+}
+
+func (c *serverPreflight) preflight(ctx context.Context, service, env, tag string) []preflightCheck {
+	svc := c.cfg.Services[service]
+	ec := svc.Env[env]
+
+	var checks []preflightCheck
+	digest, err := c.images.resolveDigest(ctx, svc.Image, tag)
+	detail := shortDigest(digest)
+	if err == nil && digest == "" {
+		detail = "not checked outside ECR"
+	}
+	checks = append(checks, preflightCheck{name: "image", detail: detail, err: err})
+
+	client, ok := dialCheck(c.cfg, ec, c.dial, &checks)
+	if !ok {
+		return checks
+	}
+	defer client.close()
+
+	out, err := client.run(ctx, `docker version --format "{{.Server.Version}}"`)
+	checks = append(checks, preflightCheck{name: "docker", detail: strings.TrimSpace(out), err: err})
+	if svc.Type == "compose" {
+		_, err := client.run(ctx, "docker compose version")
+		checks = append(checks, preflightCheck{name: "docker compose", err: err})
+	}
+	if ec.EnvFile != "" {
+		checks = append(checks, fileCheck(ctx, client, "envfile", ec.EnvFile))
+	}
+	checks = append(checks, diskCheck(ctx, client, "/var/lib/docker"))
+	if dc, ok := newProxy(c.cfg.Proxies[ec.Node], 0, 0).(drainChecker); ok && svc.Type == "server" && svc.Drain.Period > 0 {
+		checks = append(checks, c.drainCheck(ctx, client, dc, imageRef(svc.Image, tag, "")))
+	}
+	return checks
+}
+
+// drainCheck pulls image on the node and checks that the proxy can drain
+// containers of it.
+func (c *serverPreflight) drainCheck(ctx context.Context, client sshRunner, dc drainChecker, image string) preflightCheck {
+	check := preflightCheck{name: "drain"}
+	if c.auth != nil {
+		if err := c.auth.login(ctx, client, image); err != nil {
+			check.err = fmt.Errorf("logging in to registry: %w", err)
+			return check
+		}
+	}
+	if _, err := client.run(ctx, "docker pull -q "+image); err != nil {
+		check.err = fmt.Errorf("pulling image: %w", err)
+		return check
+	}
+	if err := dc.checkDrain(ctx, client, image); err != nil {
+		check.err = fmt.Errorf("can't drain with drain.period set: %w", err)
+	}
+	return check
+}
+
+type binaryPreflight struct {
+	cfg  config
+	s3   s3ListAPI
+	dial func(addr string) (sshRunner, error)
+}
+
+func (c *binaryPreflight) preflight(ctx context.Context, service, env, tag string) []preflightCheck {
+	svc := c.cfg.Services[service]
+	ec := svc.Env[env]
+
+	var checks []preflightCheck
+	if svc.Artifact.Bucket != "" {
+		checks = append(checks, s3BuildCheck(ctx, c.s3, svc.Artifact.Bucket, "builds/"+tag+"/"))
+	} else {
+		dir := filepath.Join(svc.Artifact.Dir, tag)
+		var err error
+		if fi, statErr := os.Stat(dir); statErr != nil {
+			err = fmt.Errorf("artifact not found: %w", statErr)
+		} else if !fi.IsDir() {
+			err = fmt.Errorf("artifact %s is not a directory", dir)
+		}
+		checks = append(checks, preflightCheck{name: "artifact", detail: dir, err: err})
+	}
+
+	client, ok := dialCheck(c.cfg, ec, c.dial, &checks)
+	if !ok {
+		return checks
+	}
+	defer client.close()
+
+	_, err := client.run(ctx, "systemctl cat "+shellQuote(svc.Unit)+" >/dev/null")
+	if err != nil {
+		err = fmt.Errorf("unit %s not found: %w", svc.Unit, err)
+	}
+	checks = append(checks, preflightCheck{name: "unit", detail: svc.Unit, err: err})
+	return append(checks, diskCheck(ctx, client, binaryDir(c.cfg.Project, service, ec)))
+}
+
+type cfGetDistributionAPI interface {
+	GetDistribution(ctx context.Context, params *cloudfront.GetDistributionInput, optFns ...func(*cloudfront.Options)) (*cloudfront.GetDistributionOutput, error)
+}
+
+type staticPreflight struct {
+	cfg        config
+	s3         s3ListAPI
+	storage    map[string]s3ListAPI // by service, for services with their own storage
+	cloudfront cfGetDistributionAPI
+}
+
+func (c *staticPreflight) preflight(ctx context.Context, service, env, tag string) []preflightCheck {
+	ec := c.cfg.Services[service].Env[env]
+
+	api := c.s3
+	if s, ok := c.storage[service]; ok {
+		api = s
+	}
+	checks := []preflightCheck{s3BuildCheck(ctx, api, ec.Bucket, bucketKey(ec, "builds/"+tag+"/"))}
+	if purgeType(ec) != "cloudfront" {
+		return checks
+	}
+
+	out, err := c.cloudfront.GetDistribution(ctx, &cloudfront.GetDistributionInput{Id: &ec.CloudFront})
+	check := preflightCheck{name: "cloudfront", detail: ec.CloudFront}
+	if err != nil {
+		check.err = fmt.Errorf("distribution %s: %w", ec.CloudFront, err)
+	} else if out.Distribution != nil && out.Distribution.Status != nil {
+		check.detail += " " + *out.Distribution.Status
+	}
+	return append(checks, check)
+}
+
+type s3ListAPI interface {
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+func s3BuildCheck(ctx context.Context, api s3ListAPI, bucket, prefix string) preflightCheck {
+	var maxKeys int32 = 1
+	out, err := api.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: &bucket, Prefix: &prefix, MaxKeys: &maxKeys})
+	check := preflightCheck{name: "build", detail: fmt.Sprintf("s3://%s/%s", bucket, prefix)}
+	switch {
+	case err != nil:
+		check.err = fmt.Errorf("listing s3://%s/%s: %w", bucket, prefix, err)
+	case len(out.Contents) == 0:
+		check.err = fmt.Errorf("build not found: s3://%s/%s", bucket, prefix)
+	}
+	return check
+}
+
+// dialCheck connects to the env's node and records the result. It returns
+// false if the connection failed and no remote checks can run.
+func dialCheck(cfg config, ec envConfig, dial func(addr string) (sshRunner, error), checks *[]preflightCheck) (sshRunner, bool) {
+	addr := cfg.Nodes[ec.Node]
+	client, err := dial(addr)
+	if err != nil {
+		*checks = append(*checks, preflightCheck{name: "ssh", err: fmt.Errorf("connecting to %s: %w", addr, err)})
+		return nil, false
+	}
+	*checks = append(*checks, preflightCheck{name: "ssh", detail: addr})
+	return client, true
+}
+
+func fileCheck(ctx context.Context, client sshRunner, name, file string) preflightCheck {
+	if _, err := client.run(ctx, "test -r "+shellQuote(file)); err != nil {
+		return preflightCheck{name: name, err: fmt.Errorf("%s is missing or unreadable", file)}
+	}
+	return preflightCheck{name: name, detail: file}
+}
+
+// diskCheck checks free space on the filesystem holding dir, falling back to
+// the root filesystem when dir doesn't exist yet.
+func diskCheck(ctx context.Context, client sshRunner, dir string) preflightCheck {
+	out, err := client.run(ctx, fmt.Sprintf("df -Pk %s 2>/dev/null || df -Pk /", shellQuote(dir)))
+	if err != nil {
+		return preflightCheck{name: "disk", err: fmt.Errorf("checking free space: %w", err)}
+	}
+	free, err := parseDfAvailable(out)
+	if err != nil {
+		return preflightCheck{name: "disk", err: err}
+	}
+	check := preflightCheck{name: "disk", detail: formatBytes(free) + " free"}
+	if free < minFreeDisk {
+		check.err = fmt.Errorf("only %s free, need %s", formatBytes(free), formatBytes(minFreeDisk))
+	}
+	return check
+}
+
+// parseDfAvailable returns the available bytes from `df -Pk` output.
+func parseDfAvailable(out string) (int64, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return 0, fmt.Errorf("unexpected df output: %q", out)
+	}
+	kb, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected df output: %q", out)
+	}
+	return kb * 1024, nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
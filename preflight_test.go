@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const dfOutput = `Filesystem     1024-blocks      Used Available Capacity Mounted on
+/dev/nvme0n1p1    81254044  40627022  40627022      50% /`
+
+type stubPreflighter struct {
+	checks []preflightCheck
+}
+
+func (s *stubPreflighter) preflight(context.Context, string, string, string) []preflightCheck {
+	return s.checks
+}
+
+type stubCFDistribution struct {
+	err error
+}
+
+func (s *stubCFDistribution) GetDistribution(_ context.Context, _ *cloudfront.GetDistributionInput, _ ...func(*cloudfront.Options)) (*cloudfront.GetDistributionOutput, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &cloudfront.GetDistributionOutput{Distribution: &cftypes.Distribution{Status: aws.String("Deployed")}}, nil
+}
+
+func TestServerPreflightPasses(t *testing.T) {
+	cfg := testConfig()
+	tag := "main-abc1234-20250101000000"
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: "27.1.1"}, // docker version
+			{output: ""},       // test -r envfile
+			{output: dfOutput}, // df
+		},
+	}
+	c := &serverPreflight{
+		cfg:    cfg,
+		images: &stubDigestBuilds{digests: map[string]string{"myapp/backend:" + tag: "sha256:0123456789abcdef"}},
+		dial:   func(string) (sshRunner, error) { return mock, nil },
+	}
+
+	checks := c.preflight(context.Background(), "backend", "staging", tag)
+	var names []string
+	for _, ch := range checks {
+		if ch.err != nil {
+			t.Errorf("check %s failed: %v", ch.name, ch.err)
+		}
+		names = append(names, ch.name)
+	}
+	if got := strings.Join(names, ","); got != "image,ssh,docker,envfile,disk" {
+		t.Errorf("checks = %s", got)
+	}
+	if mock.commands[1] != "test -r /etc/backend/staging.env" {
+		t.Errorf("envfile check = %q", mock.commands[1])
+	}
+}
+
+func TestServerPreflightDrain(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["backend"]
+	svc.Drain = drainConfig{Period: 30 * time.Second}
+	cfg.Services["backend"] = svc
+	tag := "main-abc1234-20250101000000"
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: "27.1.1"},                     // docker version
+			{output: ""},                           // test -r envfile
+			{output: dfOutput},                     // df
+			{output: ""},                           // docker pull
+			{output: `["CMD","/app/healthcheck"]`}, // image HEALTHCHECK
+		},
+	}
+	c := &serverPreflight{
+		cfg:    cfg,
+		images: &stubDigestBuilds{digests: map[string]string{"myapp/backend:" + tag: "sha256:0123456789abcdef"}},
+		dial:   func(string) (sshRunner, error) { return mock, nil },
+	}
+
+	checks := c.preflight(context.Background(), "backend", "staging", tag)
+	last := checks[len(checks)-1]
+	if last.name != "drain" || last.err == nil || !strings.Contains(last.err.Error(), "the image has its own HEALTHCHECK") {
+		t.Errorf("last check = %+v, want a failing drain check", last)
+	}
+	if mock.commands[3] != "docker pull -q myapp/backend:"+tag {
+		t.Errorf("pull = %q", mock.commands[3])
+	}
+}
+
+func TestServerPreflightUnreachableNode(t *testing.T) {
+	c := &serverPreflight{
+		cfg:    testConfig(),
+		images: &stubDigestBuilds{},
+		dial:   func(string) (sshRunner, error) { return nil, fmt.Errorf("connection refused") },
+	}
+
+	checks := c.preflight(context.Background(), "backend", "staging", "t")
+	last := checks[len(checks)-1]
+	if last.name != "ssh" || last.err == nil {
+		t.Errorf("expected failing ssh check to end the list, got %+v", checks)
+	}
+}
+
+func TestStaticPreflight(t *testing.T) {
+	cfg := testConfig()
+	tag := "main-abc1234-20250101000000"
+
+	c := &staticPreflight{
+		cfg:        cfg,
+		s3:         &stubS3Deploy{listPages: []s3.ListObjectsV2Output{{Contents: s3Objects("builds/" + tag + "/index.html")}}},
+		cloudfront: &stubCFDistribution{},
+	}
+	for _, ch := range c.preflight(context.Background(), "frontend", "staging", tag) {
+		if ch.err != nil {
+			t.Errorf("check %s failed: %v", ch.name, ch.err)
+		}
+	}
+
+	c = &staticPreflight{
+		cfg:        cfg,
+		s3:         &stubS3Deploy{},
+		cloudfront: &stubCFDistribution{err: fmt.Errorf("NoSuchDistribution")},
+	}
+	checks := c.preflight(context.Background(), "frontend", "staging", tag)
+	if len(checks) != 2 || checks[0].err == nil || checks[1].err == nil {
+		t.Errorf("expected missing build and distribution to fail, got %+v", checks)
+	}
+}
+
+func TestStaticPreflightStorage(t *testing.T) {
+	cfg := storageTestConfig("http://minio:9000")
+	tag := "main-abc1234-20250101000000"
+
+	// The service's own storage is checked, and there's no distribution to
+	// check with purge type none.
+	c := &staticPreflight{
+		cfg:     cfg,
+		s3:      &stubS3Deploy{},
+		storage: map[string]s3ListAPI{"frontend": &stubS3Deploy{listPages: []s3.ListObjectsV2Output{{Contents: s3Objects("builds/" + tag + "/index.html")}}}},
+	}
+	checks := c.preflight(context.Background(), "frontend", "staging", tag)
+	if len(checks) != 1 || checks[0].name != "build" || checks[0].err != nil {
+		t.Errorf("checks = %+v, want only a passing build check", checks)
+	}
+}
+
+func TestRunPreflight(t *testing.T) {
+	cfg := testConfig()
+	p := providers{preflight: map[string]preflighter{
+		"server": &stubPreflighter{checks: []preflightCheck{{name: "ssh", detail: "10.0.0.1"}}},
+		"static": &stubPreflighter{checks: []preflightCheck{{name: "cloudfront", err: fmt.Errorf("not found")}}},
+	}}
+
+	var out bytes.Buffer
+	err := runPreflight(context.Background(), cfg, p, []string{"backend", "frontend"}, "staging", nil, &out)
+	if err == nil || !strings.Contains(err.Error(), "failed for frontend") {
+		t.Fatalf("expected frontend failure, got %v", err)
+	}
+	table := out.String()
+	for _, want := range []string{"SERVICE", "backend", "ok (10.0.0.1)", "FAIL: not found"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("expected table to contain %q:\n%s", want, table)
+		}
+	}
+}
+
+func TestRunDeployPreflightBlocksDeploy(t *testing.T) {
+	cfg := testConfig()
+	p, md := testProviders(nil, nil)
+	p.preflight = map[string]preflighter{
+		"server": &stubPreflighter{checks: []preflightCheck{{name: "disk", err: fmt.Errorf("only 10 MiB free")}}},
+	}
+
+	err := runDeploy(context.Background(), cfg, p, deployOpts{
+		Services: []string{"backend"},
+		Env:      "staging",
+		Tags:     map[string]string{"backend": "main-abc1234-20250101000000"},
+		Yes:      true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "preflight") {
+		t.Fatalf("expected preflight error, got %v", err)
+	}
+	if len(md.calls) != 0 {
+		t.Errorf("expected no deploys, got %v", md.calls)
+	}
+}
+
+func TestParseDfAvailable(t *testing.T) {
+	got, err := parseDfAvailable(dfOutput)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != 40627022*1024 {
+		t.Errorf("available = %d", got)
+	}
+	if _, err := parseDfAvailable("df: /nope: No such file"); err == nil {
+		t.Error("expected error for malformed output")
+	}
+}
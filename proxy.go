@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// proxy connects server containers to the reverse proxy on their node.
+// Label-based proxies (Traefik, Caddy) discover containers through docker;
+// nginx is reconfigured over SSH once the new container is healthy.
+type proxy interface {
+	// runArgs returns extra docker run arguments that make the container
+	// routable.
+	runArgs(service string, svc serviceConfig, ec envConfig) []string
+	// route sends the service's traffic to container. It is called after
+	// the container passes its healthcheck and before the old one drains.
+	route(ctx context.Context, client sshRunner, service, env, container string, svc serviceConfig) error
+	// unroute stops new requests reaching a container that is about to be
+	// drained and stopped. It returns errNoDrain if the container can't be
+	// taken out of rotation.
+	unroute(ctx context.Context, client sshRunner, container string) error
+}
+
+// errNoDrain is returned by unroute for containers that were started
+// without a way to drain them; they are stopped without draining.
+var errNoDrain = errors.New("container has no drain healthcheck")
+
+// drainChecker is implemented by proxies that drain through a healthcheck
+// hoist adds to the container. checkDrain returns why an image can't carry
+// that healthcheck, if it can't.
+type drainChecker interface {
+	checkDrain(ctx context.Context, client sshRunner, image string) error
+}
+
+// defaultProxyThrottle is how long Traefik takes by default to apply a
+// change it has seen (its providersThrottleDuration).
+const defaultProxyThrottle = 2 * time.Second
+
+// newProxy returns the proxy integration configured for a node. Nodes
+// without a proxies entry use Traefik.
+func newProxy(pc proxyConfig, interval, timeout time.Duration) proxy {
+	health := healthRouting{interval: interval, timeout: timeout, throttle: pc.Throttle}
+	if health.throttle == 0 {
+		health.throttle = defaultProxyThrottle
+	}
+	switch pc.Type {
+	case "caddy":
+		return &caddyProxy{container: pc.Container, interval: interval, timeout: timeout}
+	case "nginx":
+		return &nginxProxy{confDir: pc.ConfDir, reload: pc.Reload}
+	default:
+		return &traefikProxy{healthRouting: health}
+	}
+}
+
+// healthRouting implements route and unroute for Traefik, which only sends
+// traffic to containers docker reports healthy. With draining enabled the
+// container gets a healthcheck on drainMarker, so creating the marker takes
+// it out of rotation without closing existing connections. The healthcheck
+// runs in the container's /bin/sh, and it would replace the image's own
+// HEALTHCHECK, so images without a shell or with a HEALTHCHECK can't be
+// drained; deploys of them fail while drain.period is set.
+type healthRouting struct {
+	interval time.Duration
+	timeout  time.Duration
+	throttle time.Duration // how long the proxy takes to follow a health change
+}
+
+func (h healthRouting) drainArgs(svc serviceConfig) []string {
+	if svc.Drain.Period == 0 {
+		return nil
+	}
+	// One failed check is enough: the marker doesn't go away by itself.
+	return []string{
+		"--health-cmd", fmt.Sprintf("test ! -e %s", drainMarker),
+		"--health-interval", "1s",
+		"--health-retries", "1",
+	}
+}
+
+func (h healthRouting) route(ctx context.Context, client sshRunner, _, _, container string, svc serviceConfig) error {
+	if svc.Drain.Period == 0 {
+		return nil
+	}
+	// Don't unroute the old container before the proxy routes the new one.
+	reportProgress(ctx, "waiting for new container to be routed...")
+	return waitDockerHealth(ctx, client, container, "healthy", h.interval, h.timeout)
+}
+
+func (h healthRouting) checkDrain(ctx context.Context, client sshRunner, image string) error {
+	out, err := client.run(ctx, fmt.Sprintf(`docker image inspect --format "{{if .Config.Healthcheck}}{{json .Config.Healthcheck.Test}}{{end}}" %s`, image))
+	if err != nil {
+		return fmt.Errorf("inspecting image: %w", err)
+	}
+	if test := strings.TrimSpace(out); test != "" && test != `["NONE"]` {
+		return errors.New("the image has its own HEALTHCHECK")
+	}
+	if _, err := client.run(ctx, fmt.Sprintf("docker run --rm --network none --entrypoint /bin/sh %s -c :", image)); err != nil {
+		return errors.New("the image has no /bin/sh")
+	}
+	return nil
+}
+
+func (h healthRouting) unroute(ctx context.Context, client sshRunner, container string) error {
+	// Containers started before draining was configured, or from images
+	// that can't carry the marker healthcheck, keep being routed until
+	// they stop.
+	out, err := client.run(ctx, fmt.Sprintf(`docker inspect --format "{{json .Config.Healthcheck}}" %s`, container))
+	if err != nil {
+		return fmt.Errorf("inspecting container: %w", err)
+	}
+	if !strings.Contains(out, drainMarker) {
+		return errNoDrain
+	}
+	if _, err := client.run(ctx, fmt.Sprintf("docker exec %s /bin/sh -c %s", container, shellQuote(": > "+drainMarker))); err != nil {
+		return fmt.Errorf("creating drain marker: %w", err)
+	}
+	// The proxy only stops routing once docker reports the container
+	// unhealthy and it has applied that.
+	reportProgress(ctx, "waiting for old container to be unrouted...")
+	if err := waitDockerHealth(ctx, client, container, "unhealthy", h.interval, h.timeout); err != nil {
+		return fmt.Errorf("waiting for old container to turn unhealthy: %w", err)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(h.throttle):
+	}
+	return nil
+}
+
+type traefikProxy struct {
+	healthRouting
+}
+
+func (p *traefikProxy) runArgs(service string, svc serviceConfig, ec envConfig) []string {
+	cc := mergeContainer(svc.Container, ec.Container)
+	var labels []string
+	for _, l := range traefikLabels(service, svc.Port, ec) {
+		labels = append(labels, "--label", l)
+	}
+	if len(cc.Networks) > 0 {
+		labels = append(labels, "--label", "traefik.docker.network="+cc.Networks[0])
+	}
+	return append(labels, p.drainArgs(svc)...)
+}
+
+// traefikLabels renders the router, service and middleware labels for a
+// service's routing config. Inline middlewares are named <service>-<name>
+// because middleware names share one namespace per Traefik provider.
+func traefikLabels(service string, port int, ec envConfig) []string {
+	rc := ec.Routing
+	router := "traefik.http.routers." + service
+
+	labels := []string{
+		"traefik.enable=true",
+		fmt.Sprintf("%s.rule=%s", router, traefikRule(routeHosts(ec), rc.PathPrefix)),
+	}
+	if len(rc.EntryPoints) > 0 {
+		labels = append(labels, fmt.Sprintf("%s.entrypoints=%s", router, strings.Join(rc.EntryPoints, ",")))
+	}
+	if rc.TLS || rc.CertResolver != "" {
+		labels = append(labels, router+".tls=true")
+	}
+	if rc.CertResolver != "" {
+		labels = append(labels, fmt.Sprintf("%s.tls.certresolver=%s", router, rc.CertResolver))
+	}
+
+	var chain []string
+	for _, m := range rc.Middlewares {
+		if m.Ref != "" {
+			chain = append(chain, m.Ref)
+			continue
+		}
+		name := service + "-" + m.Name
+		chain = append(chain, name)
+		prefix := fmt.Sprintf("traefik.http.middlewares.%s.%s", name, m.Type)
+		if len(m.Options) == 0 {
+			labels = append(labels, prefix+"=true")
+		}
+		for _, k := range sortedKeys(m.Options) {
+			labels = append(labels, fmt.Sprintf("%s.%s=%s", prefix, k, m.Options[k]))
+		}
+	}
+	if len(chain) > 0 {
+		labels = append(labels, fmt.Sprintf("%s.middlewares=%s", router, strings.Join(chain, ",")))
+	}
+
+	return append(labels, fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port=%d", service, port))
+}
+
+func traefikRule(hosts []string, pathPrefix string) string {
+	var matchers []string
+	for _, h := range hosts {
+		matchers = append(matchers, fmt.Sprintf("Host(`%s`)", h))
+	}
+	rule := strings.Join(matchers, " || ")
+	if pathPrefix == "" {
+		return rule
+	}
+	if len(matchers) > 1 {
+		rule = "(" + rule + ")"
+	}
+	return fmt.Sprintf("%s && PathPrefix(`%s`)", rule, pathPrefix)
+}
+
+// routeHosts returns the hostnames a server service answers on in an env.
+func routeHosts(ec envConfig) []string {
+	if len(ec.Routing.Hosts) > 0 {
+		return ec.Routing.Hosts
+	}
+	return []string{ec.Host}
+}
+
+// defaultCaddyContainer is the caddy-docker-proxy container hoist talks to
+// when draining.
+const defaultCaddyContainer = "caddy"
+
+// caddyAdmin is Caddy's admin API as seen from inside its container.
+const caddyAdmin = "http://localhost:2019"
+
+// caddyProxy emits labels for caddy-docker-proxy. It routes every running
+// container with caddy labels, healthy or not, so with draining enabled
+// hoist edits Caddy's live config through its admin API instead: route
+// waits for the new container's upstream to appear, and unroute loads the
+// config without the old container's upstreams. caddy-docker-proxy only
+// reloads when the Caddyfile it generates changes, which next happens when
+// the old container stops.
+type caddyProxy struct {
+	container string // caddy-docker-proxy container; default caddy
+	interval  time.Duration
+	timeout   time.Duration
+}
+
+func (p *caddyProxy) runArgs(_ string, svc serviceConfig, ec envConfig) []string {
+	cc := mergeContainer(svc.Container, ec.Container)
+	upstream := fmt.Sprintf("{{upstreams %d}}", svc.Port)
+	if prefix := ec.Routing.PathPrefix; prefix != "" {
+		// Same string-prefix semantics as Traefik's PathPrefix.
+		upstream = prefix + "* " + upstream
+	}
+	args := []string{
+		"--label", "caddy=" + strings.Join(routeHosts(ec), ", "),
+		"--label", "caddy.reverse_proxy=" + upstream,
+	}
+	if len(cc.Networks) > 0 {
+		args = append(args, "--label", "caddy_ingress_network="+cc.Networks[0])
+	}
+	return args
+}
+
+func (p *caddyProxy) caddyContainer() string {
+	if p.container == "" {
+		return defaultCaddyContainer
+	}
+	return p.container
+}
+
+func (p *caddyProxy) route(ctx context.Context, client sshRunner, _, _, container string, svc serviceConfig) error {
+	if svc.Drain.Period == 0 {
+		return nil
+	}
+	ips, err := containerIPs(ctx, client, container)
+	if err != nil {
+		return err
+	}
+
+	// Don't unroute the old container before Caddy routes the new one.
+	reportProgress(ctx, "waiting for new container to be routed...")
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	deadline := time.After(p.timeout)
+	for {
+		conf, err := client.run(ctx, fmt.Sprintf("docker exec %s wget -qO- %s/config/", p.caddyContainer(), caddyAdmin))
+		if err != nil {
+			return fmt.Errorf("reading caddy config: %w", err)
+		}
+		for _, ip := range ips {
+			if strings.Contains(conf, fmt.Sprintf("%q", net.JoinHostPort(ip, fmt.Sprint(svc.Port)))) {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("caddy didn't route %s within %s", container, p.timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *caddyProxy) unroute(ctx context.Context, client sshRunner, container string) error {
+	ips, err := containerIPs(ctx, client, container)
+	if err != nil {
+		return err
+	}
+	out, err := client.run(ctx, fmt.Sprintf("docker exec %s wget -qO- %s/config/", p.caddyContainer(), caddyAdmin))
+	if err != nil {
+		return fmt.Errorf("reading caddy config: %w", err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(out))
+	dec.UseNumber()
+	var conf any
+	if err := dec.Decode(&conf); err != nil {
+		return fmt.Errorf("parsing caddy config: %w", err)
+	}
+	addrs := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		addrs[ip] = true
+	}
+	removed, emptied := dropUpstreams(conf, addrs)
+	if emptied {
+		return fmt.Errorf("unrouting %s would leave caddy with no upstream", container)
+	}
+	if removed == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(conf); err != nil {
+		return fmt.Errorf("encoding caddy config: %w", err)
+	}
+
+	tmp := fmt.Sprintf("/tmp/hoist-caddy-%s.json", container)
+	if err := client.upload(ctx, tmp, buf.Bytes()); err != nil {
+		return fmt.Errorf("uploading caddy config: %w", err)
+	}
+	// /load applies the config before it responds, so the old container gets
+	// no new requests once this returns.
+	caddy := p.caddyContainer()
+	cmd := fmt.Sprintf("docker cp %[1]s %[2]s:%[1]s && rm -f %[1]s && "+
+		"docker exec %[2]s wget -qO- --header 'Content-Type: application/json' --post-file %[1]s %[3]s/load",
+		tmp, caddy, caddyAdmin)
+	if _, err := client.run(ctx, cmd); err != nil {
+		return fmt.Errorf("loading caddy config: %w", err)
+	}
+	return nil
+}
+
+// dropUpstreams removes reverse_proxy upstreams that dial any of ips from a
+// decoded Caddy JSON config. It reports how many it removed and whether that
+// left any upstreams list empty.
+func dropUpstreams(v any, ips map[string]bool) (removed int, emptied bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if ups, ok := child.([]any); ok && k == "upstreams" {
+				var kept []any
+				for _, u := range ups {
+					m, _ := u.(map[string]any)
+					dial, _ := m["dial"].(string)
+					if host, _, err := net.SplitHostPort(dial); err == nil && ips[host] {
+						removed++
+						continue
+					}
+					kept = append(kept, u)
+				}
+				if len(kept) == 0 && len(ups) > 0 {
+					emptied = true
+				}
+				v[k] = kept
+				continue
+			}
+			n, e := dropUpstreams(child, ips)
+			removed, emptied = removed+n, emptied || e
+		}
+	case []any:
+		for _, child := range v {
+			n, e := dropUpstreams(child, ips)
+			removed, emptied = removed+n, emptied || e
+		}
+	}
+	return removed, emptied
+}
+
+// containerIPs returns a container's addresses on its docker networks.
+func containerIPs(ctx context.Context, client sshRunner, container string) ([]string, error) {
+	out, err := client.run(ctx, fmt.Sprintf(`docker inspect --format "{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}" %s`, container))
+	if err != nil {
+		return nil, fmt.Errorf("resolving container address: %w", err)
+	}
+	ips := strings.Fields(out)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("container %s has no network address", container)
+	}
+	return ips, nil
+}
+
+const (
+	defaultNginxConfDir = "/etc/nginx/conf.d"
+	defaultNginxReload  = "nginx -t && nginx -s reload"
+)
+
+// nginxProxy renders an upstream block per service and env pointing at the
+// current container by name and reloads nginx. The name is resolved through
+// Docker's DNS, so the container must share a network with nginx, and nginx
+// needs a resolver (127.0.0.11) and 1.27.3 or later for the resolve
+// parameter, which follows the container to a new IP when docker restarts
+// it. A reload lets old workers finish
+// their connections, so switching the upstream is also what unroutes the old
+// container. The server blocks that proxy_pass to the upstream are managed
+// outside hoist.
+type nginxProxy struct {
+	confDir string
+	reload  string
+}
+
+func (p *nginxProxy) runArgs(string, serviceConfig, envConfig) []string { return nil }
+
+func (p *nginxProxy) route(ctx context.Context, client sshRunner, service, env, container string, svc serviceConfig) error {
+	confDir := p.confDir
+	if confDir == "" {
+		confDir = defaultNginxConfDir
+	}
+	reload := p.reload
+	if reload == "" {
+		reload = defaultNginxReload
+	}
+
+	conf := path.Join(confDir, fmt.Sprintf("hoist-%s-%s.conf", service, env))
+	reportProgress(ctx, "updating nginx upstream...")
+	if err := client.upload(ctx, conf+".new", []byte(nginxUpstreamConf(service, env, container, svc.Port))); err != nil {
+		return fmt.Errorf("uploading nginx config: %w", err)
+	}
+	// Keep the previous config so a failed reload leaves nginx as it was.
+	cmd := fmt.Sprintf("rm -f %[1]s.bak; cp -f %[1]s %[1]s.bak 2>/dev/null; "+
+		"mv -f %[1]s.new %[1]s && { %[2]s; } || "+
+		"{ if [ -e %[1]s.bak ]; then mv -f %[1]s.bak %[1]s; else rm -f %[1]s; fi; false; }",
+		shellQuote(conf), reload)
+	if _, err := client.run(ctx, cmd); err != nil {
+		return fmt.Errorf("reloading nginx: %w", err)
+	}
+	return nil
+}
+
+func (p *nginxProxy) unroute(context.Context, sshRunner, string) error { return nil }
+
+// nginxUpstreamName is the upstream that nginx server blocks should
+// proxy_pass to for a service in an env.
+func nginxUpstreamName(service, env string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace("hoist_" + service + "_" + env)
+}
+
+func nginxUpstreamConf(service, env, container string, port int) string {
+	name := nginxUpstreamName(service, env)
+	return fmt.Sprintf("# Managed by hoist; changes are overwritten on deploy.\nupstream %s {\n    zone %s 64k;\n    server %s:%d resolve;\n}\n",
+		name, name, container, port)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraefikLabelsRouting(t *testing.T) {
+	ec := envConfig{
+		EnvFile: "/etc/backend/staging.env",
+		Routing: routingConfig{
+			Hosts:        []string{"api.staging.example.com", "staging.example.com"},
+			PathPrefix:   "/api",
+			EntryPoints:  []string{"websecure"},
+			CertResolver: "letsencrypt",
+			Middlewares: []middlewareConfig{
+				{Name: "auth", Type: "basicauth", Options: map[string]string{"users": "admin:$apr1$xyz"}},
+				{Name: "limit", Type: "ratelimit", Options: map[string]string{"average": "100", "burst": "50"}},
+				{Name: "gzip", Type: "compress"},
+				{Ref: "security-headers@file"},
+			},
+		},
+	}
+
+	got := traefikLabels("backend", 8080, ec)
+	want := []string{
+		"traefik.enable=true",
+		"traefik.http.routers.backend.rule=(Host(`api.staging.example.com`) || Host(`staging.example.com`)) && PathPrefix(`/api`)",
+		"traefik.http.routers.backend.entrypoints=websecure",
+		"traefik.http.routers.backend.tls=true",
+		"traefik.http.routers.backend.tls.certresolver=letsencrypt",
+		"traefik.http.middlewares.backend-auth.basicauth.users=admin:$apr1$xyz",
+		"traefik.http.middlewares.backend-limit.ratelimit.average=100",
+		"traefik.http.middlewares.backend-limit.ratelimit.burst=50",
+		"traefik.http.middlewares.backend-gzip.compress=true",
+		"traefik.http.routers.backend.middlewares=backend-auth,backend-limit,backend-gzip,security-headers@file",
+		"traefik.http.services.backend.loadbalancer.server.port=8080",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("traefikLabels() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTraefikRule(t *testing.T) {
+	tests := []struct {
+		hosts  []string
+		prefix string
+		want   string
+	}{
+		{[]string{"a.com"}, "", "Host(`a.com`)"},
+		{[]string{"a.com"}, "/api", "Host(`a.com`) && PathPrefix(`/api`)"},
+		{[]string{"a.com", "b.com"}, "", "Host(`a.com`) || Host(`b.com`)"},
+	}
+	for _, tt := range tests {
+		if got := traefikRule(tt.hosts, tt.prefix); got != tt.want {
+			t.Errorf("traefikRule(%v, %q) = %q, want %q", tt.hosts, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestCaddyProxyRunArgs(t *testing.T) {
+	svc := serviceConfig{Image: "myapp/backend", Port: 8080, Healthcheck: "/health", Drain: drainConfig{Period: 30 * time.Second}}
+	ec := envConfig{Host: "api.example.com", EnvFile: "/etc/backend/prod.env", Container: containerConfig{Networks: []string{"web"}}}
+
+	joined := strings.Join(buildDockerRunArgs("myapp", dockerRun{service: "backend", env: "production", tag: "main-abc1234-20250101000000"}, svc, ec, &caddyProxy{}), " ")
+	for _, want := range []string{
+		"--label caddy=api.example.com",
+		"--label caddy.reverse_proxy={{upstreams 8080}}",
+		"--label caddy_ingress_network=web",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected args to contain %q, got: %s", want, joined)
+		}
+	}
+	// Caddy drains through its admin API, not a healthcheck.
+	for _, unwanted := range []string{"traefik", "--health-cmd"} {
+		if strings.Contains(joined, unwanted) {
+			t.Errorf("expected no %q, got: %s", unwanted, joined)
+		}
+	}
+}
+
+func TestCaddyProxyRunArgsRouting(t *testing.T) {
+	svc := serviceConfig{Image: "myapp/backend", Port: 8080, Healthcheck: "/health"}
+	ec := envConfig{EnvFile: "/etc/backend/prod.env", Routing: routingConfig{Hosts: []string{"a.example.com", "b.example.com"}, PathPrefix: "/api"}}
+
+	joined := strings.Join((&caddyProxy{}).runArgs("backend", svc, ec), " ")
+	for _, want := range []string{
+		"--label caddy=a.example.com, b.example.com",
+		"--label caddy.reverse_proxy=/api* {{upstreams 8080}}",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected args to contain %q, got: %s", want, joined)
+		}
+	}
+}
+
+func TestNginxProxyRunArgs(t *testing.T) {
+	svc := serviceConfig{Image: "myapp/backend", Port: 8080, Healthcheck: "/health", Drain: drainConfig{Period: 30 * time.Second}}
+	ec := envConfig{Host: "api.example.com", EnvFile: "/etc/backend/prod.env"}
+
+	joined := strings.Join(buildDockerRunArgs("myapp", dockerRun{service: "backend", env: "production", tag: "main-abc1234-20250101000000"}, svc, ec, &nginxProxy{}), " ")
+	if strings.Contains(joined, "traefik") || strings.Contains(joined, "--health-cmd") {
+		t.Errorf("expected no proxy labels or drain healthcheck, got: %s", joined)
+	}
+}
+
+func TestNginxProxyRoute(t *testing.T) {
+	mock := &mockSSHRunner{}
+	p := &nginxProxy{}
+	svc := serviceConfig{Port: 8080}
+
+	err := p.route(context.Background(), mock, "backend", "production", "backend-main-abc1234-20250101000000", svc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conf, ok := mock.uploads["/etc/nginx/conf.d/hoist-backend-production.conf.new"]
+	if !ok {
+		t.Fatalf("expected upstream config upload, got %v", mock.uploads)
+	}
+	// The container's name follows it to a new IP if docker restarts it.
+	if !strings.Contains(conf, "upstream hoist_backend_production {") ||
+		!strings.Contains(conf, "zone hoist_backend_production 64k;") ||
+		!strings.Contains(conf, "server backend-main-abc1234-20250101000000:8080 resolve;") {
+		t.Errorf("unexpected upstream config:\n%s", conf)
+	}
+	if len(mock.commands) != 1 || !strings.Contains(mock.commands[0], "nginx -t && nginx -s reload") {
+		t.Errorf("expected reload command, got %v", mock.commands)
+	}
+}
+
+func TestNginxProxyRouteReloadError(t *testing.T) {
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{err: fmt.Errorf("nginx: configuration file test failed")},
+		},
+	}
+	p := &nginxProxy{confDir: "/srv/nginx", reload: "docker exec nginx nginx -s reload"}
+
+	err := p.route(context.Background(), mock, "backend", "production", "backend-main-abc1234-20250101000000", serviceConfig{Port: 8080})
+	if err == nil || !strings.Contains(err.Error(), "reloading nginx") {
+		t.Fatalf("expected reload error, got %v", err)
+	}
+	if !strings.Contains(mock.commands[0], "docker exec nginx nginx -s reload") {
+		t.Errorf("expected custom reload command, got %q", mock.commands[0])
+	}
+}
+
+func TestServerDeployNginxProxy(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxies = map[string]proxyConfig{"web1": {Type: "nginx"}}
+
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: ""},   // docker pull
+			{output: "id"}, // docker run
+			{output: "OK"}, // healthcheck
+			{output: ""},   // swap and reload
+		},
+	}
+	d := &serverDeployer{
+		cfg:          cfg,
+		dial:         func(_ string) (sshRunner, error) { return mock, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  1 * time.Second,
+	}
+
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(mock.commands[1], "traefik") {
+		t.Errorf("expected no traefik labels on nginx node, got: %s", mock.commands[1])
+	}
+	// The upstream must be switched before the old container is stopped.
+	if !strings.Contains(mock.commands[3], "nginx -s reload") || mock.commands[4] != "docker stop backend-main-old1234-20241231000000" {
+		t.Errorf("unexpected command order: %v", mock.commands)
+	}
+}
+
+const caddyTestConfig = `{"apps":{"http":{"servers":{"srv0":{"routes":[{"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"172.18.0.4:8080"},{"dial":"172.18.0.5:8080"}]}]}]}}}}}`
+
+func TestCaddyProxyRoute(t *testing.T) {
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: "172.18.0.5 "}, // docker inspect
+			{output: `{"apps":{}}`}, // not routed yet
+			{output: caddyTestConfig},
+		},
+	}
+	p := &caddyProxy{interval: time.Millisecond, timeout: time.Second}
+	svc := serviceConfig{Port: 8080, Drain: drainConfig{Period: 30 * time.Second}}
+
+	if err := p.route(context.Background(), mock, "backend", "production", "backend-main-abc1234-20250101000000", svc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.commands) != 3 || mock.commands[2] != "docker exec caddy wget -qO- http://localhost:2019/config/" {
+		t.Errorf("expected caddy config to be polled until routed, got %v", mock.commands)
+	}
+}
+
+func TestCaddyProxyUnroute(t *testing.T) {
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: "172.18.0.4 "},   // docker inspect
+			{output: caddyTestConfig}, // config
+			{output: ""},              // load
+		},
+	}
+	p := &caddyProxy{container: "caddy-proxy"}
+
+	if err := p.unroute(context.Background(), mock, "backend-main-old1234-20241231000000"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf := mock.uploads["/tmp/hoist-caddy-backend-main-old1234-20241231000000.json"]
+	if strings.Contains(conf, "172.18.0.4") || !strings.Contains(conf, `{"dial":"172.18.0.5:8080"}`) {
+		t.Errorf("expected only the old upstream to be dropped, got %s", conf)
+	}
+	if !strings.Contains(mock.commands[2], "docker exec caddy-proxy wget") || !strings.HasSuffix(mock.commands[2], "http://localhost:2019/load") {
+		t.Errorf("expected config load through caddy-proxy, got %q", mock.commands[2])
+	}
+}
+
+func TestCaddyProxyUnrouteLastUpstream(t *testing.T) {
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: "172.18.0.4 "},
+			{output: `{"upstreams":[{"dial":"172.18.0.4:8080"}]}`},
+		},
+	}
+	err := (&caddyProxy{}).unroute(context.Background(), mock, "backend-main-old1234-20241231000000")
+	if err == nil || !strings.Contains(err.Error(), "no upstream") {
+		t.Fatalf("expected error, got %v", err)
+	}
+	if len(mock.uploads) != 0 {
+		t.Errorf("expected no config load, got %v", mock.uploads)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultPruneKeep is how many images besides the running one are kept per
+// service, so a rollback doesn't have to pull again.
+const defaultPruneKeep = 2
+
+// pruneItem is a container or image that prune removes.
+type pruneItem struct {
+	kind string // "container" or "image"
+	ref  string // container name, or image repo:tag / repo@digest
+	size int64  // approximate; shared image layers are counted for each image
+}
+
+// planPrune lists what pruning a service on a node would remove: stopped
+// hoist-managed containers of the service, and images of its repository
+// other than the ones running and the keep most recent others.
+func planPrune(ctx context.Context, client sshRunner, service string, svc serviceConfig, keep int) ([]pruneItem, error) {
+	// Every container hoist starts carries a hoist.previous label, and
+	// newer ones a hoist.service label. Names alone can't tell services
+	// apart: api-worker-<tag> looks like a container of api.
+	out, err := client.run(ctx, `docker ps -a -s --filter label=hoist.previous --format "{{.Names}}\t{{.State}}\t{{.Image}}\t{{.Size}}\t{{.Label \"hoist.service\"}}"`)
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+
+	var items []pruneItem
+	inUse := map[string]bool{} // image refs of containers that stay
+	for _, line := range splitLines(out) {
+		f := strings.Split(line, "\t")
+		if len(f) < 5 || !isImageOf(f[2], svc.Image) {
+			continue
+		}
+		owner := f[4]
+		if f[1] == "running" {
+			// Containers started before the service label may belong to
+			// the service; keeping their images is the safe guess.
+			if owner == service || owner == "" && strings.HasPrefix(f[0], service+"-") {
+				inUse[f[2]] = true
+			}
+			continue
+		}
+		if owner == service {
+			items = append(items, pruneItem{kind: "container", ref: f[0], size: parseDockerSize(f[3])})
+		}
+	}
+
+	// docker images lists newest first.
+	out, err = client.run(ctx, fmt.Sprintf(`docker images --digests --format "{{.Tag}}\t{{.Digest}}\t{{.Size}}" %s`, svc.Image))
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+	kept := 0
+	for _, line := range splitLines(out) {
+		f := strings.Split(line, "\t")
+		if len(f) < 3 {
+			continue
+		}
+		tag, digest := f[0], f[1]
+		byTag := svc.Image + ":" + tag
+		byDigest := svc.Image + "@" + digest
+		if inUse[byTag] || inUse[byDigest] {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		ref := byTag
+		if tag == "<none>" {
+			ref = byDigest
+		}
+		items = append(items, pruneItem{kind: "image", ref: ref, size: parseDockerSize(f[2])})
+	}
+	return items, nil
+}
+
+// applyPrune removes the planned items. Containers go first so their images
+// are no longer referenced. Items that fail to be removed (e.g. an image a
+// non-hoist container still uses) are reported and skipped.
+func applyPrune(ctx context.Context, client sshRunner, items []pruneItem) (removed []pruneItem, errs []error) {
+	for _, kind := range []string{"container", "image"} {
+		for _, it := range items {
+			if it.kind != kind {
+				continue
+			}
+			cmd := "docker rm " + shellQuote(it.ref)
+			if kind == "image" {
+				cmd = "docker rmi " + shellQuote(it.ref)
+			}
+			if _, err := client.run(ctx, cmd); err != nil {
+				errs = append(errs, fmt.Errorf("removing %s %s: %w", it.kind, it.ref, err))
+				continue
+			}
+			removed = append(removed, it)
+		}
+	}
+	return removed, errs
+}
+
+// pruneService plans and applies a prune for one service. It's used after
+// deploys, where a failure to prune must not fail the deploy.
+func pruneService(ctx context.Context, client sshRunner, service string, svc serviceConfig, keep int) (int, int64, error) {
+	items, err := planPrune(ctx, client, service, svc, keep)
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, errs := applyPrune(ctx, client, items)
+	var size int64
+	for _, it := range removed {
+		size += it.size
+	}
+	if len(errs) > 0 {
+		return len(removed), size, errs[0]
+	}
+	return len(removed), size, nil
+}
+
+type pruneOpts struct {
+	Services []string // empty means all server and worker services
+	Env      string   // empty means all envs
+	Keep     int      // -1 means use each service's prune.keep
+	DryRun   bool
+}
+
+// runPrune prunes every node that runs one of the selected services,
+// printing what was (or would be) removed and the space reclaimed.
+func runPrune(ctx context.Context, cfg config, dial func(addr string) (sshRunner, error), opts pruneOpts, w io.Writer) error {
+	services := opts.Services
+	if len(services) == 0 {
+		services = sortedServiceNames(cfg)
+	}
+
+	// node -> services deployed there
+	byNode := map[string][]string{}
+	for _, name := range services {
+		svc, ok := cfg.Services[name]
+		if !ok {
+			return fmt.Errorf("unknown service: %q", name)
+		}
+		if svc.Type != "server" && svc.Type != "worker" {
+			continue
+		}
+		seen := map[string]bool{}
+		for envName, ec := range svc.Env {
+			if opts.Env != "" && envName != opts.Env {
+				continue
+			}
+			if !seen[ec.Node] {
+				seen[ec.Node] = true
+				byNode[ec.Node] = append(byNode[ec.Node], name)
+			}
+		}
+	}
+	if len(byNode) == 0 {
+		return fmt.Errorf("no server or worker services to prune")
+	}
+
+	nodes := make([]string, 0, len(byNode))
+	for node := range byNode {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	type result struct {
+		report string
+		err    error
+	}
+	results := make([]result, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			report, err := pruneNode(ctx, cfg, dial, node, byNode[node], opts)
+			results[i] = result{report: report, err: err}
+		}(i, node)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, r := range results {
+		fmt.Fprint(w, r.report)
+		if r.err != nil {
+			fmt.Fprintf(w, "%s: %v\n", nodes[i], r.err)
+			failed = append(failed, nodes[i])
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("prune failed on: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func pruneNode(ctx context.Context, cfg config, dial func(addr string) (sshRunner, error), node string, services []string, opts pruneOpts) (string, error) {
+	addr := cfg.Nodes[node]
+	client, err := dial(addr)
+	if err != nil {
+		return "", fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	defer client.close()
+
+	var b strings.Builder
+	var total int64
+	var errs []error
+	verb := "removed"
+	if opts.DryRun {
+		verb = "would remove"
+	}
+	for _, name := range services {
+		svc := cfg.Services[name]
+		keep := opts.Keep
+		if keep < 0 {
+			keep = pruneKeep(svc)
+		}
+		items, err := planPrune(ctx, client, name, svc, keep)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if !opts.DryRun {
+			var applyErrs []error
+			items, applyErrs = applyPrune(ctx, client, items)
+			errs = append(errs, applyErrs...)
+		}
+		for _, it := range items {
+			fmt.Fprintf(&b, "%s: %s %s %s (%s)\n", node, verb, it.kind, it.ref, formatBytes(it.size))
+			total += it.size
+		}
+	}
+	if opts.DryRun {
+		fmt.Fprintf(&b, "%s: would reclaim up to %s\n", node, formatBytes(total))
+	} else {
+		fmt.Fprintf(&b, "%s: reclaimed up to %s\n", node, formatBytes(total))
+	}
+	for _, err := range errs {
+		fmt.Fprintf(&b, "%s: warning: %v\n", node, err)
+	}
+	return b.String(), nil
+}
+
+func pruneKeep(svc serviceConfig) int {
+	if svc.Prune.Keep != nil {
+		return *svc.Prune.Keep
+	}
+	return defaultPruneKeep
+}
+
+// isImageOf reports whether ref (as shown by docker ps) is a tag or digest
+// of image.
+func isImageOf(ref, image string) bool {
+	return strings.HasPrefix(ref, image+":") || strings.HasPrefix(ref, image+"@")
+}
+
+// parseDockerSize parses sizes as docker prints them ("12.3MB", "0B",
+// "1.2kB (virtual 120MB)"). Docker uses decimal units.
+func parseDockerSize(s string) int64 {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0
+	}
+	s = fields[0]
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"kB", 1e3}, {"B", 1},
+	}
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, u.suffix), 64)
+			if err != nil {
+				return 0
+			}
+			return int64(n * u.mult)
+		}
+	}
+	return 0
+}
+
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const prunePSOutput = "backend-t5\trunning\tmyapp/backend@sha256:e5\t1kB (virtual 120MB)\tbackend\n" +
+	"backend-t4\texited\tmyapp/backend:t4\t2MB (virtual 118MB)\tbackend\n" +
+	"backend-worker-t5\texited\tmyapp/worker:t5\t1MB (virtual 90MB)\tbackend-worker\n"
+
+const pruneImagesOutput = "<none>\tsha256:e5\t120MB\n" +
+	"t4\tsha256:e4\t118MB\n" +
+	"t3\tsha256:e3\t117MB\n" +
+	"t2\tsha256:e2\t116MB\n" +
+	"<none>\tsha256:e1\t115MB\n"
+
+func TestPlanPrune(t *testing.T) {
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: prunePSOutput},
+			{output: pruneImagesOutput},
+		},
+	}
+	svc := serviceConfig{Image: "myapp/backend"}
+
+	items, err := planPrune(context.Background(), mock, "backend", svc, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, it := range items {
+		got = append(got, it.kind+" "+it.ref)
+	}
+	want := []string{
+		"container backend-t4",
+		"image myapp/backend:t2",
+		"image myapp/backend@sha256:e1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("plan =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if items[0].size != 2_000_000 || items[1].size != 116_000_000 {
+		t.Errorf("sizes = %d, %d", items[0].size, items[1].size)
+	}
+	if !strings.Contains(mock.commands[1], "docker images --digests") || !strings.HasSuffix(mock.commands[1], " myapp/backend") {
+		t.Errorf("images command = %q", mock.commands[1])
+	}
+}
+
+func TestPlanPruneSharedImage(t *testing.T) {
+	// api and api-worker run the same image; api-worker's names start
+	// with "api-".
+	ps := "api-main-abc1234-20250102000000\trunning\tmyapp/api:main-abc1234-20250102000000\t1kB\tapi\n" +
+		"api-main-old1234-20250101000000\texited\tmyapp/api:main-old1234-20250101000000\t1kB\tapi\n" +
+		"api-worker-main-abc1234-20250102000000\trunning\tmyapp/api:main-abc1234-20250102000000\t1kB\tapi-worker\n" +
+		"api-worker-main-old1234-20250101000000\texited\tmyapp/api:main-old1234-20250101000000\t1kB\tapi-worker\n" +
+		"api-main-older12-20241231000000\texited\tmyapp/api:main-older12-20241231000000\t1kB\t\n"
+	svc := serviceConfig{Image: "myapp/api"}
+
+	for service, want := range map[string]string{
+		"api":        "api-main-old1234-20250101000000",
+		"api-worker": "api-worker-main-old1234-20250101000000",
+	} {
+		mock := &mockSSHRunner{responses: []mockRunResult{{output: ps}, {output: ""}}}
+		items, err := planPrune(context.Background(), mock, service, svc, 2)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", service, err)
+		}
+		// Containers without a service label are never removed.
+		if len(items) != 1 || items[0].ref != want {
+			t.Errorf("%s: plan = %+v, want only container %s", service, items, want)
+		}
+	}
+}
+
+func TestApplyPruneContinuesOnError(t *testing.T) {
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: ""},                             // docker rm
+			{err: fmt.Errorf("image is being used")}, // docker rmi t2
+			{output: ""},                             // docker rmi e1
+		},
+	}
+	items := []pruneItem{
+		{kind: "image", ref: "myapp/backend:t2"},
+		{kind: "container", ref: "backend-t4"},
+		{kind: "image", ref: "myapp/backend@sha256:e1"},
+	}
+
+	removed, errs := applyPrune(context.Background(), mock, items)
+	if len(removed) != 2 || len(errs) != 1 {
+		t.Fatalf("removed %d, errors %v", len(removed), errs)
+	}
+	if mock.commands[0] != "docker rm backend-t4" {
+		t.Errorf("containers should be removed first, got %q", mock.commands[0])
+	}
+}
+
+func TestRunPruneDryRun(t *testing.T) {
+	cfg := testConfig()
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: prunePSOutput},
+			{output: pruneImagesOutput},
+		},
+	}
+	var dialed []string
+	dial := func(addr string) (sshRunner, error) {
+		dialed = append(dialed, addr)
+		return mock, nil
+	}
+
+	var out bytes.Buffer
+	err := runPrune(context.Background(), cfg, dial, pruneOpts{Env: "staging", Keep: 1, DryRun: true}, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dialed) != 1 || dialed[0] != "10.0.0.1" {
+		t.Errorf("dialed %v, want only the staging node", dialed)
+	}
+	for _, cmd := range mock.commands {
+		if strings.HasPrefix(cmd, "docker rm") {
+			t.Errorf("dry run ran %q", cmd)
+		}
+	}
+	report := out.String()
+	if !strings.Contains(report, "web1: would remove image myapp/backend:t3") || !strings.Contains(report, "would reclaim up to") {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+}
+
+func TestParseDockerSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0B", 0},
+		{"12.5kB", 12500},
+		{"118MB", 118_000_000},
+		{"1.2GB", 1_200_000_000},
+		{"2MB (virtual 118MB)", 2_000_000},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := parseDockerSize(tt.in); got != tt.want {
+			t.Errorf("parseDockerSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pullProgressPrefix starts every progress line a pull reports, so output
+// that isn't redrawn in place can print them periodically instead of all.
+const pullProgressPrefix = "pulling image: "
+
+// pullReportInterval limits how often a pull reports progress.
+const pullReportInterval = 500 * time.Millisecond
+
+type layerProgress struct {
+	current, total int64
+	done           bool
+}
+
+// pullProgress aggregates the per-layer lines docker pull prints when
+// attached to a terminal, e.g.
+//
+//	a2abf6c4d29d: Downloading [=====>      ]  12.3MB/45.6MB
+//	a2abf6c4d29d: Pull complete
+type pullProgress struct {
+	layers map[string]*layerProgress
+}
+
+var (
+	ansiEscapeRe   = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+	pullLayerRe    = regexp.MustCompile(`^([0-9a-f]{12}): (.+)$`)
+	pullDownloadRe = regexp.MustCompile(`^Downloading\s+(?:\[[=> ]*\]\s+)?(\S+)/(\S+)$`)
+)
+
+// parse updates the progress from one line of pull output. It reports
+// whether the line was a layer status line.
+func (p *pullProgress) parse(line string) bool {
+	m := pullLayerRe.FindStringSubmatch(strings.TrimSpace(ansiEscapeRe.ReplaceAllString(line, "")))
+	if m == nil {
+		return false
+	}
+	if p.layers == nil {
+		p.layers = make(map[string]*layerProgress)
+	}
+	l, ok := p.layers[m[1]]
+	if !ok {
+		l = &layerProgress{}
+		p.layers[m[1]] = l
+	}
+
+	status := m[2]
+	if d := pullDownloadRe.FindStringSubmatch(status); d != nil {
+		l.current = parseDockerSize(d[1])
+		l.total = parseDockerSize(d[2])
+		return true
+	}
+	switch {
+	case status == "Already exists", status == "Download complete", status == "Pull complete",
+		strings.HasPrefix(status, "Verifying Checksum"), strings.HasPrefix(status, "Extracting"):
+		l.done = true
+		l.current = l.total
+	}
+	return true
+}
+
+// String summarises the download, e.g. "12.3 MiB / 45.6 MiB (27%), 2/5 layers".
+// Layers that haven't started downloading have no known size yet, so the
+// total can grow as the pull goes on.
+func (p *pullProgress) String() string {
+	var current, total int64
+	done := 0
+	for _, l := range p.layers {
+		current += l.current
+		total += l.total
+		if l.done {
+			done++
+		}
+	}
+	layers := fmt.Sprintf("%d/%d layers", done, len(p.layers))
+	if total == 0 {
+		return layers
+	}
+	return fmt.Sprintf("%s / %s (%d%%), %s", formatBytes(current), formatBytes(total), current*100/total, layers)
+}
+
+// pullWriter turns docker pull output into progress reports. Lines that
+// aren't layer progress are kept so a failed pull can show why. It is
+// safe for concurrent use, since SSH sessions write stdout and stderr
+// from separate goroutines.
+type pullWriter struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	now      func() time.Time // nil means time.Now
+	progress pullProgress
+	last     time.Time
+	buf      []byte
+	lines    []string
+}
+
+func (w *pullWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.line(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *pullWriter) line(s string) {
+	if w.progress.parse(s) {
+		now := time.Now
+		if w.now != nil {
+			now = w.now
+		}
+		if t := now(); t.Sub(w.last) >= pullReportInterval {
+			w.last = t
+			reportProgress(w.ctx, "%s%s", pullProgressPrefix, w.progress.String())
+		}
+		return
+	}
+	s = strings.TrimSpace(ansiEscapeRe.ReplaceAllString(s, ""))
+	if s == "" {
+		return
+	}
+	w.lines = append(w.lines, s)
+	if len(w.lines) > lineWriterTail {
+		w.lines = w.lines[1:]
+	}
+}
+
+func (w *pullWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.line(string(w.buf))
+		w.buf = nil
+	}
+}
+
+func (w *pullWriter) tail() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return strings.Join(w.lines, "\n")
+}
+
+// progressPrinter prints deploy progress as plain lines for output that
+// can't be redrawn in place. Pull progress is printed at most every
+// interval per service; everything else is printed as it arrives.
+type progressPrinter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	interval time.Duration    // 0 means progressPrintInterval
+	now      func() time.Time // nil means time.Now
+	last     map[string]time.Time
+}
+
+// progressPrintInterval is how often plain output repeats pull progress.
+const progressPrintInterval = 10 * time.Second
+
+func (p *progressPrinter) print(service, detail string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if strings.HasPrefix(detail, pullProgressPrefix) {
+		now := time.Now
+		if p.now != nil {
+			now = p.now
+		}
+		interval := p.interval
+		if interval == 0 {
+			interval = progressPrintInterval
+		}
+		t := now()
+		if last, ok := p.last[service]; ok && t.Sub(last) < interval {
+			return
+		}
+		if p.last == nil {
+			p.last = make(map[string]time.Time)
+		}
+		p.last[service] = t
+	}
+	fmt.Fprintf(p.w, "  %s: %s\n", service, detail)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPullProgressParse(t *testing.T) {
+	var p pullProgress
+	lines := []string{
+		"main-abc1234: Pulling from myapp/backend",
+		"\x1b[1A\x1b[2K1111111111aa: Already exists",
+		"2222222222bb: Pulling fs layer",
+		"\x1b[2K3333333333cc: Downloading [=====>                                             ]  1.5MB/15MB",
+		"\x1b[2K2222222222bb: Downloading [==================================================>]  5MB/5MB",
+		"2222222222bb: Download complete",
+	}
+	for _, l := range lines {
+		p.parse(l)
+	}
+	if p.parse("Digest: sha256:abc") {
+		t.Error("digest line should not be a layer line")
+	}
+
+	got := p.String()
+	want := "6.2 MiB / 19.1 MiB (32%), 2/3 layers"
+	if got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestPullProgressNoSizes(t *testing.T) {
+	var p pullProgress
+	p.parse("1111111111aa: Pulling fs layer")
+	p.parse("2222222222bb: Already exists")
+	if got := p.String(); got != "1/2 layers" {
+		t.Errorf("String() = %q, want layer count only", got)
+	}
+}
+
+func TestPullWriterThrottles(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var reports []string
+	ctx := withProgress(context.Background(), func(d string) { reports = append(reports, d) })
+	w := &pullWriter{ctx: ctx, now: func() time.Time { return now }}
+
+	w.Write([]byte("1111111111aa: Downloading [>   ]  1MB/10MB\r\n"))
+	w.Write([]byte("1111111111aa: Downloading [=>  ]  2MB/10MB\r\n"))
+	now = now.Add(pullReportInterval)
+	w.Write([]byte("1111111111aa: Downloading [==> ]  5MB/10MB\r\nerror pulling: denied"))
+	w.flush()
+
+	if len(reports) != 2 {
+		t.Fatalf("reports = %q, want 2 (second throttled)", reports)
+	}
+	if !strings.HasPrefix(reports[1], pullProgressPrefix) || !strings.Contains(reports[1], "(50%)") {
+		t.Errorf("reports[1] = %q, want 50%% progress", reports[1])
+	}
+	if w.tail() != "error pulling: denied" {
+		t.Errorf("tail = %q, want the non-progress line", w.tail())
+	}
+}
+
+func TestPullWriterConcurrentWrites(t *testing.T) {
+	w := &pullWriter{ctx: context.Background()}
+	var wg sync.WaitGroup
+	for _, stream := range []string{"out", "err"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				fmt.Fprintf(w, "%s %d\n", stream, i)
+			}
+		}()
+	}
+	wg.Wait()
+	w.flush()
+
+	if n := len(strings.Split(w.tail(), "\n")); n != lineWriterTail {
+		t.Errorf("tail has %d lines, want %d", n, lineWriterTail)
+	}
+}
+
+func TestProgressPrinterThrottlesPullProgress(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var b strings.Builder
+	p := &progressPrinter{w: &b, now: func() time.Time { return now }}
+
+	p.print("backend", "pulling image...")
+	p.print("backend", pullProgressPrefix+"1 MiB / 10 MiB (10%), 0/1 layers")
+	p.print("worker", pullProgressPrefix+"1 MiB / 2 MiB (50%), 0/1 layers")
+	now = now.Add(time.Second)
+	p.print("backend", pullProgressPrefix+"2 MiB / 10 MiB (20%), 0/1 layers")
+	now = now.Add(progressPrintInterval)
+	p.print("backend", pullProgressPrefix+"9 MiB / 10 MiB (90%), 0/1 layers")
+	p.print("backend", "waiting for healthcheck...")
+
+	want := strings.Join([]string{
+		"  backend: pulling image...",
+		"  backend: pulling image: 1 MiB / 10 MiB (10%), 0/1 layers",
+		"  worker: pulling image: 1 MiB / 2 MiB (50%), 0/1 layers",
+		"  backend: pulling image: 9 MiB / 10 MiB (90%), 0/1 layers",
+		"  backend: waiting for healthcheck...",
+	}, "\n") + "\n"
+	if b.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestServerDeployReportsPullProgress(t *testing.T) {
+	cfg := testConfig()
+	mock := &mockSSHRunner{responses: []mockRunResult{
+		{output: "1111111111aa: Downloading [==>  ]  3MB/6MB"},
+	}}
+	d := &serverDeployer{
+		cfg:          cfg,
+		dial:         func(_ string) (sshRunner, error) { return mock, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  1 * time.Second,
+	}
+
+	var reports []string
+	ctx := withProgress(context.Background(), func(d string) { reports = append(reports, d) })
+	if err := d.deploy(ctx, "backend", "staging", "main-abc1234-20250101000000", "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, r := range reports {
+		if r == pullProgressPrefix+"2.9 MiB / 5.7 MiB (50%), 0/1 layers" {
+			return
+		}
+	}
+	t.Errorf("expected pull progress report, got %q", reports)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+type ecrAuthAPI interface {
+	GetAuthorizationToken(ctx context.Context, params *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error)
+}
+
+type registryCredential struct {
+	username string
+	password string
+	expires  time.Time // zero means no expiry
+}
+
+// registryAuth logs nodes into the registry of the image being deployed.
+// ECR tokens are fetched locally with GetAuthorizationToken; other
+// registries use credentials from the registries section of the config.
+type registryAuth struct {
+	registries map[string]registryConfig
+	ecr        func(region string) ecrAuthAPI
+	getenv     func(string) string                                            // nil means os.Getenv
+	helper     func(ctx context.Context, name, server string) ([]byte, error) // nil means runCredentialHelper
+	now        func() time.Time                                               // nil means time.Now
+
+	mu    sync.Mutex
+	cache map[string]registryCredential // keyed by registry host
+}
+
+// login runs docker login on the node for image's registry. Images from
+// registries hoist has no credentials for are pulled anonymously.
+func (a *registryAuth) login(ctx context.Context, client sshRunner, image string) error {
+	if image == "" {
+		return nil
+	}
+	host := registryHost(image)
+	cred, ok, err := a.credentials(ctx, host)
+	if err != nil {
+		return fmt.Errorf("getting credentials for %s: %w", host, err)
+	}
+	if !ok {
+		return nil
+	}
+
+	reportProgress(ctx, "logging in to %s...", host)
+	cmd := fmt.Sprintf("docker login --username %s --password-stdin %s", shellQuote(cred.username), shellQuote(host))
+	var out bytes.Buffer
+	if err := client.streamWithInput(ctx, cmd, &out, strings.NewReader(cred.password)); err != nil {
+		return fmt.Errorf("docker login %s: %w: %s", host, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+func (a *registryAuth) credentials(ctx context.Context, host string) (registryCredential, bool, error) {
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cred, ok := a.cache[host]; ok && (cred.expires.IsZero() || now().Before(cred.expires.Add(-time.Minute))) {
+		return cred, true, nil
+	}
+
+	var cred registryCredential
+	if rc, ok := a.registries[host]; ok {
+		c, err := a.configured(ctx, host, rc)
+		if err != nil {
+			return registryCredential{}, false, err
+		}
+		cred = c
+	} else if region, ok := ecrRegion(host); ok && a.ecr != nil {
+		c, err := ecrCredential(ctx, a.ecr(region))
+		if err != nil {
+			return registryCredential{}, false, err
+		}
+		cred = c
+	} else {
+		return registryCredential{}, false, nil
+	}
+
+	if a.cache == nil {
+		a.cache = make(map[string]registryCredential)
+	}
+	a.cache[host] = cred
+	return cred, true, nil
+}
+
+func (a *registryAuth) configured(ctx context.Context, host string, rc registryConfig) (registryCredential, error) {
+	switch {
+	case rc.CredentialHelper != "":
+		helper := a.helper
+		if helper == nil {
+			helper = runCredentialHelper
+		}
+		out, err := helper(ctx, rc.CredentialHelper, host)
+		if err != nil {
+			return registryCredential{}, fmt.Errorf("credential helper %s: %w", rc.CredentialHelper, err)
+		}
+		var resp struct {
+			Username string `json:"Username"`
+			Secret   string `json:"Secret"`
+		}
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return registryCredential{}, fmt.Errorf("credential helper %s: parsing output: %w", rc.CredentialHelper, err)
+		}
+		return registryCredential{username: resp.Username, password: resp.Secret}, nil
+	case rc.PasswordEnv != "":
+		getenv := a.getenv
+		if getenv == nil {
+			getenv = os.Getenv
+		}
+		pw := getenv(rc.PasswordEnv)
+		if pw == "" {
+			return registryCredential{}, fmt.Errorf("%s is not set", rc.PasswordEnv)
+		}
+		return registryCredential{username: rc.Username, password: pw}, nil
+	default:
+		return registryCredential{username: rc.Username, password: rc.Password}, nil
+	}
+}
+
+func ecrCredential(ctx context.Context, api ecrAuthAPI) (registryCredential, error) {
+	out, err := api.GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return registryCredential{}, fmt.Errorf("getting ECR authorization token: %w", err)
+	}
+	if len(out.AuthorizationData) == 0 || out.AuthorizationData[0].AuthorizationToken == nil {
+		return registryCredential{}, fmt.Errorf("ECR returned no authorization token")
+	}
+	data := out.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(*data.AuthorizationToken)
+	if err != nil {
+		return registryCredential{}, fmt.Errorf("decoding ECR authorization token: %w", err)
+	}
+	user, pw, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return registryCredential{}, fmt.Errorf("malformed ECR authorization token")
+	}
+	cred := registryCredential{username: user, password: pw}
+	if data.ExpiresAt != nil {
+		cred.expires = *data.ExpiresAt
+	}
+	return cred, nil
+}
+
+// runCredentialHelper asks a docker credential helper (docker-credential-<name>,
+// e.g. osxkeychain or secretservice) for a registry's credentials.
+func runCredentialHelper(ctx context.Context, name, server string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "docker-credential-"+name, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// registryHost returns the registry an image reference is pulled from,
+// following docker's rule that the first path component is a registry only
+// if it looks like a hostname.
+func registryHost(image string) string {
+	first, _, ok := strings.Cut(image, "/")
+	if !ok || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		return "docker.io"
+	}
+	return first
+}
+
+var ecrHostRe = regexp.MustCompile(`^\d+\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// ecrRegion returns the AWS region of an ECR registry host.
+func ecrRegion(host string) (string, bool) {
+	m := ecrHostRe.FindStringSubmatch(host)
+	if m == nil {
+		return "", false
+	}
+	return m[2], true
+}
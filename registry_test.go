@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+type mockECRAuth struct {
+	token   string
+	expires time.Time
+	err     error
+	calls   int
+}
+
+func (m *mockECRAuth) GetAuthorizationToken(_ context.Context, _ *ecr.GetAuthorizationTokenInput, _ ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	token := base64.StdEncoding.EncodeToString([]byte(m.token))
+	return &ecr.GetAuthorizationTokenOutput{
+		AuthorizationData: []types.AuthorizationData{{AuthorizationToken: &token, ExpiresAt: &m.expires}},
+	}, nil
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := []struct {
+		image, want string
+	}{
+		{"123456789012.dkr.ecr.us-east-1.amazonaws.com/myapp", "123456789012.dkr.ecr.us-east-1.amazonaws.com"},
+		{"ghcr.io/acme/api", "ghcr.io"},
+		{"localhost:5000/api", "localhost:5000"},
+		{"localhost/api", "localhost"},
+		{"myapp/backend", "docker.io"},
+		{"nginx", "docker.io"},
+	}
+	for _, tt := range tests {
+		if got := registryHost(tt.image); got != tt.want {
+			t.Errorf("registryHost(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestECRRegion(t *testing.T) {
+	if r, ok := ecrRegion("123456789012.dkr.ecr.eu-west-2.amazonaws.com"); !ok || r != "eu-west-2" {
+		t.Errorf("ecrRegion = %q, %v; want eu-west-2", r, ok)
+	}
+	if _, ok := ecrRegion("ghcr.io"); ok {
+		t.Error("ghcr.io should not be an ECR registry")
+	}
+}
+
+func TestRegistryLoginECR(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	api := &mockECRAuth{token: "AWS:secret-token", expires: now.Add(12 * time.Hour)}
+	var region string
+	a := &registryAuth{
+		ecr: func(r string) ecrAuthAPI {
+			region = r
+			return api
+		},
+		now: func() time.Time { return now },
+	}
+
+	mock := &mockSSHRunner{}
+	image := "123456789012.dkr.ecr.us-east-1.amazonaws.com/myapp"
+	for i := 0; i < 2; i++ {
+		if err := a.login(context.Background(), mock, image); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if region != "us-east-1" {
+		t.Errorf("region = %q, want us-east-1", region)
+	}
+	if api.calls != 1 {
+		t.Errorf("GetAuthorizationToken called %d times, want 1 (cached)", api.calls)
+	}
+	want := "docker login --username AWS --password-stdin 123456789012.dkr.ecr.us-east-1.amazonaws.com"
+	if len(mock.commands) != 2 || mock.commands[0] != want {
+		t.Fatalf("commands = %v, want %q twice", mock.commands, want)
+	}
+	if mock.stdins[0] != "secret-token" {
+		t.Errorf("stdin = %q, want the token", mock.stdins[0])
+	}
+	for _, cmd := range mock.commands {
+		if strings.Contains(cmd, "secret-token") {
+			t.Errorf("password leaked into command line: %s", cmd)
+		}
+	}
+}
+
+func TestRegistryLoginECRTokenExpired(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	api := &mockECRAuth{token: "AWS:tok", expires: now.Add(time.Hour)}
+	a := &registryAuth{
+		ecr: func(string) ecrAuthAPI { return api },
+		now: func() time.Time { return now },
+	}
+	image := "123456789012.dkr.ecr.us-east-1.amazonaws.com/myapp"
+
+	a.login(context.Background(), &mockSSHRunner{}, image)
+	now = now.Add(2 * time.Hour)
+	a.login(context.Background(), &mockSSHRunner{}, image)
+
+	if api.calls != 2 {
+		t.Errorf("GetAuthorizationToken called %d times, want 2 after expiry", api.calls)
+	}
+}
+
+func TestRegistryLoginConfigured(t *testing.T) {
+	a := &registryAuth{
+		registries: map[string]registryConfig{
+			"ghcr.io":       {Username: "deploy", PasswordEnv: "GHCR_TOKEN"},
+			"registry.acme": {CredentialHelper: "osxkeychain"},
+		},
+		getenv: func(k string) string {
+			if k == "GHCR_TOKEN" {
+				return "ghp_123"
+			}
+			return ""
+		},
+		helper: func(_ context.Context, name, server string) ([]byte, error) {
+			if name != "osxkeychain" || server != "registry.acme" {
+				return nil, fmt.Errorf("unexpected helper call %s %s", name, server)
+			}
+			return []byte(`{"ServerURL":"registry.acme","Username":"ci","Secret":"keychain-pw"}`), nil
+		},
+	}
+
+	mock := &mockSSHRunner{}
+	if err := a.login(context.Background(), mock, "ghcr.io/acme/api"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := a.login(context.Background(), mock, "registry.acme/api"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantCmds := []string{
+		"docker login --username deploy --password-stdin ghcr.io",
+		"docker login --username ci --password-stdin registry.acme",
+	}
+	wantStdin := []string{"ghp_123", "keychain-pw"}
+	for i := range wantCmds {
+		if mock.commands[i] != wantCmds[i] {
+			t.Errorf("cmd[%d] = %q, want %q", i, mock.commands[i], wantCmds[i])
+		}
+		if mock.stdins[i] != wantStdin[i] {
+			t.Errorf("stdin[%d] = %q, want %q", i, mock.stdins[i], wantStdin[i])
+		}
+	}
+}
+
+func TestRegistryLoginUnknownRegistry(t *testing.T) {
+	a := &registryAuth{ecr: func(string) ecrAuthAPI { return &mockECRAuth{} }}
+	mock := &mockSSHRunner{}
+	if err := a.login(context.Background(), mock, "myapp/backend"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.commands) != 0 {
+		t.Errorf("expected no login for a registry without credentials, got %v", mock.commands)
+	}
+}
+
+func TestRegistryLoginMissingEnv(t *testing.T) {
+	a := &registryAuth{
+		registries: map[string]registryConfig{"ghcr.io": {Username: "deploy", PasswordEnv: "GHCR_TOKEN"}},
+		getenv:     func(string) string { return "" },
+	}
+	err := a.login(context.Background(), &mockSSHRunner{}, "ghcr.io/acme/api")
+	if err == nil || !strings.Contains(err.Error(), "GHCR_TOKEN is not set") {
+		t.Errorf("expected missing env error, got %v", err)
+	}
+}
+
+func TestServerDeployLogsInBeforePull(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["backend"]
+	svc.Image = "123456789012.dkr.ecr.us-east-1.amazonaws.com/backend"
+	cfg.Services["backend"] = svc
+
+	mock := &mockSSHRunner{}
+	d := &serverDeployer{
+		cfg:  cfg,
+		dial: func(_ string) (sshRunner, error) { return mock, nil },
+		auth: &registryAuth{
+			ecr: func(string) ecrAuthAPI { return &mockECRAuth{token: "AWS:tok"} },
+		},
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  1 * time.Second,
+	}
+	if err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(mock.commands[0], "docker login --username AWS --password-stdin ") {
+		t.Errorf("cmd[0] = %q, want docker login", mock.commands[0])
+	}
+	if !strings.HasPrefix(mock.commands[1], "docker pull ") {
+		t.Errorf("cmd[1] = %q, want docker pull", mock.commands[1])
+	}
+}
+
+func TestServerDeployLoginFailure(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["backend"]
+	svc.Image = "123456789012.dkr.ecr.us-east-1.amazonaws.com/backend"
+	cfg.Services["backend"] = svc
+
+	mock := &mockSSHRunner{}
+	d := &serverDeployer{
+		cfg:  cfg,
+		dial: func(_ string) (sshRunner, error) { return mock, nil },
+		auth: &registryAuth{
+			ecr: func(string) ecrAuthAPI { return &mockECRAuth{err: fmt.Errorf("expired credentials")} },
+		},
+	}
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "logging in to registry") {
+		t.Fatalf("expected login error, got %v", err)
+	}
+	if len(mock.commands) != 0 {
+		t.Errorf("expected nothing to run on the node, got %v", mock.commands)
+	}
+}
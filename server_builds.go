@@ -17,6 +17,9 @@ type ecrDescribeImagesAPI interface {
 type serverBuildsProvider struct {
 	ecr      ecrDescribeImagesAPI
 	repoName string
+	// regional returns a client for an ECR region, so digests are looked up
+	// in the registry the image lives in. nil means use ecr.
+	regional func(region string) ecrDescribeImagesAPI
 }
 
 // parseECRRepo extracts the repository name from a full ECR image URL.
@@ -51,7 +54,11 @@ func (p *serverBuildsProvider) listBuilds(ctx context.Context, limit, offset int
 				if err != nil {
 					continue // skip non-hoist tags (cache, latest, etc.)
 				}
-				all = append(all, buildFromTag(t))
+				b := buildFromTag(t)
+				if img.ImageDigest != nil {
+					b.Digest = *img.ImageDigest
+				}
+				all = append(all, b)
 			}
 		}
 
@@ -76,3 +83,30 @@ func (p *serverBuildsProvider) listBuilds(ctx context.Context, limit, offset int
 
 	return all, nil
 }
+
+// resolveDigest returns the manifest digest the tag currently points at.
+// It looks up image's own repository, which may differ from repoName when
+// several services of one type use different images, in the image's ECR
+// region. Images outside ECR aren't pinned and resolve to "".
+func (p *serverBuildsProvider) resolveDigest(ctx context.Context, image, tag string) (string, error) {
+	region, ok := ecrRegion(registryHost(image))
+	if !ok {
+		return "", nil
+	}
+	client := p.ecr
+	if p.regional != nil {
+		client = p.regional(region)
+	}
+	repo := parseECRRepo(image)
+	out, err := client.DescribeImages(ctx, &ecr.DescribeImagesInput{
+		RepositoryName: &repo,
+		ImageIds:       []types.ImageIdentifier{{ImageTag: &tag}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("describing ECR image %s:%s: %w", repo, tag, err)
+	}
+	if len(out.ImageDetails) == 0 || out.ImageDetails[0].ImageDigest == nil {
+		return "", fmt.Errorf("image %s:%s not found", repo, tag)
+	}
+	return *out.ImageDetails[0].ImageDigest, nil
+}
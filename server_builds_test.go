@@ -212,3 +212,64 @@ func TestServerBuildsEmpty(t *testing.T) {
 		t.Errorf("expected nil builds, got %d", len(builds))
 	}
 }
+
+func TestServerBuildsResolveDigest(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	stub := &stubECR{
+		pages: []ecr.DescribeImagesOutput{
+			{ImageDetails: []types.ImageDetail{{ImageTags: []string{"main-abc1234-20250101100000"}, ImageDigest: &digest}}},
+		},
+	}
+
+	p := &serverBuildsProvider{ecr: stub, repoName: "other-repo"}
+	got, err := p.resolveDigest(context.Background(), "123456.dkr.ecr.us-east-1.amazonaws.com/backend", "main-abc1234-20250101100000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != digest {
+		t.Errorf("digest = %q, want %q", got, digest)
+	}
+}
+
+func TestServerBuildsResolveDigestNotFound(t *testing.T) {
+	p := &serverBuildsProvider{ecr: &stubECR{}, repoName: "backend"}
+	if _, err := p.resolveDigest(context.Background(), "123456.dkr.ecr.us-east-1.amazonaws.com/backend", "main-abc1234-20250101100000"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestServerBuildsResolveDigestRegistry(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	regional := &stubECR{
+		pages: []ecr.DescribeImagesOutput{
+			{ImageDetails: []types.ImageDetail{{ImageDigest: &digest}}},
+		},
+	}
+	var regions []string
+	p := &serverBuildsProvider{
+		ecr: &stubECR{err: fmt.Errorf("wrong region")},
+		regional: func(region string) ecrDescribeImagesAPI {
+			regions = append(regions, region)
+			return regional
+		},
+	}
+
+	got, err := p.resolveDigest(context.Background(), "123456.dkr.ecr.eu-west-1.amazonaws.com/backend", "main-abc1234-20250101100000")
+	if err != nil || got != digest {
+		t.Fatalf("digest = %q, err = %v, want %q", got, err, digest)
+	}
+	if len(regions) != 1 || regions[0] != "eu-west-1" {
+		t.Errorf("regions = %v, want [eu-west-1]", regions)
+	}
+
+	// Images outside ECR are deployed by tag.
+	for _, image := range []string{"backend", "ghcr.io/acme/backend", "registry.example.com:5000/backend"} {
+		got, err := p.resolveDigest(context.Background(), image, "main-abc1234-20250101100000")
+		if err != nil || got != "" {
+			t.Errorf("resolveDigest(%q) = %q, %v, want no digest", image, got, err)
+		}
+	}
+	if len(regions) != 1 {
+		t.Errorf("expected no ECR lookups for other registries, got %v", regions)
+	}
+}
@@ -2,24 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type sshRunner interface {
 	run(ctx context.Context, cmd string) (string, error)
+	stream(ctx context.Context, cmd string, w io.Writer) error
+	streamWithInput(ctx context.Context, cmd string, w io.Writer, stdin io.Reader) error
+	streamTTY(ctx context.Context, cmd string, w io.Writer) error
+	upload(ctx context.Context, path string, data []byte) error
 	close() error
 }
 
 type serverDeployer struct {
 	cfg          config
 	dial         func(addr string) (sshRunner, error)
+	auth         *registryAuth // nil skips registry login
 	pollInterval time.Duration // 0 means use default (2s)
 	pollTimeout  time.Duration // 0 means use default (120s)
 }
 
-func (d *serverDeployer) deploy(ctx context.Context, service, env, tag, oldTag string) error {
+func (d *serverDeployer) deploy(ctx context.Context, service, env, tag, oldTag string, params deployParams) error {
 	svc := d.cfg.Services[service]
 	ec := svc.Env[env]
 	addr := d.cfg.Nodes[ec.Node]
@@ -30,17 +39,28 @@ func (d *serverDeployer) deploy(ctx context.Context, service, env, tag, oldTag s
 	}
 	defer client.close()
 
+	if d.auth != nil {
+		if err := d.auth.login(ctx, client, svc.Image); err != nil {
+			return fmt.Errorf("logging in to registry: %w", err)
+		}
+	}
+
 	// Pull image.
-	pullCmd := fmt.Sprintf("docker pull %s:%s", svc.Image, tag)
-	if _, err := client.run(ctx, pullCmd); err != nil {
+	digest := params.digest
+	reportProgress(ctx, "pulling image...")
+	pullCmd := "docker pull " + imageRef(svc.Image, tag, digest)
+	pw := &pullWriter{ctx: ctx}
+	err = client.streamTTY(ctx, pullCmd, pw)
+	pw.flush()
+	if err != nil {
+		if tail := pw.tail(); tail != "" {
+			return fmt.Errorf("pulling image: %w\n%s", err, tail)
+		}
 		return fmt.Errorf("pulling image: %w", err)
 	}
 
-	// Start new container.
-	runArgs := buildDockerRunArgs(d.cfg.Project, service, tag, oldTag, svc, ec, env)
-	runCmd := "docker run " + strings.Join(runArgs, " ")
-	if _, err := client.run(ctx, runCmd); err != nil {
-		return fmt.Errorf("starting container: %w", err)
+	if err := runHooks(ctx, client, "pre-deploy", svc.Hooks.PreDeploy, service, tag, digest, svc, ec); err != nil {
+		return err
 	}
 
 	// Wait for healthcheck.
@@ -52,51 +72,361 @@ func (d *serverDeployer) deploy(ctx context.Context, service, env, tag, oldTag s
 	if timeout == 0 {
 		timeout = 120 * time.Second
 	}
+	px := newProxy(d.cfg.Proxies[ec.Node], interval, timeout)
+
+	// A container that can't be drained would be stopped with requests in
+	// flight on the next deploy; refuse it while nothing has changed yet.
+	if dc, ok := px.(drainChecker); ok && svc.Type != "worker" && svc.Drain.Period > 0 {
+		if err := dc.checkDrain(ctx, client, imageRef(svc.Image, tag, digest)); err != nil {
+			return fmt.Errorf("drain.period is set but the new container couldn't be drained: %w", err)
+		}
+	}
+
+	// Start new container.
+	run := dockerRun{service: service, env: env, tag: tag, oldTag: oldTag, digest: digest}
+	runArgs := buildDockerRunArgs(d.cfg.Project, run, svc, ec, px)
+	runCmd := "docker run " + shellJoin(runArgs)
+	reportProgress(ctx, "starting container...")
+	if _, err := client.run(ctx, runCmd); err != nil {
+		return fmt.Errorf("starting container: %w", err)
+	}
 
-	if err := pollHealthcheck(ctx, client, svc.Port, svc.Healthcheck, interval, timeout); err != nil {
+	container := service + "-" + tag
+
+	// docker run only joins one network; connect the rest afterwards.
+	cc := mergeContainer(svc.Container, ec.Container)
+	if len(cc.Networks) > 1 {
+		for _, network := range cc.Networks[1:] {
+			if _, err := client.run(ctx, fmt.Sprintf("docker network connect %s %s", network, container)); err != nil {
+				client.run(ctx, fmt.Sprintf("docker stop %s", container))
+				client.run(ctx, fmt.Sprintf("docker rm %s", container))
+				return fmt.Errorf("connecting to network %s: %w", network, err)
+			}
+		}
+	}
+
+	if svc.Type == "worker" {
+		stableFor := svc.StableFor
+		if stableFor == 0 {
+			stableFor = defaultStableFor
+		}
+		reportProgress(ctx, "waiting for container to stay up for %s...", stableFor)
+		err = waitStable(ctx, client, container, interval, stableFor)
+	} else {
+		reportProgress(ctx, "waiting for healthcheck...")
+		err = pollHealthcheck(ctx, client, container, svc.Port, svc.Healthcheck, interval, timeout)
+	}
+	if err != nil {
 		// Clean up failed new container (best-effort).
 		client.run(ctx, fmt.Sprintf("docker stop %s-%s", service, tag))
 		client.run(ctx, fmt.Sprintf("docker rm %s-%s", service, tag))
+		if svc.Type == "worker" {
+			return fmt.Errorf("readiness check failed: %w", err)
+		}
 		return fmt.Errorf("healthcheck failed: %w", err)
 	}
 
-	// Stop and remove old container.
+	if svc.Type != "worker" {
+		if err := px.route(ctx, client, service, env, container, svc); err != nil {
+			client.run(ctx, fmt.Sprintf("docker stop %s", container))
+			client.run(ctx, fmt.Sprintf("docker rm %s", container))
+			return fmt.Errorf("routing new container: %w", err)
+		}
+	}
+
+	// Drain, stop and remove old container.
 	if oldTag != "" {
-		if _, err := client.run(ctx, fmt.Sprintf("docker stop %s-%s", service, oldTag)); err != nil {
+		old := service + "-" + oldTag
+		if svc.Drain.Period > 0 {
+			err := px.unroute(ctx, client, old)
+			switch {
+			case errors.Is(err, errNoDrain):
+				reportProgress(ctx, "not draining old container: %v", err)
+			case err != nil:
+				return fmt.Errorf("unrouting old container: %w", err)
+			default:
+				if err := drainContainer(ctx, client, old, svc.Port, svc.Drain.Period, interval); err != nil {
+					return fmt.Errorf("draining old container: %w", err)
+				}
+			}
+		}
+		reportProgress(ctx, "stopping old container%s...", formatStopGrace(svc.Drain))
+		if _, err := client.run(ctx, dockerStopCmd(old, svc.Drain)); err != nil {
 			return fmt.Errorf("stopping old container: %w", err)
 		}
-		if _, err := client.run(ctx, fmt.Sprintf("docker rm %s-%s", service, oldTag)); err != nil {
+		if _, err := client.run(ctx, fmt.Sprintf("docker rm %s", old)); err != nil {
 			return fmt.Errorf("removing old container: %w", err)
 		}
 	}
 
+	if err := runHooks(ctx, client, "post-deploy", svc.Hooks.PostDeploy, service, tag, digest, svc, ec); err != nil {
+		return err
+	}
+
+	if svc.Prune.AfterDeploy {
+		// The new container is live; a failed prune only costs disk space.
+		reportProgress(ctx, "pruning old images...")
+		if n, size, err := pruneService(ctx, client, service, svc, pruneKeep(svc)); err != nil {
+			reportProgress(ctx, "prune failed: %v", err)
+		} else {
+			reportProgress(ctx, "pruned %d item(s), up to %s", n, formatBytes(size))
+		}
+	}
 	return nil
 }
 
-func buildDockerRunArgs(project, service, tag, oldTag string, svc serviceConfig, ec envConfig, env string) []string {
-	return []string{
+// drainMarker is the file whose presence turns a container's Docker health
+// status unhealthy, which makes Traefik stop routing new requests to it.
+const drainMarker = "/tmp/hoist-drain"
+
+// drainContainer waits until an unrouted container has no established
+// connections on port, or period elapses.
+func drainContainer(ctx context.Context, client sshRunner, container string, port int, period, interval time.Duration) error {
+	start := time.Now()
+	deadline := time.After(period)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reportProgress(ctx, "draining old container (0s/%s)...", period)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return nil
+		case <-ticker.C:
+			elapsed := time.Since(start).Round(time.Second)
+			n, err := activeConnections(ctx, client, container, port)
+			if err != nil {
+				// Can't count connections; fall back to waiting the full period.
+				reportProgress(ctx, "draining old container (%s/%s)...", elapsed, period)
+				continue
+			}
+			if n == 0 {
+				return nil
+			}
+			reportProgress(ctx, "draining old container (%s/%s, %d connections)...", elapsed, period, n)
+		}
+	}
+}
+
+// waitDockerHealth polls the container's Docker health status until it
+// reports status.
+func waitDockerHealth(ctx context.Context, client sshRunner, container, status string, interval, timeout time.Duration) error {
+	cmd := fmt.Sprintf(`docker inspect --format "{{.State.Health.Status}}" %s`, container)
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		out, err := client.run(ctx, cmd)
+		if err == nil && strings.TrimSpace(out) == status {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("timed out after %s", timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// activeConnections counts established TCP connections to port inside the
+// container's network namespace.
+func activeConnections(ctx context.Context, client sshRunner, container string, port int) (int, error) {
+	pid, err := client.run(ctx, fmt.Sprintf(`docker inspect --format "{{.State.Pid}}" %s`, container))
+	if err != nil {
+		return 0, err
+	}
+	pid = strings.TrimSpace(pid)
+	out, err := client.run(ctx, fmt.Sprintf("cat /proc/%s/net/tcp /proc/%s/net/tcp6", pid, pid))
+	if err != nil {
+		return 0, err
+	}
+	return countEstablished(out, port), nil
+}
+
+// countEstablished parses /proc/net/tcp{,6} output and counts ESTABLISHED
+// (state 01) sockets whose local port is port.
+func countEstablished(procNetTCP string, port int) int {
+	wantPort := fmt.Sprintf(":%04X", port)
+	n := 0
+	for _, line := range strings.Split(procNetTCP, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "sl" {
+			continue
+		}
+		if strings.HasSuffix(fields[1], wantPort) && fields[3] == "01" {
+			n++
+		}
+	}
+	return n
+}
+
+func dockerStopCmd(container string, dc drainConfig) string {
+	args := []string{"docker", "stop"}
+	if dc.StopTimeout > 0 {
+		args = append(args, "--time", strconv.Itoa(int(dc.StopTimeout.Seconds())))
+	}
+	if dc.StopSignal != "" {
+		args = append(args, "--signal", dc.StopSignal)
+	}
+	args = append(args, container)
+	return strings.Join(args, " ")
+}
+
+func formatStopGrace(dc drainConfig) string {
+	if dc.StopTimeout == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (grace %s)", dc.StopTimeout)
+}
+
+// dockerRun identifies the container a deploy starts.
+type dockerRun struct {
+	service string
+	env     string
+	tag     string
+	oldTag  string // tag of the container it replaces, if any
+	digest  string // image digest to run, if pinned
+}
+
+func buildDockerRunArgs(project string, run dockerRun, svc serviceConfig, ec envConfig, px proxy) []string {
+	cc := mergeContainer(svc.Container, ec.Container)
+
+	args := []string{
 		"-d",
-		"--name", service + "-" + tag,
+		"--name", run.service + "-" + run.tag,
 		"--restart", "unless-stopped",
 		"--env-file", ec.EnvFile,
-		"--log-driver", "awslogs",
-		"--log-opt", fmt.Sprintf("awslogs-group=/%s/%s/%s", project, env, service),
-		"--label", "traefik.enable=true",
-		"--label", fmt.Sprintf("traefik.http.routers.%s.rule=Host(`%s`)", service, ec.Host),
-		"--label", fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port=%d", service, svc.Port),
-		"--label", fmt.Sprintf("hoist.previous=%s", oldTag),
-		svc.Image + ":" + tag,
 	}
+	args = append(args, logDriverArgs(resolveLogConfig(project, run.service, run.env, run.tag, svc, ec))...)
+	// Workers have no HTTP surface and stay out of the proxy.
+	if svc.Type != "worker" {
+		args = append(args, px.runArgs(run.service, svc, ec)...)
+	}
+	args = append(args, "--label", "hoist.service="+run.service)
+	args = append(args, "--label", fmt.Sprintf("hoist.previous=%s", run.oldTag))
+	if run.digest != "" {
+		args = append(args, "--label", "hoist.digest="+run.digest)
+	}
+	args = append(args, containerRunArgs(cc)...)
+	args = append(args, imageRef(svc.Image, run.tag, run.digest))
+	return append(args, cc.Command...)
+}
+
+// containerRunArgs renders user-configured container options as docker run
+// flags. Map-backed options are sorted so the command line is stable.
+func containerRunArgs(cc containerConfig) []string {
+	var args []string
+	for _, v := range cc.Volumes {
+		args = append(args, "--volume", v)
+	}
+	if len(cc.Networks) > 0 {
+		args = append(args, "--network", cc.Networks[0])
+	}
+	if cc.CPUs != "" {
+		args = append(args, "--cpus", cc.CPUs)
+	}
+	if cc.Memory != "" {
+		args = append(args, "--memory", cc.Memory)
+	}
+	for _, name := range sortedKeys(cc.Ulimits) {
+		args = append(args, "--ulimit", name+"="+cc.Ulimits[name])
+	}
+	if cc.User != "" {
+		args = append(args, "--user", cc.User)
+	}
+	if cc.Entrypoint != "" {
+		args = append(args, "--entrypoint", cc.Entrypoint)
+	}
+	for _, k := range sortedKeys(cc.Env) {
+		args = append(args, "--env", k+"="+cc.Env[k])
+	}
+	for _, k := range sortedKeys(cc.Labels) {
+		args = append(args, "--label", k+"="+cc.Labels[k])
+	}
+	if cc.Init != nil && *cc.Init {
+		args = append(args, "--init")
+	}
+	return args
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// healthcheckCmd builds a command that probes the healthcheck endpoint at
+// the container's own IP from the node. Containers don't publish ports, so
+// localhost on the node would hit whatever else is bound there; it is only
+// used for containers on the host network. The check fails when the
+// container has no IP. wget is a fallback for nodes without curl.
+func healthcheckCmd(container string, port int, path string) string {
+	ip := fmt.Sprintf(`$(docker inspect --format '{{if eq .HostConfig.NetworkMode "host"}}127.0.0.1{{else}}{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}{{end}}' %s | awk '{print $1}')`, container)
+	url := fmt.Sprintf(`"http://$ip:%d"%s`, port, shellQuote(path))
+	return fmt.Sprintf(`ip=%s; [ -n "$ip" ] && { curl -sf --max-time 5 %s || wget -q -T 5 -O /dev/null %s; }`, ip, url, url)
+}
+
+// defaultStableFor is how long a worker container must keep running without
+// restarts before it is considered ready.
+const defaultStableFor = 10 * time.Second
+
+// waitStable waits until the container has been running for stableFor
+// without exiting or restarting. Workers have no endpoint to probe, so
+// staying up is the readiness signal.
+func waitStable(ctx context.Context, client sshRunner, container string, interval, stableFor time.Duration) error {
+	cmd := fmt.Sprintf(`docker inspect --format "{{.State.Status}} {{.RestartCount}}" %s`, container)
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		out, err := client.run(ctx, cmd)
+		if err != nil {
+			return fmt.Errorf("inspecting container: %w", err)
+		}
+		var status string
+		var restarts int
+		if _, err := fmt.Sscanf(out, "%s %d", &status, &restarts); err != nil {
+			return fmt.Errorf("unexpected docker inspect output: %q", out)
+		}
+		if status != "running" {
+			return fmt.Errorf("container is %s", status)
+		}
+		if restarts > 0 {
+			return fmt.Errorf("container restarted %d time(s)", restarts)
+		}
+		if time.Since(start) >= stableFor {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func pollHealthcheck(ctx context.Context, client sshRunner, container string, port int, path string, interval, timeout time.Duration) error {
+	return pollCommand(ctx, client, healthcheckCmd(container, port, path), interval, timeout)
 }
 
-func pollHealthcheck(ctx context.Context, client sshRunner, port int, path string, interval, timeout time.Duration) error {
-	healthCmd := fmt.Sprintf("curl -sf http://localhost:%d%s", port, path)
+// pollCommand runs cmd every interval until it succeeds or timeout elapses.
+func pollCommand(ctx context.Context, client sshRunner, cmd string, interval, timeout time.Duration) error {
 	deadline := time.After(timeout)
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	// First attempt immediately.
-	if _, err := client.run(ctx, healthCmd); err == nil {
+	if _, err := client.run(ctx, cmd); err == nil {
 		return nil
 	}
 
@@ -107,7 +437,7 @@ func pollHealthcheck(ctx context.Context, client sshRunner, port int, path strin
 		case <-deadline:
 			return fmt.Errorf("timed out after %s", timeout)
 		case <-ticker.C:
-			if _, err := client.run(ctx, healthCmd); err == nil {
+			if _, err := client.run(ctx, cmd); err == nil {
 				return nil
 			}
 		}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
 	"time"
@@ -12,6 +13,8 @@ type mockSSHRunner struct {
 	commands  []string
 	responses []mockRunResult
 	idx       int
+	uploads   map[string]string
+	stdins    []string
 }
 
 type mockRunResult struct {
@@ -30,13 +33,39 @@ func (m *mockSSHRunner) run(_ context.Context, cmd string) (string, error) {
 	return "", nil
 }
 
+func (m *mockSSHRunner) stream(ctx context.Context, cmd string, w io.Writer) error {
+	out, err := m.run(ctx, cmd)
+	if out != "" {
+		io.WriteString(w, out+"\n")
+	}
+	return err
+}
+
+func (m *mockSSHRunner) streamWithInput(ctx context.Context, cmd string, w io.Writer, stdin io.Reader) error {
+	data, _ := io.ReadAll(stdin)
+	m.stdins = append(m.stdins, string(data))
+	return m.stream(ctx, cmd, w)
+}
+
+func (m *mockSSHRunner) streamTTY(ctx context.Context, cmd string, w io.Writer) error {
+	return m.stream(ctx, cmd, w)
+}
+
+func (m *mockSSHRunner) upload(_ context.Context, path string, data []byte) error {
+	if m.uploads == nil {
+		m.uploads = make(map[string]string)
+	}
+	m.uploads[path] = string(data)
+	return nil
+}
+
 func (m *mockSSHRunner) close() error { return nil }
 
 func TestBuildDockerRunArgs(t *testing.T) {
 	svc := serviceConfig{Image: "myapp/backend", Port: 8080, Healthcheck: "/health"}
 	ec := envConfig{Host: "api.staging.example.com", EnvFile: "/etc/backend/staging.env"}
 
-	args := buildDockerRunArgs("myapp", "backend", "main-abc1234-20250101000000", "main-old1234-20241231000000", svc, ec, "staging")
+	args := buildDockerRunArgs("myapp", dockerRun{service: "backend", env: "staging", tag: "main-abc1234-20250101000000", oldTag: "main-old1234-20241231000000"}, svc, ec, &traefikProxy{})
 	joined := strings.Join(args, " ")
 
 	checks := []string{
@@ -46,9 +75,11 @@ func TestBuildDockerRunArgs(t *testing.T) {
 		"--env-file /etc/backend/staging.env",
 		"--log-driver awslogs",
 		"awslogs-group=/myapp/staging/backend",
+		"--log-opt tag={{.Name}}",
 		"traefik.enable=true",
 		"traefik.http.routers.backend.rule=Host(`api.staging.example.com`)",
 		"traefik.http.services.backend.loadbalancer.server.port=8080",
+		"hoist.service=backend",
 		"hoist.previous=main-old1234-20241231000000",
 	}
 
@@ -69,7 +100,7 @@ func TestBuildDockerRunArgsEmptyOldTag(t *testing.T) {
 	svc := serviceConfig{Image: "myapp/backend", Port: 8080, Healthcheck: "/health"}
 	ec := envConfig{Host: "api.example.com", EnvFile: "/etc/backend/prod.env"}
 
-	args := buildDockerRunArgs("myapp", "backend", "main-abc1234-20250101000000", "", svc, ec, "production")
+	args := buildDockerRunArgs("myapp", dockerRun{service: "backend", env: "production", tag: "main-abc1234-20250101000000"}, svc, ec, &traefikProxy{})
 	joined := strings.Join(args, " ")
 
 	// Label should still be present with empty value.
@@ -78,17 +109,85 @@ func TestBuildDockerRunArgsEmptyOldTag(t *testing.T) {
 	}
 }
 
+func TestBuildDockerRunArgsLogDriver(t *testing.T) {
+	svc := serviceConfig{
+		Image: "myapp/backend", Port: 8080, Healthcheck: "/health",
+		Log: logConfig{Options: map[string]string{"awslogs-stream": "${service}-${tag}"}},
+	}
+	ec := envConfig{Host: "api.example.com", EnvFile: "/etc/backend/prod.env"}
+
+	joined := strings.Join(buildDockerRunArgs("myapp", dockerRun{service: "backend", env: "production", tag: "main-abc1234-20250101000000"}, svc, ec, &traefikProxy{}), " ")
+	for _, want := range []string{
+		"--log-driver awslogs",
+		"--log-opt awslogs-group=/myapp/production/backend",
+		"--log-opt awslogs-stream=backend-main-abc1234-20250101000000",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected args to contain %q, got: %s", want, joined)
+		}
+	}
+
+	// An on-prem env switches to a local driver; awslogs options must not leak.
+	ec.Log = logConfig{Driver: "local", Options: map[string]string{"max-size": "20m"}}
+	joined = strings.Join(buildDockerRunArgs("myapp", dockerRun{service: "backend", env: "production", tag: "main-abc1234-20250101000000"}, svc, ec, &traefikProxy{}), " ")
+	if !strings.Contains(joined, "--log-driver local --log-opt max-size=20m") {
+		t.Errorf("expected local driver args, got: %s", joined)
+	}
+	if strings.Contains(joined, "awslogs") {
+		t.Errorf("expected no awslogs options, got: %s", joined)
+	}
+
+	// Loki streams are labeled with the service so logs survive new tags.
+	ec.Log = logConfig{Driver: "loki", Options: map[string]string{"loki-url": "http://loki:3100/loki/api/v1/push"}}
+	joined = strings.Join(buildDockerRunArgs("myapp", dockerRun{service: "backend", env: "production", tag: "main-abc1234-20250101000000"}, svc, ec, &traefikProxy{}), " ")
+	if want := "--log-opt loki-external-labels=container_name={{.Name}},hoist_service=backend,hoist_env=production"; !strings.Contains(joined, want) {
+		t.Errorf("expected args to contain %q, got: %s", want, joined)
+	}
+}
+
+func TestServerDeployByDigest(t *testing.T) {
+	cfg := testConfig()
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	mock := &mockSSHRunner{}
+	d := &serverDeployer{
+		cfg:          cfg,
+		dial:         func(_ string) (sshRunner, error) { return mock, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  1 * time.Second,
+	}
+
+	if err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "", deployParams{digest: digest}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if mock.commands[0] != "docker pull myapp/backend@"+digest {
+		t.Errorf("pull = %q, want pull by digest", mock.commands[0])
+	}
+	run := mock.commands[1]
+	if !strings.Contains(run, "--label hoist.digest="+digest) {
+		t.Errorf("expected digest label, got: %s", run)
+	}
+	if !strings.HasSuffix(run, " myapp/backend@"+digest) {
+		t.Errorf("expected container to run image by digest, got: %s", run)
+	}
+	if !strings.Contains(run, "--name backend-main-abc1234-20250101000000") {
+		t.Errorf("container name should still use the tag, got: %s", run)
+	}
+}
+
 func TestPollHealthcheckImmediateSuccess(t *testing.T) {
 	mock := &mockSSHRunner{}
-	err := pollHealthcheck(context.Background(), mock, 8080, "/health", 10*time.Millisecond, 1*time.Second)
+	err := pollHealthcheck(context.Background(), mock, "backend-main-abc1234-20250101000000", 8080, "/health", 10*time.Millisecond, 1*time.Second)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	if len(mock.commands) != 1 {
 		t.Fatalf("expected 1 command, got %d", len(mock.commands))
 	}
-	if !strings.Contains(mock.commands[0], "curl -sf http://localhost:8080/health") {
-		t.Errorf("unexpected command: %s", mock.commands[0])
+	want := `ip=$(docker inspect --format '{{if eq .HostConfig.NetworkMode "host"}}127.0.0.1{{else}}{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}{{end}}' backend-main-abc1234-20250101000000 | awk '{print $1}'); ` +
+		`[ -n "$ip" ] && { curl -sf --max-time 5 "http://$ip:8080"/health || wget -q -T 5 -O /dev/null "http://$ip:8080"/health; }`
+	if mock.commands[0] != want {
+		t.Errorf("command = %q, want %q", mock.commands[0], want)
 	}
 }
 
@@ -101,7 +200,7 @@ func TestPollHealthcheckEventualSuccess(t *testing.T) {
 			{output: "OK"},
 		},
 	}
-	err := pollHealthcheck(context.Background(), mock, 8080, "/health", 10*time.Millisecond, 1*time.Second)
+	err := pollHealthcheck(context.Background(), mock, "backend-main-abc1234-20250101000000", 8080, "/health", 10*time.Millisecond, 1*time.Second)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -125,7 +224,7 @@ func TestPollHealthcheckTimeout(t *testing.T) {
 			{err: fmt.Errorf("unhealthy")},
 		},
 	}
-	err := pollHealthcheck(context.Background(), mock, 8080, "/health", 10*time.Millisecond, 50*time.Millisecond)
+	err := pollHealthcheck(context.Background(), mock, "backend-main-abc1234-20250101000000", 8080, "/health", 10*time.Millisecond, 50*time.Millisecond)
 	if err == nil {
 		t.Fatal("expected timeout error")
 	}
@@ -149,7 +248,7 @@ func TestPollHealthcheckContextCancelled(t *testing.T) {
 		time.Sleep(25 * time.Millisecond)
 		cancel()
 	}()
-	err := pollHealthcheck(ctx, mock, 8080, "/health", 10*time.Millisecond, 5*time.Second)
+	err := pollHealthcheck(ctx, mock, "backend-main-abc1234-20250101000000", 8080, "/health", 10*time.Millisecond, 5*time.Second)
 	if err == nil {
 		t.Fatal("expected error from context cancellation")
 	}
@@ -173,7 +272,7 @@ func TestServerDeployHappyPath(t *testing.T) {
 		pollTimeout:  1 * time.Second,
 	}
 
-	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000")
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -193,8 +292,8 @@ func TestServerDeployHappyPath(t *testing.T) {
 	if !strings.HasPrefix(mock.commands[1], "docker run") {
 		t.Errorf("cmd[1] = %q, want docker run", mock.commands[1])
 	}
-	if !strings.Contains(mock.commands[2], "curl -sf") {
-		t.Errorf("cmd[2] = %q, want curl healthcheck", mock.commands[2])
+	if !strings.Contains(mock.commands[2], "{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}{{end}}' backend-main-abc1234-20250101000000") {
+		t.Errorf("cmd[2] = %q, want healthcheck against the new container's IP", mock.commands[2])
 	}
 
 	// Last two: stop and rm old container.
@@ -218,7 +317,7 @@ func TestServerDeployNoOldTag(t *testing.T) {
 		pollTimeout:  1 * time.Second,
 	}
 
-	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "")
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "", deployParams{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -244,7 +343,7 @@ func TestServerDeployPullFailure(t *testing.T) {
 		dial: func(_ string) (sshRunner, error) { return mock, nil },
 	}
 
-	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "old-tag")
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "old-tag", deployParams{})
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -286,7 +385,7 @@ func TestServerDeployHealthcheckFailure(t *testing.T) {
 		pollTimeout:  50 * time.Millisecond,
 	}
 
-	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000")
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -332,7 +431,7 @@ func TestServerDeployDialFailure(t *testing.T) {
 		},
 	}
 
-	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "old-tag")
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "old-tag", deployParams{})
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -340,3 +439,419 @@ func TestServerDeployDialFailure(t *testing.T) {
 		t.Errorf("expected 'connecting to' error, got: %v", err)
 	}
 }
+
+func TestBuildDockerRunArgsDrainHealthcheck(t *testing.T) {
+	svc := serviceConfig{Image: "myapp/backend", Port: 8080, Healthcheck: "/health", Drain: drainConfig{Period: 30 * time.Second}}
+	ec := envConfig{Host: "api.example.com", EnvFile: "/etc/backend/prod.env"}
+
+	args := buildDockerRunArgs("myapp", dockerRun{service: "backend", env: "production", tag: "main-abc1234-20250101000000"}, svc, ec, &traefikProxy{})
+	joined := strings.Join(args, " ")
+
+	if !strings.Contains(joined, "--health-cmd test ! -e /tmp/hoist-drain") {
+		t.Errorf("expected drain health-cmd, got: %s", joined)
+	}
+	if args[len(args)-1] != "myapp/backend:main-abc1234-20250101000000" {
+		t.Errorf("expected last arg to be image:tag, got %q", args[len(args)-1])
+	}
+}
+
+func TestCountEstablished(t *testing.T) {
+	out := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0200110A:1F90 0100110A:C350 01 00000000:00000000 00:00000000 00000000     0        0 2 1 0000000000000000 20 4 30 10 -1
+   2: 0200110A:1F90 0100110A:C351 01 00000000:00000000 00:00000000 00000000     0        0 3 1 0000000000000000 20 4 30 10 -1
+   3: 0200110A:D431 0100110A:1538 01 00000000:00000000 00:00000000 00000000     0        0 4 1 0000000000000000 20 4 30 10 -1
+   4: 0200110A:1F90 0100110A:C352 06 00000000:00000000 00:00000000 00000000     0        0 5 1 0000000000000000 20 4 30 10 -1`
+
+	if got := countEstablished(out, 8080); got != 2 {
+		t.Errorf("countEstablished = %d, want 2", got)
+	}
+	if got := countEstablished("", 8080); got != 0 {
+		t.Errorf("countEstablished(empty) = %d, want 0", got)
+	}
+}
+
+func TestDockerStopCmd(t *testing.T) {
+	tests := []struct {
+		name string
+		dc   drainConfig
+		want string
+	}{
+		{"defaults", drainConfig{}, "docker stop backend-old"},
+		{"timeout", drainConfig{StopTimeout: 45 * time.Second}, "docker stop --time 45 backend-old"},
+		{"timeout and signal", drainConfig{StopTimeout: 10 * time.Second, StopSignal: "SIGQUIT"}, "docker stop --time 10 --signal SIGQUIT backend-old"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dockerStopCmd("backend-old", tt.dc); got != tt.want {
+				t.Errorf("dockerStopCmd = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerDeployDrainsOldContainer(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["backend"]
+	svc.Drain = drainConfig{Period: time.Second, StopTimeout: 20 * time.Second}
+	cfg.Services["backend"] = svc
+	cfg.Proxies = map[string]proxyConfig{"web1": {Throttle: time.Millisecond}}
+
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: ""},                   // docker pull
+			{output: ""},                   // image has no HEALTHCHECK
+			{output: ""},                   // image has /bin/sh
+			{output: "id"},                 // docker run
+			{output: "OK"},                 // healthcheck
+			{output: "healthy"},            // docker health status
+			{output: drainHealthcheckJSON}, // old container has the drain healthcheck
+			{output: ""},                   // create drain marker
+			{output: "healthy"},            // not failed its check yet
+			{output: "unhealthy"},          // unrouted
+			{output: "4242"},               // old container pid
+			{output: ""},                   // /proc/net/tcp: no connections
+		},
+	}
+
+	var progress []string
+	ctx := withProgress(context.Background(), func(detail string) {
+		progress = append(progress, detail)
+	})
+
+	d := &serverDeployer{
+		cfg:          cfg,
+		dial:         func(_ string) (sshRunner, error) { return mock, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  1 * time.Second,
+	}
+
+	err := d.deploy(ctx, "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		`docker inspect --format "{{.State.Health.Status}}" backend-main-abc1234-20250101000000`,
+		`docker inspect --format "{{json .Config.Healthcheck}}" backend-main-old1234-20241231000000`,
+		"docker exec backend-main-old1234-20241231000000 /bin/sh -c ': > /tmp/hoist-drain'",
+		`docker inspect --format "{{.State.Health.Status}}" backend-main-old1234-20241231000000`,
+		`docker inspect --format "{{.State.Health.Status}}" backend-main-old1234-20241231000000`,
+		`docker inspect --format "{{.State.Pid}}" backend-main-old1234-20241231000000`,
+		"cat /proc/4242/net/tcp /proc/4242/net/tcp6",
+		"docker stop --time 20 backend-main-old1234-20241231000000",
+		"docker rm backend-main-old1234-20241231000000",
+	}
+	if !strings.Contains(mock.commands[3], "--health-cmd") || !strings.Contains(mock.commands[3], "--health-retries 1") {
+		t.Errorf("docker run = %q, want the drain healthcheck", mock.commands[3])
+	}
+	got := mock.commands[5:]
+	if len(got) != len(want) {
+		t.Fatalf("commands after healthcheck = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("cmd[%d] = %q, want %q", i+5, got[i], want[i])
+		}
+	}
+
+	var sawDrain, sawStop bool
+	for _, p := range progress {
+		if strings.HasPrefix(p, "draining old container") {
+			sawDrain = true
+		}
+		if p == "stopping old container (grace 20s)..." {
+			sawStop = true
+		}
+	}
+	if !sawDrain || !sawStop {
+		t.Errorf("expected drain and stop progress, got %v", progress)
+	}
+}
+
+const drainHealthcheckJSON = `{"Test":["CMD-SHELL","test ! -e /tmp/hoist-drain"],"Interval":1000000000}`
+
+func TestServerDeployDrainUnsupportedImage(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["backend"]
+	svc.Drain = drainConfig{Period: time.Second}
+	cfg.Services["backend"] = svc
+
+	tests := []struct {
+		name      string
+		responses []mockRunResult
+		want      string
+	}{
+		{
+			name: "own healthcheck",
+			responses: []mockRunResult{
+				{output: ""}, // docker pull
+				{output: `["CMD","/app/healthcheck"]`},
+			},
+			want: "the image has its own HEALTHCHECK",
+		},
+		{
+			name: "no shell",
+			responses: []mockRunResult{
+				{output: ""}, // docker pull
+				{output: ""}, // no HEALTHCHECK
+				{err: fmt.Errorf("exec: \"/bin/sh\": stat /bin/sh: no such file or directory")},
+			},
+			want: "the image has no /bin/sh",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockSSHRunner{responses: tt.responses}
+			d := &serverDeployer{
+				cfg:          cfg,
+				dial:         func(_ string) (sshRunner, error) { return mock, nil },
+				pollInterval: 10 * time.Millisecond,
+				pollTimeout:  time.Second,
+			}
+			// Nothing changes on the node: the old container keeps running.
+			err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+			if err == nil || !strings.Contains(err.Error(), "drain.period is set but the new container couldn't be drained: "+tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+			for _, cmd := range mock.commands {
+				if strings.HasPrefix(cmd, "docker run -d") || strings.HasPrefix(cmd, "docker stop") {
+					t.Errorf("unexpected %q", cmd)
+				}
+			}
+		})
+	}
+}
+
+func TestServerDeployDrainLegacyContainer(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["backend"]
+	svc.Drain = drainConfig{Period: time.Second}
+	cfg.Services["backend"] = svc
+
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: ""},        // docker pull
+			{output: ""},        // image has no HEALTHCHECK
+			{output: ""},        // image has /bin/sh
+			{output: "id"},      // docker run
+			{output: "OK"},      // healthcheck
+			{output: "healthy"}, // docker health status
+			{output: "null"},    // old container predates draining
+		},
+	}
+	var progress []string
+	ctx := withProgress(context.Background(), func(detail string) { progress = append(progress, detail) })
+	d := &serverDeployer{
+		cfg:          cfg,
+		dial:         func(_ string) (sshRunner, error) { return mock, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  time.Second,
+	}
+	if err := d.deploy(ctx, "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(mock.commands); mock.commands[n-2] != "docker stop backend-main-old1234-20241231000000" {
+		t.Errorf("commands = %v, want the old container stopped without draining", mock.commands)
+	}
+	found := false
+	for _, p := range progress {
+		found = found || p == "not draining old container: container has no drain healthcheck"
+	}
+	if !found {
+		t.Errorf("progress = %v", progress)
+	}
+}
+
+func TestServerDeployDrainMarkerError(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["backend"]
+	svc.Drain = drainConfig{Period: time.Second}
+	cfg.Services["backend"] = svc
+
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: ""},        // docker pull
+			{output: ""},        // image has no HEALTHCHECK
+			{output: ""},        // image has /bin/sh
+			{output: "id"},      // docker run
+			{output: "OK"},      // healthcheck
+			{output: "healthy"}, // docker health status
+			{output: drainHealthcheckJSON},
+			{err: fmt.Errorf("container is restarting")},
+		},
+	}
+	d := &serverDeployer{
+		cfg:          cfg,
+		dial:         func(_ string) (sshRunner, error) { return mock, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  time.Second,
+	}
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "creating drain marker: container is restarting") {
+		t.Errorf("err = %v, want the drain marker error", err)
+	}
+}
+
+func TestBuildDockerRunArgsContainerOptions(t *testing.T) {
+	init := true
+	svc := serviceConfig{
+		Image:       "myapp/backend",
+		Port:        8080,
+		Healthcheck: "/health",
+		Container: containerConfig{
+			Volumes:  []string{"/srv/uploads:/uploads"},
+			Networks: []string{"web", "db"},
+			Memory:   "512m",
+			Env:      map[string]string{"GREETING": "hello world"},
+			Command:  []string{"serve", "--port", "8080"},
+			Init:     &init,
+		},
+	}
+	ec := envConfig{
+		Host:      "api.example.com",
+		EnvFile:   "/etc/backend/prod.env",
+		Container: containerConfig{Memory: "1g", User: "1000:1000"},
+	}
+
+	args := buildDockerRunArgs("myapp", dockerRun{service: "backend", env: "production", tag: "main-abc1234-20250101000000"}, svc, ec, &traefikProxy{})
+	joined := shellJoin(args)
+
+	checks := []string{
+		"--volume /srv/uploads:/uploads",
+		"--network web",
+		"--label traefik.docker.network=web",
+		"--memory 1g",
+		"--user 1000:1000",
+		"--env 'GREETING=hello world'",
+		"--init",
+		"myapp/backend:main-abc1234-20250101000000 serve --port 8080",
+	}
+	for _, check := range checks {
+		if !strings.Contains(joined, check) {
+			t.Errorf("expected command to contain %q, got: %s", check, joined)
+		}
+	}
+	if strings.Contains(joined, "--network db") {
+		t.Errorf("only the first network should be passed to docker run, got: %s", joined)
+	}
+}
+
+func TestServerDeployConnectsExtraNetworks(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["backend"]
+	svc.Container = containerConfig{Networks: []string{"web", "db", "cache"}}
+	cfg.Services["backend"] = svc
+
+	mock := &mockSSHRunner{}
+	d := &serverDeployer{
+		cfg:          cfg,
+		dial:         func(_ string) (sshRunner, error) { return mock, nil },
+		pollInterval: 10 * time.Millisecond,
+		pollTimeout:  1 * time.Second,
+	}
+
+	err := d.deploy(context.Background(), "backend", "staging", "main-abc1234-20250101000000", "", deployParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if mock.commands[2] != "docker network connect db backend-main-abc1234-20250101000000" {
+		t.Errorf("cmd[2] = %q, want network connect db", mock.commands[2])
+	}
+	if mock.commands[3] != "docker network connect cache backend-main-abc1234-20250101000000" {
+		t.Errorf("cmd[3] = %q, want network connect cache", mock.commands[3])
+	}
+}
+
+func TestBuildDockerRunArgsWorkerSkipsTraefik(t *testing.T) {
+	svc := serviceConfig{Type: "worker", Image: "myapp/jobs", Container: containerConfig{Networks: []string{"queue"}}}
+	ec := envConfig{EnvFile: "/etc/jobs/prod.env"}
+
+	args := buildDockerRunArgs("myapp", dockerRun{service: "jobs", env: "production", tag: "main-abc1234-20250101000000"}, svc, ec, &traefikProxy{})
+	joined := strings.Join(args, " ")
+
+	if strings.Contains(joined, "traefik.") {
+		t.Errorf("worker args should not contain traefik labels, got: %s", joined)
+	}
+	if !strings.Contains(joined, "--network queue") {
+		t.Errorf("expected network flag, got: %s", joined)
+	}
+	if !strings.Contains(joined, "hoist.previous=") {
+		t.Errorf("expected hoist.previous label, got: %s", joined)
+	}
+}
+
+func TestWaitStable(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []mockRunResult
+		wantErr   string
+	}{
+		{"stays up", []mockRunResult{{output: "running 0"}, {output: "running 0"}, {output: "running 0"}, {output: "running 0"}, {output: "running 0"}, {output: "running 0"}}, ""},
+		{"restarts", []mockRunResult{{output: "running 0"}, {output: "running 1"}}, "restarted 1 time(s)"},
+		{"exits", []mockRunResult{{output: "exited 0"}}, "container is exited"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockSSHRunner{responses: tt.responses}
+			err := waitStable(context.Background(), mock, "jobs-t", 5*time.Millisecond, 20*time.Millisecond)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerDeployWorker(t *testing.T) {
+	cfg := testConfig()
+	cfg.Services["jobs"] = serviceConfig{
+		Type:      "worker",
+		Image:     "myapp/jobs",
+		StableFor: 20 * time.Millisecond,
+		Env: map[string]envConfig{
+			"staging": {Node: "web1", EnvFile: "/etc/jobs/staging.env"},
+		},
+	}
+
+	mock := &mockSSHRunner{
+		responses: []mockRunResult{
+			{output: ""},          // docker pull
+			{output: "id"},        // docker run
+			{output: "running 0"}, // readiness
+			{output: "running 0"},
+			{output: "running 0"},
+			{output: "running 0"},
+			{output: "running 0"},
+			{output: "running 0"},
+		},
+	}
+	d := &serverDeployer{
+		cfg:          cfg,
+		dial:         func(_ string) (sshRunner, error) { return mock, nil },
+		pollInterval: 5 * time.Millisecond,
+	}
+
+	err := d.deploy(context.Background(), "jobs", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, cmd := range mock.commands {
+		if strings.Contains(cmd, "curl") {
+			t.Errorf("worker deploy should not run an HTTP healthcheck: %s", cmd)
+		}
+	}
+	n := len(mock.commands)
+	if mock.commands[n-2] != "docker stop jobs-main-old1234-20241231000000" {
+		t.Errorf("cmd[%d] = %q, want docker stop old", n-2, mock.commands[n-2])
+	}
+}
@@ -16,7 +16,7 @@ func (p *serverHistoryProvider) current(ctx context.Context, service, env string
 	svc := p.cfg.Services[service]
 	addr := p.cfg.Nodes[svc.Env[env].Node]
 
-	cmd := fmt.Sprintf(`docker ps --filter "name=%s-" --format "{{.Names}}\t{{.Status}}"`, service)
+	cmd := fmt.Sprintf(`docker ps --filter "name=%s-" --format "{{.Names}}\t{{.Status}}\t{{.Label \"hoist.digest\"}}"`, service)
 	out, err := p.run(ctx, addr, cmd)
 	if err != nil {
 		return deploy{}, fmt.Errorf("listing containers: %w", err)
@@ -28,13 +28,18 @@ func (p *serverHistoryProvider) current(ctx context.Context, service, env string
 
 	// Take first matching line.
 	line := strings.SplitN(out, "\n", 2)[0]
-	parts := strings.SplitN(line, "\t", 2)
-	if len(parts) != 2 {
+	parts := strings.SplitN(line, "\t", 3)
+	if len(parts) < 2 {
 		return deploy{}, fmt.Errorf("unexpected docker ps output: %q", line)
 	}
 
 	name := parts[0]
 	status := parts[1]
+	var digest string
+	if len(parts) == 3 {
+		// Empty for containers deployed before digests were recorded.
+		digest = strings.TrimSpace(parts[2])
+	}
 
 	tag := parseContainerTag(service, name)
 	if tag == "" {
@@ -45,6 +50,7 @@ func (p *serverHistoryProvider) current(ctx context.Context, service, env string
 		Service: service,
 		Env:     env,
 		Tag:     tag,
+		Digest:  digest,
 		Uptime:  parseDockerUptime(status),
 	}, nil
 }
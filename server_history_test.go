@@ -125,6 +125,23 @@ func TestServerHistoryCurrentSSHError(t *testing.T) {
 	}
 }
 
+func TestServerHistoryCurrentDigest(t *testing.T) {
+	p := &serverHistoryProvider{
+		cfg: testConfig(),
+		run: func(_ context.Context, _, _ string) (string, error) {
+			return "backend-main-abc1234-20250101000000\tUp 3 hours\tsha256:abc\n", nil
+		},
+	}
+
+	d, err := p.current(context.Background(), "backend", "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Digest != "sha256:abc" {
+		t.Errorf("digest = %q, want %q", d.Digest, "sha256:abc")
+	}
+}
+
 func TestServerHistoryPrevious(t *testing.T) {
 	cfg := testConfig()
 
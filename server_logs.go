@@ -7,18 +7,41 @@ import (
 )
 
 type serverLogsProvider struct {
-	cfg config
+	cfg     config
+	history historyProvider
 }
 
-func (p *serverLogsProvider) tail(_ context.Context, service, env string, n int, since string) error {
+func (p *serverLogsProvider) tail(ctx context.Context, service, env string, n int, since string) error {
 	svc := p.cfg.Services[service]
 	ec := svc.Env[env]
 
-	container := service
 	follow := n == 0 && since == ""
-	args := dockerLogsArgs(container, since, n, follow)
+	lc := resolveLogConfig(p.cfg.Project, service, env, "", svc, ec)
 
-	// TODO: SSH into node and run docker logs
-	fmt.Printf("[%s] Would run on node %q: docker %s\n", service, ec.Node, strings.Join(args, " "))
+	// Containers are named <service>-<tag>, so docker logs needs the one
+	// that's running now.
+	var container string
+	if logsOnNode(lc.Driver) {
+		cur, err := p.history.current(ctx, service, env)
+		if err != nil {
+			return fmt.Errorf("finding running container: %w", err)
+		}
+		if cur.Tag == "" {
+			return fmt.Errorf("no running container for %s in %s", service, env)
+		}
+		container = service + "-" + cur.Tag
+	}
+
+	onNode, cmd, err := logsCommand(lc, service, env, container, since, n, follow)
+	if err != nil {
+		return err
+	}
+
+	// TODO: run the command over SSH or locally
+	if onNode {
+		fmt.Printf("[%s] Would run on node %q: %s\n", service, ec.Node, strings.Join(cmd, " "))
+	} else {
+		fmt.Printf("[%s] Would run locally: %s\n", service, strings.Join(cmd, " "))
+	}
 	return nil
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// smokeBodyLimit caps how much of a response body is searched for a
+// check's body text.
+const smokeBodyLimit = 1 << 20
+
+// smokeTester runs an env's smoke checks after a deploy.
+type smokeTester struct {
+	client *http.Client // nil means a client with a 10s timeout
+}
+
+// run makes each check's request and returns a summary like "3 smoke
+// checks passed", or an error listing the checks that failed.
+func (t *smokeTester) run(ctx context.Context, checks []smokeCheck) (string, error) {
+	transports := map[string]*http.Transport{} // for Host overrides, by TLS server name
+	defer func() {
+		for _, tr := range transports {
+			tr.CloseIdleConnections()
+		}
+	}()
+
+	var problems []string
+	for i, c := range checks {
+		reportProgress(ctx, "smoke check %d/%d: %s", i+1, len(checks), c.URL)
+		if err := t.check(ctx, c, transports); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", c.URL, err))
+		}
+	}
+	if len(problems) > 0 {
+		return "", fmt.Errorf("%d of %s failed: %s", len(problems), pluralize(len(checks), "smoke check"), summarizeProblems(problems, 3))
+	}
+	return pluralize(len(checks), "smoke check") + " passed", nil
+}
+
+func (t *smokeTester) check(ctx context.Context, c smokeCheck, transports map[string]*http.Transport) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range c.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+	if c.Host != "" {
+		req.Host = c.Host
+	}
+
+	resp, err := t.clientFor(c, transports).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	want := c.Status
+	if want == 0 {
+		want = http.StatusOK
+	}
+	if resp.StatusCode != want {
+		return fmt.Errorf("got %s, want %d", resp.Status, want)
+	}
+	if c.Body != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, smokeBodyLimit))
+		if err != nil {
+			return fmt.Errorf("reading body: %w", err)
+		}
+		if !strings.Contains(string(body), c.Body) {
+			return fmt.Errorf("body does not contain %q", c.Body)
+		}
+	}
+	return nil
+}
+
+// clientFor returns the client for c. Redirects are returned rather than
+// followed, and with a Host override TLS verifies the certificate of that
+// host instead of the URL's. Transports made for Host overrides are kept
+// in transports, so checks of the same host share them.
+func (t *smokeTester) clientFor(c smokeCheck, transports map[string]*http.Transport) *http.Client {
+	var client http.Client
+	if t != nil && t.client != nil {
+		client = *t.client
+	} else {
+		client.Timeout = 10 * time.Second
+		if c.Host != "" {
+			name := (&url.URL{Host: c.Host}).Hostname()
+			tr, ok := transports[name]
+			if !ok {
+				tr = http.DefaultTransport.(*http.Transport).Clone()
+				tr.TLSClientConfig = &tls.Config{ServerName: name}
+				transports[name] = tr
+			}
+			client.Transport = tr
+		}
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &client
+}
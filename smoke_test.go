@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func smokeServer(t *testing.T) (*httptest.Server, *http.Request) {
+	var last http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = *r
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte("<title>Welcome</title>"))
+		case "/old":
+			http.Redirect(w, r, "/", http.StatusMovedPermanently)
+		case "/private":
+			if r.Header.Get("Authorization") != "Bearer s3cret" || r.Host != "www.example.com" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &last
+}
+
+func TestSmokeRun(t *testing.T) {
+	srv, last := smokeServer(t)
+	t.Setenv("SMOKE_TOKEN", "s3cret")
+
+	st := &smokeTester{client: srv.Client()}
+	summary, err := st.run(context.Background(), []smokeCheck{
+		{URL: srv.URL + "/", Body: "Welcome"},
+		{URL: srv.URL + "/old", Status: http.StatusMovedPermanently},
+		{URL: srv.URL + "/private", Host: "www.example.com", Headers: map[string]string{"Authorization": "Bearer ${SMOKE_TOKEN}"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary != "3 smoke checks passed" {
+		t.Errorf("summary = %q", summary)
+	}
+	if last.Host != "www.example.com" {
+		t.Errorf("Host = %q, want www.example.com", last.Host)
+	}
+}
+
+func TestSmokeRunFailures(t *testing.T) {
+	srv, _ := smokeServer(t)
+
+	st := &smokeTester{client: srv.Client()}
+	_, err := st.run(context.Background(), []smokeCheck{
+		{URL: srv.URL + "/", Body: "Welcome"},
+		{URL: srv.URL + "/", Body: "Goodbye"},
+		{URL: srv.URL + "/old"},
+		{URL: srv.URL + "/missing"},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{
+		"3 of 4 smoke checks failed",
+		`/: body does not contain "Goodbye"`,
+		"/old: got 301 Moved Permanently, want 200",
+		"/missing: got 404 Not Found, want 200",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestDeployServiceSmoke(t *testing.T) {
+	srv, _ := smokeServer(t)
+	cfg := testConfig()
+	ec := cfg.Services["backend"].Env["staging"]
+	ec.Smoke = []smokeCheck{{URL: srv.URL + "/", Body: "Welcome"}}
+	cfg.Services["backend"].Env["staging"] = ec
+
+	p, md := testProviders(nil, nil)
+	p.smoke = &smokeTester{client: srv.Client()}
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+	if err := deployService(ctx, cfg, p, "backend", "staging", "main-abc1234-20250101000000", "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary != "1 smoke check passed" {
+		t.Errorf("summary = %q", summary)
+	}
+
+	// A failing check fails the deploy, after the switch.
+	ec.Smoke[0].Body = "Goodbye"
+	err := deployService(ctx, cfg, p, "backend", "staging", "main-abc1234-20250101000000", "", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "smoke: 1 of 1 smoke check failed") {
+		t.Errorf("err = %v, want a smoke failure", err)
+	}
+	if len(md.calls) != 2 {
+		t.Errorf("expected 2 deploy calls, got %d", len(md.calls))
+	}
+}
+
+func TestDeployServiceSmokeNoWait(t *testing.T) {
+	srv, last := smokeServer(t)
+	cfg := testConfig()
+	ec := cfg.Services["frontend"].Env["staging"]
+	ec.Smoke = []smokeCheck{{URL: srv.URL + "/", Body: "Welcome"}}
+	cfg.Services["frontend"].Env["staging"] = ec
+
+	p, _ := testProviders(nil, nil)
+	p.smoke = &smokeTester{client: srv.Client()}
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+	if err := deployService(ctx, cfg, p, "frontend", "staging", "main-abc1234-20250101000000", "", deployParams{noWait: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary != "1 smoke check skipped (--no-wait)" {
+		t.Errorf("summary = %q", summary)
+	}
+	if last.URL != nil {
+		t.Errorf("smoke check requested %s without waiting for the invalidation", last.URL)
+	}
+}
+
+func TestSmokeClientForSharesTransports(t *testing.T) {
+	transports := map[string]*http.Transport{}
+	var st *smokeTester
+	a := st.clientFor(smokeCheck{URL: "https://10.0.0.1/", Host: "www.example.com"}, transports)
+	b := st.clientFor(smokeCheck{URL: "https://10.0.0.2/health", Host: "www.example.com:443"}, transports)
+	c := st.clientFor(smokeCheck{URL: "https://10.0.0.1/", Host: "api.example.com"}, transports)
+
+	if a.Transport != b.Transport || a.Transport == c.Transport || len(transports) != 2 {
+		t.Errorf("got %d transports, want one per host", len(transports))
+	}
+	if tr := transports["api.example.com"]; tr.TLSClientConfig.ServerName != "api.example.com" {
+		t.Errorf("ServerName = %q", tr.TLSClientConfig.ServerName)
+	}
+	if d := st.clientFor(smokeCheck{URL: "https://www.example.com/"}, transports); d.Transport != nil {
+		t.Error("checks without a Host override should use the default transport")
+	}
+}
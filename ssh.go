@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path"
+	"regexp"
 	"strings"
 
 	"golang.org/x/crypto/ssh"
@@ -94,6 +97,66 @@ func (c *sshClient) run(ctx context.Context, cmd string) (string, error) {
 	return strings.TrimRight(stdout.String(), "\n"), nil
 }
 
+// stream runs cmd and copies its combined stdout and stderr to w as it is
+// produced, for long-running commands whose output should be shown live.
+func (c *sshClient) stream(ctx context.Context, cmd string, w io.Writer) error {
+	return c.streamWithInput(ctx, cmd, w, nil)
+}
+
+func (c *sshClient) streamWithInput(ctx context.Context, cmd string, w io.Writer, stdin io.Reader) error {
+	return c.exec(ctx, cmd, w, stdin, false)
+}
+
+// streamTTY is stream with a pseudo-terminal, for commands like docker pull
+// that only print detailed progress to a terminal. The output contains
+// terminal escape sequences.
+func (c *sshClient) streamTTY(ctx context.Context, cmd string, w io.Writer) error {
+	return c.exec(ctx, cmd, w, nil, true)
+}
+
+func (c *sshClient) exec(ctx context.Context, cmd string, w io.Writer, stdin io.Reader, tty bool) error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("creating SSH session: %w", err)
+	}
+	defer session.Close()
+
+	if tty {
+		// Wide enough that progress lines aren't wrapped.
+		if err := session.RequestPty("xterm", 50, 200, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+			return fmt.Errorf("requesting pty: %w", err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Signal(ssh.SIGTERM)
+		case <-done:
+		}
+	}()
+
+	session.Stdout = w
+	session.Stderr = w
+	session.Stdin = stdin
+
+	err = session.Run(cmd)
+	close(done)
+
+	if err != nil {
+		return fmt.Errorf("running %q: %w", cmd, err)
+	}
+	return nil
+}
+
+// upload writes data to dst on the remote host, creating parent
+// directories as needed.
+func (c *sshClient) upload(ctx context.Context, dst string, data []byte) error {
+	cmd := fmt.Sprintf("mkdir -p %s && cat > %s", shellQuote(path.Dir(dst)), shellQuote(dst))
+	return c.streamWithInput(ctx, cmd, io.Discard, bytes.NewReader(data))
+}
+
 func (c *sshClient) close() error {
 	return c.client.Close()
 }
@@ -107,3 +170,23 @@ func sshRun(ctx context.Context, addr, cmd string) (string, error) {
 	defer c.close()
 	return c.run(ctx, cmd)
 }
+
+var shellSafeRe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// shellQuote quotes s for a POSIX shell. Strings made only of safe characters
+// are returned unchanged so commands stay readable in logs and errors.
+func shellQuote(s string) string {
+	if shellSafeRe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes each argument and joins them into a command line.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
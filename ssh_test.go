@@ -28,3 +28,22 @@ func TestParseSSHAddr(t *testing.T) {
 		})
 	}
 }
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/etc/backend/prod.env", "/etc/backend/prod.env"},
+		{"hoist.previous=", "hoist.previous="},
+		{"", "''"},
+		{"traefik.http.routers.api.rule=Host(`api.com`)", "'traefik.http.routers.api.rule=Host(`api.com`)'"},
+		{"it's", `'it'\''s'`},
+	}
+
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
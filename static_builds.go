@@ -5,23 +5,75 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 type s3ListObjectsAPI interface {
 	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// staticEnvBuildsProvider lists the builds of static services, which live
+// in each env's bucket under its prefix.
+type staticEnvBuildsProvider struct {
+	cfg     config
+	s3      s3ListObjectsAPI
+	storage map[string]s3ListObjectsAPI // by service, for services with their own storage
+
+	mu      sync.Mutex
+	targets map[staticBuildsProvider]*staticBuildsProvider
+}
+
+// forEnv returns the provider of a service's builds in env. Services and
+// envs sharing a bucket, prefix and client share a provider.
+func (p *staticEnvBuildsProvider) forEnv(service, env string) buildsProvider {
+	ec := p.cfg.Services[service].Env[env]
+	t := staticBuildsProvider{s3: p.s3, bucket: ec.Bucket, prefix: bucketPrefix(ec)}
+	if c, ok := p.storage[service]; ok {
+		t.s3 = c
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bp, ok := p.targets[t]; ok {
+		return bp
+	}
+	if p.targets == nil {
+		p.targets = map[staticBuildsProvider]*staticBuildsProvider{}
+	}
+	bp := &t
+	p.targets[t] = bp
+	return bp
+}
+
+// listBuilds lists the builds present in every env of every static
+// service.
+func (p *staticEnvBuildsProvider) listBuilds(ctx context.Context, limit, offset int) ([]build, error) {
+	var services []string
+	for _, name := range sortedServiceNames(p.cfg) {
+		if p.cfg.Services[name].Type == "static" {
+			services = append(services, name)
+		}
+	}
+	bp := buildsForServices(p.cfg, providers{builds: map[string]buildsProvider{"static": p}}, services, "")
+	if bp == nil {
+		return nil, nil
+	}
+	return bp.listBuilds(ctx, limit, offset)
 }
 
 type staticBuildsProvider struct {
 	s3     s3ListObjectsAPI
 	bucket string
+	prefix string // key prefix of builds/ and manifests/, see bucketPrefix
 }
 
 func (p *staticBuildsProvider) listBuilds(ctx context.Context, limit, offset int) ([]build, error) {
 	var all []build
 
-	prefix := "builds/"
+	prefix := p.prefix + "builds/"
 	delimiter := "/"
 	input := &s3.ListObjectsV2Input{
 		Bucket:    &p.bucket,
@@ -39,7 +91,7 @@ func (p *staticBuildsProvider) listBuilds(ctx context.Context, limit, offset int
 			if cp.Prefix == nil {
 				continue
 			}
-			tagStr := strings.TrimPrefix(*cp.Prefix, "builds/")
+			tagStr := strings.TrimPrefix(*cp.Prefix, prefix)
 			tagStr = strings.TrimSuffix(tagStr, "/")
 
 			t, err := parseTag(tagStr)
@@ -68,5 +120,42 @@ func (p *staticBuildsProvider) listBuilds(ctx context.Context, limit, offset int
 		all = all[:limit]
 	}
 
+	// Builds uploaded by hoist publish carry their commit in the manifest's
+	// metadata. Others have no manifest and are left for enrichBuilds.
+	const maxWorkers = 20
+
+	sem := make(chan struct{}, maxWorkers)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+
+	for i := range all {
+		wg.Add(1)
+		go func(b *build) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			key := p.prefix + manifestKey(b.Tag)
+			out, err := p.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &p.bucket, Key: &key})
+			if err != nil {
+				if !isNotFound(err) {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("reading s3://%s/%s: %w", p.bucket, key, err)
+					}
+					mu.Unlock()
+				}
+				return
+			}
+			git := gitFromMetadata(out.Metadata)
+			b.Message, b.Author = git.Message, git.Author
+		}(&all[i])
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
 	return all, nil
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -11,8 +12,10 @@ import (
 )
 
 type stubS3List struct {
-	pages []s3.ListObjectsV2Output
-	err   error
+	pages   []s3.ListObjectsV2Output
+	err     error
+	meta    map[string]map[string]string // HeadObject metadata by key; other keys are not found
+	headErr error
 }
 
 func (s *stubS3List) ListObjectsV2(_ context.Context, _ *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
@@ -27,6 +30,17 @@ func (s *stubS3List) ListObjectsV2(_ context.Context, _ *s3.ListObjectsV2Input,
 	return &page, nil
 }
 
+func (s *stubS3List) HeadObject(_ context.Context, params *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if s.headErr != nil {
+		return nil, s.headErr
+	}
+	m, ok := s.meta[*params.Key]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{Metadata: m}, nil
+}
+
 func prefixes(tags ...string) []types.CommonPrefix {
 	var cps []types.CommonPrefix
 	for _, t := range tags {
@@ -195,3 +209,78 @@ func TestStaticBuildsEmpty(t *testing.T) {
 		t.Errorf("expected nil builds, got %d", len(builds))
 	}
 }
+
+func TestStaticBuildsManifestMetadata(t *testing.T) {
+	stub := &stubS3List{
+		pages: []s3.ListObjectsV2Output{
+			{CommonPrefixes: prefixes("main-abc1234-20250101100000", "main-def5678-20250101090000")},
+		},
+		meta: map[string]map[string]string{
+			manifestKey("main-abc1234-20250101100000"): gitMetadata(gitInfo{Author: "Zoë", Message: "Fix header"}),
+		},
+	}
+
+	p := &staticBuildsProvider{s3: stub, bucket: "test-bucket"}
+	builds, err := p.listBuilds(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if builds[0].Author != "Zoë" || builds[0].Message != "Fix header" {
+		t.Errorf("builds[0] = %+v, want commit from the manifest", builds[0])
+	}
+	if builds[1].Author != "" || builds[1].Message != "" {
+		t.Errorf("builds[1] = %+v, want no commit without a manifest", builds[1])
+	}
+}
+
+func TestStaticBuildsManifestError(t *testing.T) {
+	stub := &stubS3List{
+		pages: []s3.ListObjectsV2Output{
+			{CommonPrefixes: prefixes("main-abc1234-20250101100000")},
+		},
+		headErr: fmt.Errorf("access denied"),
+	}
+
+	p := &staticBuildsProvider{s3: stub, bucket: "test-bucket"}
+	_, err := p.listBuilds(context.Background(), 10, 0)
+	if err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Fatalf("err = %v, want the HeadObject error", err)
+	}
+}
+
+func TestStaticEnvBuilds(t *testing.T) {
+	cfg := testConfig()
+	cfg.Services["docs"] = serviceConfig{Type: "static", Env: map[string]envConfig{
+		"staging":    {Bucket: "docs", Prefix: "staging"},
+		"production": {Bucket: "docs", Prefix: "prod"},
+	}}
+	cfg.Services["site"] = serviceConfig{Type: "static", Env: map[string]envConfig{
+		"staging": {Bucket: "frontend-staging"},
+	}}
+	defaultS3, docsS3 := &stubS3List{}, &stubS3List{}
+	sp := &staticEnvBuildsProvider{cfg: cfg, s3: defaultS3, storage: map[string]s3ListObjectsAPI{"docs": docsS3}}
+	p := providers{builds: map[string]buildsProvider{"static": sp}}
+
+	for _, tt := range []struct {
+		service, env string
+		want         staticBuildsProvider
+	}{
+		{"frontend", "staging", staticBuildsProvider{s3: defaultS3, bucket: "frontend-staging"}},
+		{"frontend", "production", staticBuildsProvider{s3: defaultS3, bucket: "frontend-prod"}},
+		{"docs", "production", staticBuildsProvider{s3: docsS3, bucket: "docs", prefix: "prod/"}},
+	} {
+		bp := buildsForServices(cfg, p, []string{tt.service}, tt.env)
+		got, ok := bp.(*staticBuildsProvider)
+		if !ok || *got != tt.want {
+			t.Errorf("%s %s: provider = %#v, want %#v", tt.service, tt.env, bp, tt.want)
+		}
+	}
+
+	// Services sharing a bucket share a provider; other envs intersect.
+	if bp := buildsForServices(cfg, p, []string{"frontend", "site"}, "staging"); bp != sp.forEnv("frontend", "staging") {
+		t.Errorf("frontend and site staging: provider = %#v, want the shared one", bp)
+	}
+	if bp, ok := buildsForServices(cfg, p, []string{"docs"}, "").(*mergedBuildsProvider); !ok || len(bp.providers) != 2 {
+		t.Errorf("docs without env: provider = %#v, want one per env", bp)
+	}
+}
@@ -2,84 +2,157 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
-	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type s3DeployAPI interface {
 	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
 	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
 	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
 }
 
 type cfInvalidateAPI interface {
 	CreateInvalidation(ctx context.Context, params *cloudfront.CreateInvalidationInput, optFns ...func(*cloudfront.Options)) (*cloudfront.CreateInvalidationOutput, error)
+	GetInvalidation(ctx context.Context, params *cloudfront.GetInvalidationInput, optFns ...func(*cloudfront.Options)) (*cloudfront.GetInvalidationOutput, error)
 }
 
 type staticDeployer struct {
-	cfg        config
-	s3         s3DeployAPI
-	cloudfront cfInvalidateAPI
+	cfg          config
+	s3           s3DeployAPI
+	storage      map[string]s3DeployAPI // by service, for services with their own storage
+	cloudfront   cfDeployAPI
+	http         *http.Client     // purge webhooks; nil means a client with a 30s timeout
+	now          func() time.Time // nil means time.Now
+	pollInterval time.Duration    // 0 means use default (15s)
+	pollTimeout  time.Duration    // 0 means use default (30m)
 }
 
-func (d *staticDeployer) deploy(ctx context.Context, service, env, tag, oldTag string) error {
-	ec := d.cfg.Services[service].Env[env]
+func (d *staticDeployer) deploy(ctx context.Context, service, env, tag, oldTag string, params deployParams) error {
+	d = d.forService(service)
+	svc := d.cfg.Services[service]
+	ec := svc.Env[env]
 	bucket := ec.Bucket
-	distID := ec.CloudFront
 
-	// Write previous-tag marker.
+	// Check the build before anything in the bucket changes.
+	buildPrefix := bucketKey(ec, "builds/"+tag+"/")
+	build, err := d.listObjects(ctx, bucket, buildPrefix)
+	if err != nil {
+		return fmt.Errorf("listing build objects in s3://%s/%s: %w", bucket, buildPrefix, err)
+	}
+	if len(build) == 0 {
+		return fmt.Errorf("build not found: s3://%s/%s", bucket, buildPrefix)
+	}
+	if err := d.checkBuild(ctx, svc, ec, tag, build); err != nil {
+		return err
+	}
+
+	var rel staticRelease
+	if ec.Strategy == "origin_path" {
+		rel, err = d.switchOrigin(ctx, svc, ec, tag, build, params.noWait)
+	} else {
+		rel, err = d.syncCurrent(ctx, svc, ec, tag, build)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Write previous-tag marker now that the release is current.
 	if oldTag != "" {
-		if err := d.putMarker(ctx, bucket, "previous-tag", oldTag); err != nil {
+		if err := d.putMarker(ctx, bucket, bucketKey(ec, "previous-tag"), oldTag); err != nil {
 			return fmt.Errorf("writing previous-tag marker: %w", err)
 		}
 	}
 
-	// List build objects.
-	keys, err := d.listBuildObjects(ctx, bucket, tag)
+	purged, err := d.purge(ctx, service, env, tag, rel, params.noWait)
+	if err != nil {
+		return err
+	}
+	reportSummary(ctx, "%s, %s", rel.summary, purged)
+	return nil
+}
+
+// staticRelease describes what a static deploy changed.
+type staticRelease struct {
+	changed []string // keys, relative to the build root, whose content or metadata changed
+	all     bool     // what changed is unknown; invalidate everything
+	summary string
+}
+
+// syncCurrent makes current/ a copy of the build: new and changed files are
+// copied, and files the build doesn't have are deleted. When the metadata
+// rules changed since the last deploy, unchanged files are copied again so
+// they get the new metadata. build is the checked listing of builds/<tag>/.
+func (d *staticDeployer) syncCurrent(ctx context.Context, svc serviceConfig, ec envConfig, tag string, build map[string]s3Object) (staticRelease, error) {
+	bucket := ec.Bucket
+	buildPrefix := bucketKey(ec, "builds/"+tag+"/")
+
+	currentPrefix := bucketKey(ec, "current/")
+	current, err := d.listObjects(ctx, bucket, currentPrefix)
 	if err != nil {
-		return fmt.Errorf("listing build objects in s3://%s/builds/%s/: %w", bucket, tag, err)
+		return staticRelease{}, fmt.Errorf("listing s3://%s/%s: %w", bucket, currentPrefix, err)
 	}
-	if len(keys) == 0 {
-		return fmt.Errorf("build not found: s3://%s/builds/%s/", bucket, tag)
+	if err := d.readSHA256(ctx, bucket, currentPrefix, current, build); err != nil {
+		return staticRelease{}, err
 	}
+	diff := diffObjects(build, current)
 
-	// Copy build objects to current/.
-	buildPrefix := "builds/" + tag + "/"
-	if err := d.copyObjects(ctx, bucket, buildPrefix, "current/", keys); err != nil {
-		return err
+	rulesHash := metadataRulesHash(svc.Metadata)
+	applied, err := d.readMarker(ctx, bucket, bucketKey(ec, metadataRulesMarker))
+	if err != nil {
+		return staticRelease{}, err
+	}
+	keys := append(diff.added, diff.updated...)
+	restamped := 0
+	if applied != rulesHash {
+		keys = append(keys, diff.unchanged...)
+		restamped = len(diff.unchanged)
+	}
+
+	// Copy new and changed build objects to current/.
+	reportProgress(ctx, "copying %d files...", len(keys))
+	if err := d.copyObjects(ctx, bucket, buildPrefix, currentPrefix, keys, svc.Metadata); err != nil {
+		return staticRelease{}, err
+	}
+	if applied != rulesHash {
+		if err := d.putMarker(ctx, bucket, bucketKey(ec, metadataRulesMarker), rulesHash); err != nil {
+			return staticRelease{}, fmt.Errorf("writing %s marker: %w", metadataRulesMarker, err)
+		}
 	}
 
 	// Write current-tag marker.
-	if err := d.putMarker(ctx, bucket, "current-tag", tag); err != nil {
-		return fmt.Errorf("writing current-tag marker: %w", err)
-	}
-
-	// Invalidate CloudFront.
-	callerRef := fmt.Sprintf("hoist-%s-%d", tag, time.Now().UnixNano())
-	path := "/*"
-	quantity := int32(1)
-	_, err = d.cloudfront.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
-		DistributionId: &distID,
-		InvalidationBatch: &cftypes.InvalidationBatch{
-			CallerReference: &callerRef,
-			Paths: &cftypes.Paths{
-				Quantity: &quantity,
-				Items:    []string{path},
-			},
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("invalidating CloudFront %s: %w", distID, err)
+	if err := d.putMarker(ctx, bucket, bucketKey(ec, "current-tag"), tag); err != nil {
+		return staticRelease{}, fmt.Errorf("writing current-tag marker: %w", err)
 	}
 
-	return nil
+	// Delete files the build no longer has, now that nothing new refers to
+	// them.
+	removed, kept, err := d.removeStale(ctx, ec, diff.removed)
+	if err != nil {
+		return staticRelease{}, err
+	}
+	summary := fmt.Sprintf("%d added, %d updated, %d removed", len(diff.added), len(diff.updated), len(removed))
+	if restamped > 0 {
+		summary += fmt.Sprintf(", %d metadata updated", restamped)
+	}
+	if kept > 0 {
+		summary += fmt.Sprintf(", %d stale kept for %s", kept, ec.DeleteGrace)
+	}
+	return staticRelease{changed: append(keys, removed...), summary: summary}, nil
 }
 
 func (d *staticDeployer) putMarker(ctx context.Context, bucket, key, value string) error {
@@ -92,9 +165,17 @@ func (d *staticDeployer) putMarker(ctx context.Context, bucket, key, value strin
 	return err
 }
 
-func (d *staticDeployer) listBuildObjects(ctx context.Context, bucket, tag string) ([]string, error) {
-	var keys []string
-	prefix := "builds/" + tag + "/"
+// s3Object is what a listing tells about an object.
+type s3Object struct {
+	etag   string
+	size   int64
+	sha256 string // from the manifest or the object's metadata, if known
+}
+
+// listObjects returns the objects under prefix, keyed by the object key
+// relative to prefix.
+func (d *staticDeployer) listObjects(ctx context.Context, bucket, prefix string) (map[string]s3Object, error) {
+	objects := map[string]s3Object{}
 	input := &s3.ListObjectsV2Input{
 		Bucket: &bucket,
 		Prefix: &prefix,
@@ -107,7 +188,7 @@ func (d *staticDeployer) listBuildObjects(ctx context.Context, bucket, tag strin
 		}
 		for _, obj := range out.Contents {
 			if obj.Key != nil {
-				keys = append(keys, *obj.Key)
+				objects[strings.TrimPrefix(*obj.Key, prefix)] = s3Object{etag: aws.ToString(obj.ETag), size: aws.ToInt64(obj.Size)}
 			}
 		}
 		if out.IsTruncated == nil || !*out.IsTruncated {
@@ -116,10 +197,60 @@ func (d *staticDeployer) listBuildObjects(ctx context.Context, bucket, tag strin
 		input.ContinuationToken = out.NextContinuationToken
 	}
 
-	return keys, nil
+	return objects, nil
+}
+
+// readSHA256 records the sha256 metadata of objects in current whose ETag
+// differs from the build's but whose size matches, when the build's
+// SHA-256 is known. A copy of a multipart upload gets a new ETag even
+// though its content is the same.
+func (d *staticDeployer) readSHA256(ctx context.Context, bucket, prefix string, current, build map[string]s3Object) error {
+	var keys []string
+	for key, obj := range build {
+		cur, ok := current[key]
+		if ok && obj.sha256 != "" && cur.etag != obj.etag && cur.size == obj.size {
+			keys = append(keys, key)
+		}
+	}
+
+	const maxWorkers = 20
+
+	sem := make(chan struct{}, maxWorkers)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			full := prefix + key
+			head, err := d.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &full})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("reading metadata of s3://%s/%s: %w", bucket, full, err)
+				}
+				return
+			}
+			obj := current[key]
+			obj.sha256 = head.Metadata[manifestSHA256Key]
+			current[key] = obj
+		}(key)
+	}
+
+	wg.Wait()
+	return firstErr
 }
 
-func (d *staticDeployer) copyObjects(ctx context.Context, bucket, srcPrefix, dstPrefix string, keys []string) error {
+// copyObjects copies srcPrefix+key to dstPrefix+key for each key. Objects
+// that metadata rules match get the source's metadata with the rules
+// applied; others keep the source's metadata as is.
+func (d *staticDeployer) copyObjects(ctx context.Context, bucket, srcPrefix, dstPrefix string, keys []string, rules []metadataRule) error {
 	const maxWorkers = 20
 
 	sem := make(chan struct{}, maxWorkers)
@@ -134,19 +265,14 @@ func (d *staticDeployer) copyObjects(ctx context.Context, bucket, srcPrefix, dst
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			relKey := strings.TrimPrefix(key, srcPrefix)
-			dst := dstPrefix + relKey
-			src := bucket + "/" + key
+			dst := dstPrefix + key
+			src := bucket + "/" + srcPrefix + key
 
-			_, err := d.s3.CopyObject(ctx, &s3.CopyObjectInput{
-				Bucket:     &bucket,
-				Key:        aws.String(dst),
-				CopySource: aws.String(src),
-			})
+			err := d.copyObject(ctx, bucket, srcPrefix, dstPrefix, key, rules)
 			if err != nil {
 				mu.Lock()
 				if firstErr == nil {
-					firstErr = fmt.Errorf("copying s3://%s/%s to s3://%s/%s: %w", bucket, key, bucket, dst, err)
+					firstErr = fmt.Errorf("copying s3://%s to s3://%s/%s: %w", src, bucket, dst, err)
 				}
 				mu.Unlock()
 			}
@@ -156,3 +282,164 @@ func (d *staticDeployer) copyObjects(ctx context.Context, bucket, srcPrefix, dst
 	wg.Wait()
 	return firstErr
 }
+
+func (d *staticDeployer) copyObject(ctx context.Context, bucket, srcPrefix, dstPrefix, key string, rules []metadataRule) error {
+	src := srcPrefix + key
+	in := &s3.CopyObjectInput{
+		Bucket:     &bucket,
+		Key:        aws.String(dstPrefix + key),
+		CopySource: aws.String(bucket + "/" + src),
+	}
+	if want, ok := metadataFor(rules, key); ok {
+		head, err := d.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &src})
+		if err != nil {
+			return err
+		}
+		headMeta(head).overlay(want).applyToCopy(in)
+	}
+	_, err := d.s3.CopyObject(ctx, in)
+	return err
+}
+
+// objectDiff lists keys, relative to current/, that a sync changes.
+type objectDiff struct {
+	added, updated, unchanged, removed []string
+}
+
+// diffObjects compares a build with current/ by SHA-256 where both have
+// one, and by ETag otherwise. Both are keyed by relative key.
+func diffObjects(build, current map[string]s3Object) objectDiff {
+	var d objectDiff
+	for key, obj := range build {
+		cur, ok := current[key]
+		switch {
+		case !ok:
+			d.added = append(d.added, key)
+		case obj.sha256 != "" && cur.sha256 != "":
+			if obj.sha256 != cur.sha256 {
+				d.updated = append(d.updated, key)
+			} else {
+				d.unchanged = append(d.unchanged, key)
+			}
+		case cur.etag != obj.etag:
+			d.updated = append(d.updated, key)
+		default:
+			d.unchanged = append(d.unchanged, key)
+		}
+	}
+	for key := range current {
+		if _, ok := build[key]; !ok {
+			d.removed = append(d.removed, key)
+		}
+	}
+	sort.Strings(d.added)
+	sort.Strings(d.updated)
+	sort.Strings(d.unchanged)
+	sort.Strings(d.removed)
+	return d
+}
+
+// staleKeysMarker records when each stale key in current/ was first seen,
+// so keys are deleted once they've been stale for the grace period.
+const staleKeysMarker = "stale-keys"
+
+// removeStale deletes stale keys from current/. With a grace period, a key
+// is only deleted by the first deploy at least grace after the deploy that
+// made it stale, so pages cached before the switch can still load their
+// assets. It returns the keys deleted and how many were kept.
+func (d *staticDeployer) removeStale(ctx context.Context, ec envConfig, stale []string) (removed []string, kept int, err error) {
+	bucket, grace := ec.Bucket, ec.DeleteGrace
+	currentPrefix := bucketKey(ec, "current/")
+	if grace == 0 {
+		if err := d.deleteObjects(ctx, bucket, currentPrefix, stale); err != nil {
+			return nil, 0, err
+		}
+		return stale, 0, nil
+	}
+
+	now := time.Now
+	if d.now != nil {
+		now = d.now
+	}
+	seen, err := d.readStaleKeys(ctx, ec)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var due []string
+	record := make(map[string]time.Time, len(stale))
+	for _, key := range stale {
+		t, ok := seen[key]
+		if !ok {
+			t = now()
+		}
+		if now().Sub(t) >= grace {
+			due = append(due, key)
+			continue
+		}
+		record[key] = t
+	}
+	if err := d.deleteObjects(ctx, bucket, currentPrefix, due); err != nil {
+		return nil, 0, err
+	}
+	if len(record) > 0 || len(seen) > 0 {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := d.putMarker(ctx, bucket, bucketKey(ec, staleKeysMarker), string(data)); err != nil {
+			return nil, 0, fmt.Errorf("writing %s marker: %w", staleKeysMarker, err)
+		}
+	}
+	return due, len(record), nil
+}
+
+func (d *staticDeployer) readStaleKeys(ctx context.Context, ec envConfig) (map[string]time.Time, error) {
+	bucket := ec.Bucket
+	data, err := d.readMarker(ctx, bucket, bucketKey(ec, staleKeysMarker))
+	if err != nil || data == "" {
+		return nil, err
+	}
+	var seen map[string]time.Time
+	if err := json.Unmarshal([]byte(data), &seen); err != nil {
+		return nil, fmt.Errorf("parsing %s in s3://%s: %w", staleKeysMarker, bucket, err)
+	}
+	return seen, nil
+}
+
+func isNoSuchKey(err error) bool {
+	var nsk *s3types.NoSuchKey
+	return errors.As(err, &nsk)
+}
+
+// isNotFound reports whether err means an object doesn't exist. HeadObject
+// responses have no body, so S3 can only say NotFound.
+func isNotFound(err error) bool {
+	var nf *s3types.NotFound
+	return errors.As(err, &nf) || isNoSuchKey(err)
+}
+
+// deleteObjects deletes prefix+key for each key, in batches of the 1000
+// keys S3 accepts per request.
+func (d *staticDeployer) deleteObjects(ctx context.Context, bucket, prefix string, keys []string) error {
+	const batch = 1000
+	for start := 0; start < len(keys); start += batch {
+		end := min(start+batch, len(keys))
+		var ids []s3types.ObjectIdentifier
+		for _, key := range keys[start:end] {
+			ids = append(ids, s3types.ObjectIdentifier{Key: aws.String(prefix + key)})
+		}
+		out, err := d.s3.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &bucket,
+			Delete: &s3types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("deleting stale objects from s3://%s/%s: %w", bucket, prefix, err)
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("deleting s3://%s/%s: %s (%d more errors)", bucket, aws.ToString(e.Key), aws.ToString(e.Message), len(out.Errors)-1)
+		}
+	}
+	return nil
+}
@@ -3,13 +3,16 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"sort"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
@@ -19,15 +22,29 @@ type stubS3Deploy struct {
 	listPages  []s3.ListObjectsV2Output
 	copyInputs []s3.CopyObjectInput
 	putInputs  []s3.PutObjectInput
+	objects    map[string]string     // key -> ETag; when set, listings come from here instead of listPages
+	sizes      map[string]int64      // listed sizes by key
+	bodies     map[string]string     // GetObject contents by key
+	meta       map[string]objectMeta // HeadObject results by key
+	deleted    []string
 	listErr    error
 	copyErr    error
 	putErr     error
 }
 
-func (s *stubS3Deploy) ListObjectsV2(_ context.Context, _ *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+func (s *stubS3Deploy) ListObjectsV2(_ context.Context, params *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
 	if s.listErr != nil {
 		return nil, s.listErr
 	}
+	if s.objects != nil {
+		var out s3.ListObjectsV2Output
+		for key, etag := range s.objects {
+			if strings.HasPrefix(key, *params.Prefix) {
+				out.Contents = append(out.Contents, s3types.Object{Key: aws.String(key), ETag: aws.String(etag), Size: aws.Int64(s.sizes[key])})
+			}
+		}
+		return &out, nil
+	}
 	if len(s.listPages) == 0 {
 		return &s3.ListObjectsV2Output{}, nil
 	}
@@ -58,10 +75,55 @@ func (s *stubS3Deploy) PutObject(_ context.Context, params *s3.PutObjectInput, _
 	return &s3.PutObjectOutput{}, nil
 }
 
+func (s *stubS3Deploy) GetObject(_ context.Context, params *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.bodies[*params.Key]
+	if !ok {
+		return nil, &s3types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func (s *stubS3Deploy) HeadObject(_ context.Context, params *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.meta[*params.Key]
+	opt := func(v string) *string {
+		if v == "" {
+			return nil
+		}
+		return aws.String(v)
+	}
+	return &s3.HeadObjectOutput{
+		CacheControl:       opt(m.CacheControl),
+		ContentType:        opt(m.ContentType),
+		ContentEncoding:    opt(m.ContentEncoding),
+		ContentDisposition: opt(m.ContentDisposition),
+		ContentLanguage:    opt(m.ContentLanguage),
+		Metadata:           m.Metadata,
+	}, nil
+}
+
+func (s *stubS3Deploy) DeleteObjects(_ context.Context, params *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range params.Delete.Objects {
+		s.deleted = append(s.deleted, *id.Key)
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
 type stubCFInvalidate struct {
-	mu    sync.Mutex
-	input *cloudfront.CreateInvalidationInput
-	err   error
+	mu       sync.Mutex
+	input    *cloudfront.CreateInvalidationInput
+	err      error
+	config   *cftypes.DistributionConfig
+	updates  []cloudfront.UpdateDistributionInput
+	statuses []string // returned by successive GetDistribution calls; then "Deployed"
+
+	invalidationStatuses []string // returned by successive GetInvalidation calls; then "Completed"
+	invalidationChecks   int
 }
 
 func (s *stubCFInvalidate) CreateInvalidation(_ context.Context, params *cloudfront.CreateInvalidationInput, _ ...func(*cloudfront.Options)) (*cloudfront.CreateInvalidationOutput, error) {
@@ -71,7 +133,44 @@ func (s *stubCFInvalidate) CreateInvalidation(_ context.Context, params *cloudfr
 	if s.err != nil {
 		return nil, s.err
 	}
-	return &cloudfront.CreateInvalidationOutput{}, nil
+	return &cloudfront.CreateInvalidationOutput{Invalidation: &cftypes.Invalidation{Id: aws.String("I1")}}, nil
+}
+
+func (s *stubCFInvalidate) GetInvalidation(_ context.Context, _ *cloudfront.GetInvalidationInput, _ ...func(*cloudfront.Options)) (*cloudfront.GetInvalidationOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invalidationChecks++
+	status := "Completed"
+	if len(s.invalidationStatuses) > 0 {
+		status, s.invalidationStatuses = s.invalidationStatuses[0], s.invalidationStatuses[1:]
+	}
+	return &cloudfront.GetInvalidationOutput{Invalidation: &cftypes.Invalidation{Id: aws.String("I1"), Status: &status}}, nil
+}
+
+func (s *stubCFInvalidate) GetDistribution(_ context.Context, _ *cloudfront.GetDistributionInput, _ ...func(*cloudfront.Options)) (*cloudfront.GetDistributionOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := "Deployed"
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	return &cloudfront.GetDistributionOutput{Distribution: &cftypes.Distribution{Status: &status}}, nil
+}
+
+func (s *stubCFInvalidate) GetDistributionConfig(_ context.Context, _ *cloudfront.GetDistributionConfigInput, _ ...func(*cloudfront.Options)) (*cloudfront.GetDistributionConfigOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config == nil {
+		return nil, fmt.Errorf("NoSuchDistribution")
+	}
+	return &cloudfront.GetDistributionConfigOutput{DistributionConfig: s.config, ETag: aws.String("E1")}, nil
+}
+
+func (s *stubCFInvalidate) UpdateDistribution(_ context.Context, params *cloudfront.UpdateDistributionInput, _ ...func(*cloudfront.Options)) (*cloudfront.UpdateDistributionOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updates = append(s.updates, *params)
+	return &cloudfront.UpdateDistributionOutput{}, nil
 }
 
 func s3Objects(keys ...string) []s3types.Object {
@@ -97,23 +196,27 @@ func TestStaticDeployHappyPath(t *testing.T) {
 
 	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: cf}
 
-	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000")
+	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "main-old1234-20241231000000", deployParams{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	// Verify previous-tag and current-tag markers written.
-	if len(stub.putInputs) != 2 {
-		t.Fatalf("expected 2 PutObject calls, got %d", len(stub.putInputs))
+	// Verify current-tag, previous-tag and last-invalidation markers written,
+	// previous-tag only once the release is current.
+	if len(stub.putInputs) != 3 {
+		t.Fatalf("expected 3 PutObject calls, got %d", len(stub.putInputs))
 	}
-	if *stub.putInputs[0].Key != "previous-tag" {
-		t.Errorf("put[0].Key = %q, want %q", *stub.putInputs[0].Key, "previous-tag")
+	if *stub.putInputs[0].Key != "current-tag" {
+		t.Errorf("put[0].Key = %q, want %q", *stub.putInputs[0].Key, "current-tag")
 	}
 	if *stub.putInputs[0].Bucket != "frontend-staging" {
 		t.Errorf("put[0].Bucket = %q, want %q", *stub.putInputs[0].Bucket, "frontend-staging")
 	}
-	if *stub.putInputs[1].Key != "current-tag" {
-		t.Errorf("put[1].Key = %q, want %q", *stub.putInputs[1].Key, "current-tag")
+	if *stub.putInputs[1].Key != "previous-tag" {
+		t.Errorf("put[1].Key = %q, want %q", *stub.putInputs[1].Key, "previous-tag")
+	}
+	if *stub.putInputs[2].Key != lastInvalidationMarker {
+		t.Errorf("put[2].Key = %q, want %q", *stub.putInputs[2].Key, lastInvalidationMarker)
 	}
 
 	// Verify copies.
@@ -143,8 +246,8 @@ func TestStaticDeployHappyPath(t *testing.T) {
 	if *cf.input.DistributionId != "E1234567890" {
 		t.Errorf("DistributionId = %q, want %q", *cf.input.DistributionId, "E1234567890")
 	}
-	if len(cf.input.InvalidationBatch.Paths.Items) != 1 || cf.input.InvalidationBatch.Paths.Items[0] != "/*" {
-		t.Errorf("invalidation paths = %v, want [/*]", cf.input.InvalidationBatch.Paths.Items)
+	if got := strings.Join(cf.input.InvalidationBatch.Paths.Items, " "); got != "/ /app.js /index.html" {
+		t.Errorf("invalidation paths = %v, want [/ /app.js /index.html]", cf.input.InvalidationBatch.Paths.Items)
 	}
 }
 
@@ -159,14 +262,14 @@ func TestStaticDeployNoOldTag(t *testing.T) {
 
 	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: cf}
 
-	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "")
+	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "", deployParams{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	// Only current-tag should be written (no previous-tag).
-	if len(stub.putInputs) != 1 {
-		t.Fatalf("expected 1 PutObject call, got %d", len(stub.putInputs))
+	// Only current-tag and last-invalidation should be written (no previous-tag).
+	if len(stub.putInputs) != 2 {
+		t.Fatalf("expected 2 PutObject calls, got %d", len(stub.putInputs))
 	}
 	if *stub.putInputs[0].Key != "current-tag" {
 		t.Errorf("put[0].Key = %q, want %q", *stub.putInputs[0].Key, "current-tag")
@@ -184,7 +287,7 @@ func TestStaticDeployBuildNotFound(t *testing.T) {
 
 	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: cf}
 
-	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "")
+	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "", deployParams{})
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -208,7 +311,7 @@ func TestStaticDeployListError(t *testing.T) {
 
 	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: cf}
 
-	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "")
+	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "", deployParams{})
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -235,7 +338,7 @@ func TestStaticDeployCopyError(t *testing.T) {
 
 	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: cf}
 
-	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "")
+	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "", deployParams{})
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -266,7 +369,7 @@ func TestStaticDeployInvalidationError(t *testing.T) {
 
 	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: cf}
 
-	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "")
+	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "", deployParams{})
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -304,7 +407,7 @@ func TestStaticDeployPagination(t *testing.T) {
 
 	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: cf}
 
-	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "")
+	err := d.deploy(context.Background(), "frontend", "staging", "main-abc1234-20250101000000", "", deployParams{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -322,3 +425,89 @@ func TestStaticDeployPagination(t *testing.T) {
 		t.Errorf("copy destinations = %v, want [current/page1.html current/page2.html]", dstKeys)
 	}
 }
+
+func TestStaticDeploySync(t *testing.T) {
+	cfg := testConfig()
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{
+		"builds/" + tag + "/index.html":    `"new-index"`,
+		"builds/" + tag + "/app.2.js":      `"app2"`,
+		"builds/" + tag + "/logo.svg":      `"logo"`,
+		"current/index.html":               `"old-index"`,
+		"current/app.1.js":                 `"app1"`,
+		"current/logo.svg":                 `"logo"`,
+		"current/debug.log":                `"debug"`,
+		"builds/main-old1234-x/index.html": `"old-index"`,
+	}}
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+
+	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: &stubCFInvalidate{}}
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var copied []string
+	for _, c := range stub.copyInputs {
+		copied = append(copied, *c.Key)
+	}
+	sort.Strings(copied)
+	if strings.Join(copied, " ") != "current/app.2.js current/index.html" {
+		t.Errorf("copied = %v, want only added and changed files", copied)
+	}
+	sort.Strings(stub.deleted)
+	if strings.Join(stub.deleted, " ") != "current/app.1.js current/debug.log" {
+		t.Errorf("deleted = %v, want stale files", stub.deleted)
+	}
+	if summary != "1 added, 1 updated, 2 removed, invalidated 5 paths (I1)" {
+		t.Errorf("summary = %q", summary)
+	}
+}
+
+func TestStaticDeployDeleteGrace(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["frontend"]
+	ec := svc.Env["staging"]
+	ec.DeleteGrace = time.Hour
+	svc.Env["staging"] = ec
+	cfg.Services["frontend"] = svc
+
+	tag := "main-abc1234-20250101000000"
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	stub := &stubS3Deploy{
+		objects: map[string]string{
+			"builds/" + tag + "/index.html": `"index"`,
+			"current/index.html":            `"index"`,
+			"current/app.1.js":              `"app1"`,
+			"current/app.0.js":              `"app0"`,
+		},
+		bodies: map[string]string{
+			staleKeysMarker: `{"app.0.js":"2025-01-01T10:00:00Z"}`,
+		},
+	}
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+
+	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: &stubCFInvalidate{}, now: func() time.Time { return now }}
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Join(stub.deleted, " ") != "current/app.0.js" {
+		t.Errorf("deleted = %v, want only the key stale for longer than the grace period", stub.deleted)
+	}
+	if summary != "0 added, 0 updated, 1 removed, 1 stale kept for 1h0m0s, invalidated 1 path (I1)" {
+		t.Errorf("summary = %q", summary)
+	}
+
+	var record string
+	for _, p := range stub.putInputs {
+		if *p.Key == staleKeysMarker {
+			b, _ := io.ReadAll(p.Body)
+			record = string(b)
+		}
+	}
+	if record != `{"app.1.js":"2025-01-01T12:00:00Z"}` {
+		t.Errorf("stale-keys = %s, want app.1.js first seen now", record)
+	}
+}
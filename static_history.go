@@ -17,9 +17,10 @@ type s3GetObjectAPI interface {
 }
 
 type staticHistoryProvider struct {
-	cfg config
-	s3  s3GetObjectAPI
-	now func() time.Time
+	cfg     config
+	s3      s3GetObjectAPI
+	storage map[string]s3GetObjectAPI // by service, for services with their own storage
+	now     func() time.Time
 }
 
 func (p *staticHistoryProvider) current(ctx context.Context, service, env string) (deploy, error) {
@@ -31,9 +32,15 @@ func (p *staticHistoryProvider) previous(ctx context.Context, service, env strin
 }
 
 func (p *staticHistoryProvider) readMarker(ctx context.Context, service, env, key string) (deploy, error) {
-	bucket := p.cfg.Services[service].Env[env].Bucket
+	ec := p.cfg.Services[service].Env[env]
+	bucket := ec.Bucket
+	key = bucketKey(ec, key)
+	api := p.s3
+	if c, ok := p.storage[service]; ok {
+		api = c
+	}
 
-	out, err := p.s3.GetObject(ctx, &s3.GetObjectInput{
+	out, err := api.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: &bucket,
 		Key:    &key,
 	})
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// defaultInvalidationMaxPaths is how many paths a deploy invalidates before
+// collapsing them into wildcards. CloudFront bills invalidations per path,
+// and a wildcard counts as one.
+const defaultInvalidationMaxPaths = 15
+
+// lastInvalidationMarker holds the ID of the last invalidation a deploy
+// created.
+const lastInvalidationMarker = "last-invalidation"
+
+func invalidationMaxPaths(ec envConfig) int {
+	if ec.InvalidationMaxPaths > 0 {
+		return ec.InvalidationMaxPaths
+	}
+	return defaultInvalidationMaxPaths
+}
+
+// invalidationPaths turns changed keys into the URL paths CloudFront caches
+// them under. An index.html is also served for its directory.
+func invalidationPaths(keys []string) []string {
+	set := map[string]bool{}
+	for _, key := range keys {
+		p := (&url.URL{Path: "/" + key}).EscapedPath()
+		set[p] = true
+		if dir, ok := strings.CutSuffix(p, "index.html"); ok && strings.HasSuffix(dir, "/") {
+			set[dir] = true
+		}
+	}
+	paths := make([]string, 0, len(set))
+	for p := range set {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// collapsePaths replaces paths with directory wildcards until there are at
+// most max of them. It collapses the deepest directories with several
+// entries first, so as much of the site as possible stays cached.
+func collapsePaths(paths []string, max int) []string {
+	set := map[string]bool{}
+	for _, p := range paths {
+		set[p] = true
+	}
+	for len(set) > max {
+		// The parent of /a/b.js and of /a/b/* is /a/.
+		counts := map[string]int{}
+		for p := range set {
+			counts[parentDir(p)]++
+		}
+		dirs := make([]string, 0, len(counts))
+		for dir := range counts {
+			dirs = append(dirs, dir)
+		}
+		sort.Slice(dirs, func(i, j int) bool {
+			a, b := dirs[i], dirs[j]
+			if (counts[a] > 1) != (counts[b] > 1) {
+				return counts[a] > 1
+			}
+			if da, db := strings.Count(a, "/"), strings.Count(b, "/"); da != db {
+				return da > db
+			}
+			if counts[a] != counts[b] {
+				return counts[a] > counts[b]
+			}
+			return a < b
+		})
+		best := dirs[0]
+		if best == "/" {
+			return []string{"/*"}
+		}
+		for p := range set {
+			if strings.HasPrefix(p, best) {
+				delete(set, p)
+			}
+		}
+		set[best+"*"] = true
+	}
+	out := make([]string, 0, len(set))
+	for p := range set {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func parentDir(p string) string {
+	p = strings.TrimSuffix(strings.TrimSuffix(p, "*"), "/")
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return p[:i+1]
+	}
+	return "/"
+}
+
+// invalidateCloudFront invalidates what a release changed in the env's
+// distribution and, unless noWait is set, waits until the invalidation
+// completes. It returns a summary of what it did.
+func (d *staticDeployer) invalidateCloudFront(ctx context.Context, ec envConfig, tag string, rel staticRelease, noWait bool) (string, error) {
+	paths := []string{"/*"}
+	if !rel.all {
+		paths = collapsePaths(invalidationPaths(rel.changed), invalidationMaxPaths(ec))
+	}
+	if len(paths) == 0 {
+		return "nothing to invalidate", nil
+	}
+	id, err := d.invalidate(ctx, ec, tag, paths)
+	if err != nil {
+		return "", err
+	}
+	if noWait {
+		return fmt.Sprintf("invalidating %s (%s, not waiting)", pluralize(len(paths), "path"), id), nil
+	}
+	if err := d.waitInvalidated(ctx, ec.CloudFront, id, ec.InvalidationTimeout); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("invalidated %s (%s)", pluralize(len(paths), "path"), id), nil
+}
+
+// invalidate creates an invalidation for paths and records its ID in the
+// bucket.
+func (d *staticDeployer) invalidate(ctx context.Context, ec envConfig, tag string, paths []string) (string, error) {
+	distID := ec.CloudFront
+	callerRef := fmt.Sprintf("hoist-%s-%d", tag, time.Now().UnixNano())
+	out, err := d.cloudfront.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
+		DistributionId: &distID,
+		InvalidationBatch: &cftypes.InvalidationBatch{
+			CallerReference: &callerRef,
+			Paths: &cftypes.Paths{
+				Quantity: aws.Int32(int32(len(paths))),
+				Items:    paths,
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalidating CloudFront %s: %w", distID, err)
+	}
+	var id string
+	if out.Invalidation != nil {
+		id = aws.ToString(out.Invalidation.Id)
+	}
+	if err := d.putMarker(ctx, ec.Bucket, bucketKey(ec, lastInvalidationMarker), id); err != nil {
+		return "", fmt.Errorf("writing %s marker: %w", lastInvalidationMarker, err)
+	}
+	return id, nil
+}
+
+// waitInvalidated polls an invalidation until CloudFront reports it
+// completed, so a finished deploy means the edge serves the new build.
+func (d *staticDeployer) waitInvalidated(ctx context.Context, distID, id string, timeout time.Duration) error {
+	interval := d.pollInterval
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+	if timeout == 0 {
+		timeout = 15 * time.Minute
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		out, err := d.cloudfront.GetInvalidation(ctx, &cloudfront.GetInvalidationInput{DistributionId: &distID, Id: &id})
+		if err != nil {
+			return fmt.Errorf("getting CloudFront invalidation %s status: %w", id, err)
+		}
+		status := "unknown"
+		if out.Invalidation != nil && out.Invalidation.Status != nil {
+			status = *out.Invalidation.Status
+		}
+		if status == "Completed" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("CloudFront invalidation %s still %s after %s (use --no-wait to skip waiting)", id, status, timeout)
+		}
+		reportProgress(ctx, "invalidating CloudFront cache (%s, %s)...", status, time.Since(start).Round(time.Second))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
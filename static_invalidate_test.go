@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvalidationPaths(t *testing.T) {
+	got := invalidationPaths([]string{"index.html", "docs/index.html", "assets/app.js", "a b.txt", "docs/index.html"})
+	want := "/ /a%20b.txt /assets/app.js /docs/ /docs/index.html /index.html"
+	if strings.Join(got, " ") != want {
+		t.Errorf("paths = %v, want %s", got, want)
+	}
+}
+
+func TestCollapsePaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		max   int
+		want  string
+	}{
+		{"under limit", []string{"/a.js", "/b.js"}, 15, "/a.js /b.js"},
+		{"deepest first", []string{"/index.html", "/assets/js/a.js", "/assets/js/b.js", "/assets/c.css"}, 3, "/assets/c.css /assets/js/* /index.html"},
+		{"up a level", []string{"/index.html", "/assets/js/a.js", "/assets/js/b.js", "/assets/c.css"}, 2, "/assets/* /index.html"},
+		{"lone files", []string{"/x/1/a", "/x/2/b", "/x/3/c"}, 2, "/x/*"},
+		{"root", []string{"/a", "/b", "/c"}, 2, "/*"},
+	}
+	for _, tt := range tests {
+		got := collapsePaths(tt.paths, tt.max)
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStaticDeployCollapsesInvalidation(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{}}
+	for i := 0; i < 20; i++ {
+		stub.objects[fmt.Sprintf("builds/%s/assets/%d.js", tag, i)] = `"new"`
+	}
+	stub.objects["builds/"+tag+"/index.html"] = `"i"`
+	stub.objects["current/index.html"] = `"i"`
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+
+	cf := &stubCFInvalidate{}
+	d := &staticDeployer{cfg: testConfig(), s3: stub, cloudfront: cf}
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(cf.input.InvalidationBatch.Paths.Items, " "); got != "/assets/*" {
+		t.Errorf("invalidation paths = %s, want /assets/*", got)
+	}
+	if !strings.HasSuffix(summary, "invalidated 1 path (I1)") {
+		t.Errorf("summary = %q", summary)
+	}
+	var id string
+	for _, p := range stub.putInputs {
+		if *p.Key == lastInvalidationMarker {
+			b, _ := io.ReadAll(p.Body)
+			id = string(b)
+		}
+	}
+	if id != "I1" {
+		t.Errorf("last-invalidation marker = %q, want I1", id)
+	}
+}
+
+func TestStaticDeployNothingToInvalidate(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{
+		"builds/" + tag + "/index.html": `"i"`,
+		"current/index.html":            `"i"`,
+	}}
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+
+	cf := &stubCFInvalidate{}
+	d := &staticDeployer{cfg: testConfig(), s3: stub, cloudfront: cf}
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cf.input != nil {
+		t.Errorf("expected no invalidation, got %v", cf.input.InvalidationBatch.Paths.Items)
+	}
+	if summary != "0 added, 0 updated, 0 removed, nothing to invalidate" {
+		t.Errorf("summary = %q", summary)
+	}
+}
+
+func TestStaticDeployWaitsForInvalidation(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{"builds/" + tag + "/app.js": `"a"`}}
+	var progress []string
+	ctx := withProgress(context.Background(), func(d string) { progress = append(progress, d) })
+
+	cf := &stubCFInvalidate{invalidationStatuses: []string{"InProgress", "InProgress"}}
+	d := &staticDeployer{cfg: testConfig(), s3: stub, cloudfront: cf, pollInterval: time.Millisecond}
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cf.invalidationChecks != 3 {
+		t.Errorf("GetInvalidation calls = %d, want 3", cf.invalidationChecks)
+	}
+	if last := progress[len(progress)-1]; !strings.Contains(last, "InProgress") {
+		t.Errorf("last progress = %q, want the invalidation status", last)
+	}
+}
+
+func TestStaticDeployInvalidationTimeout(t *testing.T) {
+	cfg := testConfig()
+	svc := cfg.Services["frontend"]
+	ec := svc.Env["staging"]
+	ec.InvalidationTimeout = time.Millisecond
+	svc.Env["staging"] = ec
+	cfg.Services["frontend"] = svc
+
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{"builds/" + tag + "/app.js": `"a"`}}
+	statuses := make([]string, 1000)
+	for i := range statuses {
+		statuses[i] = "InProgress"
+	}
+	cf := &stubCFInvalidate{invalidationStatuses: statuses}
+	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: cf, pollInterval: time.Millisecond}
+	err := d.deploy(context.Background(), "frontend", "staging", tag, "", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "still InProgress") {
+		t.Fatalf("err = %v, want timeout", err)
+	}
+}
+
+func TestStaticDeployNoWait(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{"builds/" + tag + "/app.js": `"a"`}}
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+
+	cf := &stubCFInvalidate{invalidationStatuses: []string{"InProgress"}}
+	d := &staticDeployer{cfg: testConfig(), s3: stub, cloudfront: cf}
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{noWait: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cf.invalidationChecks != 0 {
+		t.Errorf("GetInvalidation calls = %d, want none with --no-wait", cf.invalidationChecks)
+	}
+	if !strings.HasSuffix(summary, "not waiting)") {
+		t.Errorf("summary = %q", summary)
+	}
+}
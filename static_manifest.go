@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// manifestSHA256Key is the user metadata key hoist publish stores each
+// file's SHA-256 under, so content can be checked without downloading it.
+const manifestSHA256Key = "sha256"
+
+// readManifest returns the manifest of a build, and false if it has none.
+func (d *staticDeployer) readManifest(ctx context.Context, ec envConfig, tag string) (buildManifest, bool, error) {
+	bucket, key := ec.Bucket, bucketKey(ec, manifestKey(tag))
+	data, err := d.readMarker(ctx, bucket, key)
+	if err != nil {
+		return buildManifest{}, false, err
+	}
+	if data == "" {
+		return buildManifest{}, false, nil
+	}
+	var m buildManifest
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return buildManifest{}, false, fmt.Errorf("decoding s3://%s/%s: %w", bucket, key, err)
+	}
+	return m, true, nil
+}
+
+// check lists how objects, keyed by path relative to the build root,
+// differ from the manifest: missing files, files of the wrong size and
+// files the manifest doesn't have.
+func (m buildManifest) check(objects map[string]s3Object) []string {
+	var problems []string
+	listed := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		listed[f.Path] = true
+		obj, ok := objects[f.Path]
+		switch {
+		case !ok:
+			problems = append(problems, f.Path+": missing")
+		case obj.size != f.Size:
+			problems = append(problems, fmt.Sprintf("%s: %d bytes, manifest says %d", f.Path, obj.size, f.Size))
+		}
+	}
+	for key := range objects {
+		if !listed[key] {
+			problems = append(problems, key+": not in manifest")
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// checkBuild refuses builds that don't match their manifest, which is what
+// an interrupted upload leaves behind, and builds without one if the
+// service requires it. It records the manifest's SHA-256 of each file on
+// build.
+func (d *staticDeployer) checkBuild(ctx context.Context, svc serviceConfig, ec envConfig, tag string, build map[string]s3Object) error {
+	m, ok, err := d.readManifest(ctx, ec, tag)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if svc.Manifest == "required" {
+			return fmt.Errorf("build %s has no manifest at s3://%s/%s; upload it with hoist publish", tag, ec.Bucket, bucketKey(ec, manifestKey(tag)))
+		}
+		return nil
+	}
+	if problems := m.check(build); len(problems) > 0 {
+		return fmt.Errorf("build %s does not match its manifest (%s)", tag, summarizeProblems(problems, 3))
+	}
+	for _, f := range m.Files {
+		obj := build[f.Path]
+		obj.sha256 = f.SHA256
+		build[f.Path] = obj
+	}
+	return nil
+}
+
+// summarizeProblems joins the first n problems and counts the rest.
+func summarizeProblems(problems []string, n int) string {
+	if len(problems) <= n {
+		return strings.Join(problems, "; ")
+	}
+	return fmt.Sprintf("%s; and %d more", strings.Join(problems[:n], "; "), len(problems)-n)
+}
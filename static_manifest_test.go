@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func manifestJSON(t *testing.T, tag string, files ...manifestFile) string {
+	t.Helper()
+	data, err := json.Marshal(buildManifest{Tag: tag, Files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestManifestCheck(t *testing.T) {
+	m := buildManifest{Files: []manifestFile{
+		{Path: "index.html", Size: 10},
+		{Path: "app.js", Size: 20},
+		{Path: "style.css", Size: 30},
+	}}
+	got := m.check(map[string]s3Object{
+		"index.html": {size: 10},
+		"app.js":     {size: 19},
+		"extra.txt":  {size: 1},
+	})
+	want := "app.js: 19 bytes, manifest says 20|extra.txt: not in manifest|style.css: missing"
+	if strings.Join(got, "|") != want {
+		t.Errorf("problems = %q", got)
+	}
+}
+
+func TestStaticDeployIncompleteBuild(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{
+		objects: map[string]string{"builds/" + tag + "/index.html": `"i"`},
+		sizes:   map[string]int64{"builds/" + tag + "/index.html": 10},
+		bodies: map[string]string{
+			manifestKey(tag): manifestJSON(t, tag, manifestFile{Path: "index.html", Size: 10}, manifestFile{Path: "app.js", Size: 20}),
+		},
+	}
+	cf := &stubCFInvalidate{}
+	d := &staticDeployer{cfg: testConfig(), s3: stub, cloudfront: cf}
+
+	err := d.deploy(context.Background(), "frontend", "staging", tag, "main-old1234-20241231000000", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "app.js: missing") {
+		t.Fatalf("err = %v, want incomplete build refused", err)
+	}
+	if len(stub.copyInputs) != 0 || len(stub.putInputs) != 0 || cf.input != nil {
+		t.Error("nothing should be written for an incomplete build")
+	}
+
+	// Once the upload finishes, the build deploys.
+	stub.objects["builds/"+tag+"/app.js"] = `"a"`
+	stub.sizes["builds/"+tag+"/app.js"] = 20
+	if err := d.deploy(context.Background(), "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestStaticDeploySyncBySHA256(t *testing.T) {
+	// Copies of multipart uploads get new ETags; the sha256 metadata tells
+	// whether the content changed.
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{
+		objects: map[string]string{
+			"builds/" + tag + "/index.html": `"i-2"`,
+			"builds/" + tag + "/app.js":     `"a-2"`,
+			"current/index.html":            `"i-copy"`,
+			"current/app.js":                `"a-copy"`,
+		},
+		sizes: map[string]int64{
+			"builds/" + tag + "/index.html": 10,
+			"builds/" + tag + "/app.js":     20,
+			"current/index.html":            10,
+			"current/app.js":                20,
+		},
+		bodies: map[string]string{
+			manifestKey(tag): manifestJSON(t, tag,
+				manifestFile{Path: "index.html", Size: 10, SHA256: "aaa"},
+				manifestFile{Path: "app.js", Size: 20, SHA256: "bbb"},
+			),
+		},
+		meta: map[string]objectMeta{
+			"current/index.html": {Metadata: map[string]string{manifestSHA256Key: "aaa"}},
+			"current/app.js":     {Metadata: map[string]string{manifestSHA256Key: "old"}},
+		},
+	}
+	d := &staticDeployer{cfg: testConfig(), s3: stub, cloudfront: &stubCFInvalidate{}}
+
+	if err := d.deploy(context.Background(), "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stub.copyInputs) != 1 || *stub.copyInputs[0].Key != "current/app.js" {
+		var copied []string
+		for _, c := range stub.copyInputs {
+			copied = append(copied, *c.Key)
+		}
+		t.Errorf("copied %v, want only current/app.js", copied)
+	}
+}
+
+func TestStaticDeployRequiredManifest(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	cfg := testConfig()
+	svc := cfg.Services["frontend"]
+	svc.Manifest = "required"
+	cfg.Services["frontend"] = svc
+	stub := &stubS3Deploy{objects: map[string]string{"builds/" + tag + "/index.html": `"i"`}}
+	d := &staticDeployer{cfg: cfg, s3: stub, cloudfront: &stubCFInvalidate{}}
+
+	err := d.deploy(context.Background(), "frontend", "staging", tag, "", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "no manifest") {
+		t.Fatalf("err = %v, want build without manifest refused", err)
+	}
+}
+
+func TestStaticVerifyManifest(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{
+		objects: map[string]string{
+			"current/index.html": `"i"`,
+			"current/app.js":     `"a"`,
+			"current/old.js":     `"o"`,
+		},
+		sizes: map[string]int64{"current/index.html": 10, "current/app.js": 20, "current/old.js": 5},
+		bodies: map[string]string{
+			"current-tag": tag,
+			manifestKey(tag): manifestJSON(t, tag,
+				manifestFile{Path: "index.html", Size: 10, SHA256: "aaa"},
+				manifestFile{Path: "app.js", Size: 20, SHA256: "bbb"},
+			),
+		},
+		meta: map[string]objectMeta{
+			"current/index.html": {Metadata: map[string]string{manifestSHA256Key: "aaa"}},
+			"current/app.js":     {Metadata: map[string]string{manifestSHA256Key: "ccc"}},
+		},
+	}
+	cfg := testConfig()
+	d := &staticDeployer{cfg: cfg, s3: stub}
+
+	var b strings.Builder
+	err := runVerify(context.Background(), cfg, d, verifyOpts{Services: []string{"frontend"}, Env: "staging"}, &b)
+	if err == nil {
+		t.Fatal("expected verify to fail")
+	}
+	out := b.String()
+	for _, want := range []string{
+		"frontend staging: current/app.js: sha256 differs from manifest",
+		"frontend staging: current/old.js: not in manifest",
+		"against the manifest of " + tag,
+		"2 problems",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "index.html:") {
+		t.Errorf("index.html matches the manifest:\n%s", out)
+	}
+}
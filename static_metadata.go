@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// objectMeta is the part of an S3 object's metadata that metadata rules
+// control. Empty fields are unset.
+type objectMeta struct {
+	CacheControl       string
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	ContentLanguage    string
+	Metadata           map[string]string // x-amz-meta-*, lowercase keys
+}
+
+// metadataFor returns the metadata the rules give key, and whether any rule
+// matched. Rules apply in order, so later rules override earlier ones.
+func metadataFor(rules []metadataRule, key string) (objectMeta, bool) {
+	var m objectMeta
+	matched := false
+	for _, r := range rules {
+		if !globMatch(r.Match, key) {
+			continue
+		}
+		matched = true
+		m = m.overlay(r.meta())
+	}
+	return m, matched
+}
+
+// meta converts a rule to the metadata it sets. Content-Disposition and
+// Content-Language are real S3 headers; other custom headers can only be
+// stored as user metadata.
+func (r metadataRule) meta() objectMeta {
+	m := objectMeta{CacheControl: r.CacheControl, ContentType: r.ContentType, ContentEncoding: r.ContentEncoding}
+	for name, value := range r.Headers {
+		switch strings.ToLower(name) {
+		case "content-disposition":
+			m.ContentDisposition = value
+		case "content-language":
+			m.ContentLanguage = value
+		default:
+			if m.Metadata == nil {
+				m.Metadata = map[string]string{}
+			}
+			m.Metadata[strings.ToLower(name)] = value
+		}
+	}
+	return m
+}
+
+// overlay returns m with the fields set in o replacing its own.
+func (m objectMeta) overlay(o objectMeta) objectMeta {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&m.CacheControl, o.CacheControl)
+	set(&m.ContentType, o.ContentType)
+	set(&m.ContentEncoding, o.ContentEncoding)
+	set(&m.ContentDisposition, o.ContentDisposition)
+	set(&m.ContentLanguage, o.ContentLanguage)
+	if len(o.Metadata) > 0 {
+		merged := make(map[string]string, len(m.Metadata)+len(o.Metadata))
+		for k, v := range m.Metadata {
+			merged[k] = v
+		}
+		for k, v := range o.Metadata {
+			merged[k] = v
+		}
+		m.Metadata = merged
+	}
+	return m
+}
+
+// violations lists how have differs from the fields want sets.
+func (want objectMeta) violations(have objectMeta) []string {
+	var out []string
+	check := func(name, w, h string) {
+		if w != "" && w != h {
+			out = append(out, fmt.Sprintf("%s is %q, want %q", name, h, w))
+		}
+	}
+	check("Cache-Control", want.CacheControl, have.CacheControl)
+	check("Content-Type", want.ContentType, have.ContentType)
+	check("Content-Encoding", want.ContentEncoding, have.ContentEncoding)
+	check("Content-Disposition", want.ContentDisposition, have.ContentDisposition)
+	check("Content-Language", want.ContentLanguage, have.ContentLanguage)
+	keys := make([]string, 0, len(want.Metadata))
+	for k := range want.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		check("x-amz-meta-"+k, want.Metadata[k], have.Metadata[k])
+	}
+	return out
+}
+
+func headMeta(out *s3.HeadObjectOutput) objectMeta {
+	return objectMeta{
+		CacheControl:       aws.ToString(out.CacheControl),
+		ContentType:        aws.ToString(out.ContentType),
+		ContentEncoding:    aws.ToString(out.ContentEncoding),
+		ContentDisposition: aws.ToString(out.ContentDisposition),
+		ContentLanguage:    aws.ToString(out.ContentLanguage),
+		Metadata:           out.Metadata,
+	}
+}
+
+// applyToCopy sets m on a copy with MetadataDirective REPLACE. S3 replaces
+// all metadata then, so m must include whatever should be kept from the
+// source.
+func (m objectMeta) applyToCopy(in *s3.CopyObjectInput) {
+	in.MetadataDirective = s3types.MetadataDirectiveReplace
+	in.CacheControl = optString(m.CacheControl)
+	in.ContentType = optString(m.ContentType)
+	in.ContentEncoding = optString(m.ContentEncoding)
+	in.ContentDisposition = optString(m.ContentDisposition)
+	in.ContentLanguage = optString(m.ContentLanguage)
+	in.Metadata = m.Metadata
+}
+
+// optString returns nil for "", so unset fields are left out of requests.
+func optString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return aws.String(v)
+}
+
+// metadataRulesMarker holds a hash of the rules current/ was last copied
+// with, so changed rules are applied to files whose content didn't change.
+const metadataRulesMarker = "metadata-rules"
+
+// metadataRulesHash identifies a set of rules. No rules hash to "".
+func metadataRulesHash(rules []metadataRule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(rules)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readMarker returns the contents of a marker object, or "" if it doesn't
+// exist.
+func (d *staticDeployer) readMarker(ctx context.Context, bucket, key string) (string, error) {
+	out, err := d.s3.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		if isNoSuchKey(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading %s from s3://%s: %w", key, bucket, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading %s from s3://%s: %w", key, bucket, err)
+	}
+	return string(data), nil
+}
+
+// globMatch matches an object key, relative to the build root, against a
+// rule pattern. * and ? don't match /, ** matches any number of directories
+// and {a,b} matches either alternative. A pattern without a / matches the
+// file name in any directory, so "*.js" covers every JavaScript file.
+func globMatch(pattern, key string) bool {
+	re, err := globRegexp(pattern)
+	return err == nil && re.MatchString(key)
+}
+
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	if !strings.Contains(pattern, "/") {
+		b.WriteString("(?:.*/)?")
+	}
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '{':
+			depth++
+			b.WriteString("(?:")
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("unmatched } in %q", pattern)
+			}
+			depth--
+			b.WriteString(")")
+		case ',':
+			if depth > 0 {
+				b.WriteString("|")
+			} else {
+				b.WriteString(",")
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("unmatched { in %q", pattern)
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"index.html", "index.html", true},
+		{"index.html", "docs/index.html", true},
+		{"/index.html", "docs/index.html", false},
+		{"*.js", "assets/app.1234.js", true},
+		{"*.{js,css}", "app.css", true},
+		{"*.{js,css}", "app.map", false},
+		{"assets/*", "assets/app.js", true},
+		{"assets/*", "assets/img/logo.png", false},
+		{"assets/**", "assets/img/logo.png", true},
+		{"**/*.wasm", "pkg/app.wasm", true},
+		{"**/*.wasm", "app.wasm", true},
+		{"app.?.js", "app.1.js", true},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+	if _, err := globRegexp("*.{js"); err == nil {
+		t.Error("expected error for unmatched {")
+	}
+}
+
+func TestMetadataFor(t *testing.T) {
+	rules := []metadataRule{
+		{Match: "**", CacheControl: "public, max-age=31536000, immutable"},
+		{Match: "*.html", CacheControl: "no-cache", Headers: map[string]string{"Content-Language": "en", "X-Robots-Tag": "noindex"}},
+	}
+
+	m, ok := metadataFor(rules, "docs/index.html")
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if m.CacheControl != "no-cache" {
+		t.Errorf("CacheControl = %q, want the later rule to win", m.CacheControl)
+	}
+	if m.ContentLanguage != "en" || m.Metadata["x-robots-tag"] != "noindex" {
+		t.Errorf("headers not applied: %+v", m)
+	}
+
+	m, _ = metadataFor(rules, "assets/app.js")
+	if m.CacheControl != "public, max-age=31536000, immutable" {
+		t.Errorf("CacheControl = %q", m.CacheControl)
+	}
+	if _, ok := metadataFor(nil, "index.html"); ok {
+		t.Error("expected no match without rules")
+	}
+}
+
+func metadataConfig(rules ...metadataRule) config {
+	cfg := testConfig()
+	svc := cfg.Services["frontend"]
+	svc.Metadata = rules
+	cfg.Services["frontend"] = svc
+	return cfg
+}
+
+func TestStaticDeployMetadataRules(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	rules := []metadataRule{
+		{Match: "index.html", CacheControl: "no-cache"},
+		{Match: "*.wasm", ContentType: "application/wasm"},
+	}
+	stub := &stubS3Deploy{
+		objects: map[string]string{
+			"builds/" + tag + "/index.html": `"i"`,
+			"builds/" + tag + "/app.wasm":   `"w"`,
+			"builds/" + tag + "/app.js":     `"j"`,
+		},
+		meta: map[string]objectMeta{
+			"builds/" + tag + "/index.html": {ContentType: "text/html", CacheControl: "max-age=60"},
+			"builds/" + tag + "/app.wasm":   {ContentType: "binary/octet-stream"},
+		},
+	}
+	d := &staticDeployer{cfg: metadataConfig(rules...), s3: stub, cloudfront: &stubCFInvalidate{}}
+	if err := d.deploy(context.Background(), "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	copies := map[string]int{}
+	for i, c := range stub.copyInputs {
+		copies[*c.Key] = i
+	}
+	index := stub.copyInputs[copies["current/index.html"]]
+	if index.MetadataDirective != s3types.MetadataDirectiveReplace {
+		t.Errorf("index.html MetadataDirective = %q, want REPLACE", index.MetadataDirective)
+	}
+	if aws.ToString(index.CacheControl) != "no-cache" || aws.ToString(index.ContentType) != "text/html" {
+		t.Errorf("index.html metadata = %q, %q; want rule Cache-Control and source Content-Type",
+			aws.ToString(index.CacheControl), aws.ToString(index.ContentType))
+	}
+	if wasm := stub.copyInputs[copies["current/app.wasm"]]; aws.ToString(wasm.ContentType) != "application/wasm" {
+		t.Errorf("app.wasm Content-Type = %q", aws.ToString(wasm.ContentType))
+	}
+	if js := stub.copyInputs[copies["current/app.js"]]; js.MetadataDirective != "" {
+		t.Errorf("app.js should keep the source metadata, got directive %q", js.MetadataDirective)
+	}
+
+	var marker string
+	for _, p := range stub.putInputs {
+		if *p.Key == metadataRulesMarker {
+			b, _ := io.ReadAll(p.Body)
+			marker = string(b)
+		}
+	}
+	if marker != metadataRulesHash(rules) {
+		t.Errorf("metadata-rules marker = %q, want the rules' hash", marker)
+	}
+}
+
+func TestStaticDeployMetadataRulesChanged(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	rules := []metadataRule{{Match: "*.html", CacheControl: "no-cache"}}
+	stub := &stubS3Deploy{
+		objects: map[string]string{
+			"builds/" + tag + "/index.html": `"i"`,
+			"current/index.html":            `"i"`,
+		},
+		bodies: map[string]string{metadataRulesMarker: "old-hash"},
+	}
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+
+	d := &staticDeployer{cfg: metadataConfig(rules...), s3: stub, cloudfront: &stubCFInvalidate{}}
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stub.copyInputs) != 1 {
+		t.Fatalf("expected the unchanged file to be copied again, got %d copies", len(stub.copyInputs))
+	}
+	if summary != "0 added, 0 updated, 0 removed, 1 metadata updated, invalidated 2 paths (I1)" {
+		t.Errorf("summary = %q", summary)
+	}
+
+	// Same rules again: nothing to copy.
+	stub.copyInputs = nil
+	stub.bodies[metadataRulesMarker] = metadataRulesHash(rules)
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stub.copyInputs) != 0 {
+		t.Errorf("expected no copies, got %d", len(stub.copyInputs))
+	}
+}
+
+func TestStaticVerify(t *testing.T) {
+	rules := []metadataRule{
+		{Match: "index.html", CacheControl: "no-cache"},
+		{Match: "assets/**", CacheControl: "public, max-age=31536000, immutable"},
+	}
+	stub := &stubS3Deploy{
+		objects: map[string]string{
+			"current/index.html":    `"i"`,
+			"current/assets/app.js": `"j"`,
+			"current/robots.txt":    `"r"`,
+		},
+		meta: map[string]objectMeta{
+			"current/index.html":    {CacheControl: "no-cache"},
+			"current/assets/app.js": {CacheControl: "max-age=60"},
+		},
+	}
+	cfg := metadataConfig(rules...)
+	d := &staticDeployer{cfg: cfg, s3: stub}
+
+	var b strings.Builder
+	err := runVerify(context.Background(), cfg, d, verifyOpts{Services: []string{"frontend"}, Env: "staging"}, &b)
+	if err == nil || !strings.Contains(err.Error(), "frontend staging") {
+		t.Fatalf("expected verify to fail, got %v", err)
+	}
+	out := b.String()
+	want := `frontend staging: current/assets/app.js: Cache-Control is "max-age=60", want "public, max-age=31536000, immutable"`
+	if !strings.Contains(out, want) {
+		t.Errorf("output missing violation:\n%s", out)
+	}
+	if !strings.Contains(out, "checked 2 of 3 objects") || !strings.Contains(out, "1 problems") {
+		t.Errorf("output missing summary:\n%s", out)
+	}
+}
+
+func TestStaticVerifyOriginPath(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	cfg := originPathConfig()
+	svc := cfg.Services["frontend"]
+	svc.Metadata = []metadataRule{{Match: "index.html", CacheControl: "no-cache"}}
+	cfg.Services["frontend"] = svc
+	stub := &stubS3Deploy{
+		objects: map[string]string{"builds/" + tag + "/index.html": `"i"`},
+		bodies:  map[string]string{"current-tag": tag},
+		meta:    map[string]objectMeta{"builds/" + tag + "/index.html": {CacheControl: "no-cache"}},
+	}
+	d := &staticDeployer{cfg: cfg, s3: stub}
+
+	var b strings.Builder
+	if err := runVerify(context.Background(), cfg, d, verifyOpts{Env: "staging"}, &b); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, b.String())
+	}
+	if !strings.Contains(b.String(), "s3://frontend-staging/builds/"+tag+"/") {
+		t.Errorf("expected the live build to be checked, got:\n%s", b.String())
+	}
+}
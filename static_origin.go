@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+type cfDeployAPI interface {
+	cfInvalidateAPI
+	cfGetDistributionAPI
+	GetDistributionConfig(ctx context.Context, params *cloudfront.GetDistributionConfigInput, optFns ...func(*cloudfront.Options)) (*cloudfront.GetDistributionConfigOutput, error)
+	UpdateDistribution(ctx context.Context, params *cloudfront.UpdateDistributionInput, optFns ...func(*cloudfront.Options)) (*cloudfront.UpdateDistributionOutput, error)
+}
+
+// switchOrigin releases a build without copying it: the distribution's S3
+// origin is pointed at builds/<tag>, so CloudFront switches every file at
+// once. A rollback points it back at the previous tag the same way. With
+// noWait it returns once the update is accepted, without waiting for it to
+// reach every edge location.
+func (d *staticDeployer) switchOrigin(ctx context.Context, svc serviceConfig, ec envConfig, tag string, build map[string]s3Object, noWait bool) (staticRelease, error) {
+	bucket, distID := ec.Bucket, ec.CloudFront
+	buildPrefix := bucketKey(ec, "builds/"+tag+"/")
+
+	// The build is served as is, so metadata rules are applied in place.
+	var keys []string
+	for key := range build {
+		if _, ok := metadataFor(svc.Metadata, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		reportProgress(ctx, "applying metadata to %d files...", len(keys))
+		if err := d.copyObjects(ctx, bucket, buildPrefix, buildPrefix, keys, svc.Metadata); err != nil {
+			return staticRelease{}, err
+		}
+	}
+
+	out, err := d.cloudfront.GetDistributionConfig(ctx, &cloudfront.GetDistributionConfigInput{Id: &distID})
+	if err != nil {
+		return staticRelease{}, fmt.Errorf("getting CloudFront %s config: %w", distID, err)
+	}
+	origin, err := findS3Origin(out.DistributionConfig, bucket, ec.OriginID)
+	if err != nil {
+		return staticRelease{}, fmt.Errorf("CloudFront %s: %w", distID, err)
+	}
+
+	rel := staticRelease{all: true}
+	path := "/" + strings.TrimSuffix(buildPrefix, "/")
+	oldPath := aws.ToString(origin.OriginPath)
+	if oldTag, ok := strings.CutPrefix(oldPath, "/"+bucketKey(ec, "builds/")); ok {
+		prevPrefix := bucketKey(ec, "builds/"+oldTag+"/")
+		prev, err := d.listObjects(ctx, bucket, prevPrefix)
+		if err != nil {
+			return staticRelease{}, fmt.Errorf("listing s3://%s/%s: %w", bucket, prevPrefix, err)
+		}
+		diff := diffObjects(build, prev)
+		rel = staticRelease{changed: append(append(diff.added, diff.updated...), diff.removed...)}
+		rulesHash := metadataRulesHash(svc.Metadata)
+		applied, err := d.readMarker(ctx, bucket, bucketKey(ec, metadataRulesMarker))
+		if err != nil {
+			return staticRelease{}, err
+		}
+		if applied != rulesHash {
+			rel.changed = append(rel.changed, keys...)
+			if err := d.putMarker(ctx, bucket, bucketKey(ec, metadataRulesMarker), rulesHash); err != nil {
+				return staticRelease{}, fmt.Errorf("writing %s marker: %w", metadataRulesMarker, err)
+			}
+		}
+	}
+
+	if oldPath != path {
+		reportProgress(ctx, "pointing origin %s at %s...", aws.ToString(origin.Id), path)
+		origin.OriginPath = &path
+		// IfMatch makes the update fail rather than overwrite a config
+		// someone else changed since we read it.
+		_, err := d.cloudfront.UpdateDistribution(ctx, &cloudfront.UpdateDistributionInput{
+			Id:                 &distID,
+			IfMatch:            out.ETag,
+			DistributionConfig: out.DistributionConfig,
+		})
+		if err != nil {
+			return staticRelease{}, fmt.Errorf("updating CloudFront %s origin path: %w", distID, err)
+		}
+	}
+
+	// The distribution now converges on the new tag even if waiting fails,
+	// so record it as current before waiting.
+	if err := d.putMarker(ctx, bucket, bucketKey(ec, "current-tag"), tag); err != nil {
+		return staticRelease{}, fmt.Errorf("writing current-tag marker: %w", err)
+	}
+	rel.summary = "origin path " + path
+	if noWait {
+		rel.summary += " (not waiting)"
+		return rel, nil
+	}
+	if err := d.waitDeployed(ctx, distID); err != nil {
+		return staticRelease{}, err
+	}
+	return rel, nil
+}
+
+// waitDeployed polls the distribution until CloudFront reports the latest
+// config deployed to all edge locations.
+func (d *staticDeployer) waitDeployed(ctx context.Context, distID string) error {
+	interval := d.pollInterval
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+	timeout := d.pollTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Minute
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		out, err := d.cloudfront.GetDistribution(ctx, &cloudfront.GetDistributionInput{Id: &distID})
+		if err != nil {
+			return fmt.Errorf("getting CloudFront %s status: %w", distID, err)
+		}
+		status := "unknown"
+		if out.Distribution != nil && out.Distribution.Status != nil {
+			status = *out.Distribution.Status
+		}
+		if status == "Deployed" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("CloudFront %s still %s after %s", distID, status, timeout)
+		}
+		reportProgress(ctx, "waiting for CloudFront (%s, %s)...", status, time.Since(start).Round(time.Second))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// findS3Origin returns the distribution's origin for bucket: the one with
+// the given ID, or else the only origin whose domain is the bucket's.
+func findS3Origin(dc *cftypes.DistributionConfig, bucket, id string) (*cftypes.Origin, error) {
+	if dc == nil || dc.Origins == nil {
+		return nil, fmt.Errorf("distribution has no origins")
+	}
+	var found *cftypes.Origin
+	for i := range dc.Origins.Items {
+		o := &dc.Origins.Items[i]
+		if id != "" {
+			if aws.ToString(o.Id) == id {
+				return o, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(aws.ToString(o.DomainName), bucket+".s3") {
+			if found != nil {
+				return nil, fmt.Errorf("several origins use bucket %s; set origin_id", bucket)
+			}
+			found = o
+		}
+	}
+	if id != "" {
+		return nil, fmt.Errorf("no origin with id %q", id)
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no origin uses bucket %s; set origin_id", bucket)
+	}
+	return found, nil
+}
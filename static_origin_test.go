@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+func originPathConfig() config {
+	cfg := testConfig()
+	svc := cfg.Services["frontend"]
+	ec := svc.Env["staging"]
+	ec.Strategy = "origin_path"
+	svc.Env["staging"] = ec
+	cfg.Services["frontend"] = svc
+	return cfg
+}
+
+func distributionConfig(origins ...cftypes.Origin) *cftypes.DistributionConfig {
+	return &cftypes.DistributionConfig{
+		Origins: &cftypes.Origins{Items: origins, Quantity: aws.Int32(int32(len(origins)))},
+	}
+}
+
+func TestStaticDeployOriginPath(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{"builds/" + tag + "/index.html": `"i"`}}
+	cf := &stubCFInvalidate{
+		config: distributionConfig(
+			cftypes.Origin{Id: aws.String("api"), DomainName: aws.String("api.example.com")},
+			cftypes.Origin{Id: aws.String("site"), DomainName: aws.String("frontend-staging.s3.us-east-1.amazonaws.com"), OriginPath: aws.String("/builds/main-old1234-20241231000000")},
+		),
+		statuses: []string{"InProgress", "InProgress"},
+	}
+	d := &staticDeployer{cfg: originPathConfig(), s3: stub, cloudfront: cf, pollInterval: time.Millisecond}
+
+	if err := d.deploy(context.Background(), "frontend", "staging", tag, "main-old1234-20241231000000", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(stub.copyInputs) != 0 {
+		t.Errorf("expected no copies, got %d", len(stub.copyInputs))
+	}
+	if len(cf.updates) != 1 {
+		t.Fatalf("expected 1 UpdateDistribution call, got %d", len(cf.updates))
+	}
+	u := cf.updates[0]
+	if aws.ToString(u.IfMatch) != "E1" {
+		t.Errorf("IfMatch = %q, want the config's ETag", aws.ToString(u.IfMatch))
+	}
+	if got := aws.ToString(u.DistributionConfig.Origins.Items[1].OriginPath); got != "/builds/"+tag {
+		t.Errorf("origin path = %q, want /builds/%s", got, tag)
+	}
+	if u.DistributionConfig.Origins.Items[0].OriginPath != nil {
+		t.Error("other origins should be left alone")
+	}
+	if len(cf.statuses) != 0 {
+		t.Error("expected to wait until the distribution is deployed")
+	}
+
+	var keys []string
+	for _, p := range stub.putInputs {
+		keys = append(keys, *p.Key)
+	}
+	if strings.Join(keys, " ") != "current-tag previous-tag last-invalidation" {
+		t.Errorf("markers = %v, want current-tag, previous-tag and last-invalidation", keys)
+	}
+	if cf.input == nil {
+		t.Fatal("expected CloudFront invalidation")
+	}
+	// Only the files that differ from the previous build are invalidated.
+	if got := strings.Join(cf.input.InvalidationBatch.Paths.Items, " "); got != "/ /index.html" {
+		t.Errorf("invalidation paths = %v, want [/ /index.html]", cf.input.InvalidationBatch.Paths.Items)
+	}
+}
+
+func TestStaticDeployOriginPathAlreadyCurrent(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{"builds/" + tag + "/index.html": `"i"`}}
+	cf := &stubCFInvalidate{config: distributionConfig(
+		cftypes.Origin{Id: aws.String("site"), DomainName: aws.String("frontend-staging.s3.amazonaws.com"), OriginPath: aws.String("/builds/" + tag)},
+	)}
+	d := &staticDeployer{cfg: originPathConfig(), s3: stub, cloudfront: cf}
+
+	if err := d.deploy(context.Background(), "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cf.updates) != 0 {
+		t.Errorf("expected no update when the origin already points at the tag, got %d", len(cf.updates))
+	}
+}
+
+func TestStaticDeployOriginPathTimeout(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{"builds/" + tag + "/index.html": `"i"`}}
+	cf := &stubCFInvalidate{
+		config:   distributionConfig(cftypes.Origin{Id: aws.String("site"), DomainName: aws.String("frontend-staging.s3.amazonaws.com")}),
+		statuses: []string{"InProgress", "InProgress", "InProgress", "InProgress"},
+	}
+	d := &staticDeployer{cfg: originPathConfig(), s3: stub, cloudfront: cf, pollInterval: time.Millisecond, pollTimeout: time.Millisecond}
+
+	err := d.deploy(context.Background(), "frontend", "staging", tag, "main-old1234-20241231000000", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "still InProgress") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if cf.input != nil {
+		t.Error("expected no invalidation before the distribution is deployed")
+	}
+	for _, p := range stub.putInputs {
+		if *p.Key == "previous-tag" {
+			t.Error("previous-tag should only be written once the switch succeeds")
+		}
+	}
+}
+
+func TestStaticDeployOriginPathNoWait(t *testing.T) {
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{"builds/" + tag + "/index.html": `"i"`}}
+	cf := &stubCFInvalidate{
+		config:               distributionConfig(cftypes.Origin{Id: aws.String("site"), DomainName: aws.String("frontend-staging.s3.amazonaws.com")}),
+		statuses:             []string{"InProgress"},
+		invalidationStatuses: []string{"InProgress"},
+	}
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+	d := &staticDeployer{cfg: originPathConfig(), s3: stub, cloudfront: cf, pollInterval: time.Millisecond, pollTimeout: time.Millisecond}
+
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{noWait: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cf.statuses) != 1 {
+		t.Error("expected no wait for the distribution to deploy with --no-wait")
+	}
+	if !strings.HasPrefix(summary, "origin path /builds/"+tag+" (not waiting), ") {
+		t.Errorf("summary = %q", summary)
+	}
+}
+
+func TestFindS3Origin(t *testing.T) {
+	dc := distributionConfig(
+		cftypes.Origin{Id: aws.String("a"), DomainName: aws.String("site.s3.amazonaws.com")},
+		cftypes.Origin{Id: aws.String("b"), DomainName: aws.String("site.s3.eu-west-1.amazonaws.com")},
+	)
+	if _, err := findS3Origin(dc, "site", ""); err == nil || !strings.Contains(err.Error(), "set origin_id") {
+		t.Errorf("expected ambiguity error, got %v", err)
+	}
+	o, err := findS3Origin(dc, "site", "b")
+	if err != nil || aws.ToString(o.Id) != "b" {
+		t.Errorf("findS3Origin by id = %v, %v", o, err)
+	}
+	if _, err := findS3Origin(dc, "other", ""); err == nil {
+		t.Error("expected error for a bucket without an origin")
+	}
+}
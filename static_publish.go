@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type s3PublishAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// defaultPartSize is the part size of multipart uploads. Files up to this
+// size are uploaded with a single PutObject.
+const defaultPartSize = 16 << 20
+
+type staticPublisher struct {
+	s3       s3PublishAPI
+	partSize int64            // 0 means use default (16 MiB)
+	now      func() time.Time // nil means time.Now
+}
+
+// buildManifest lists every file of a published build. It is uploaded
+// after the files, to manifestKey(tag).
+type buildManifest struct {
+	Tag       string         `json:"tag"`
+	Git       gitInfo        `json:"git"`
+	Published time.Time      `json:"published"`
+	Files     []manifestFile `json:"files"`
+}
+
+type gitInfo struct {
+	Branch  string `json:"branch"`
+	SHA     string `json:"sha"`
+	Author  string `json:"author,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type manifestFile struct {
+	Path   string `json:"path"` // relative to the build root, with / separators
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifestKey is where a build's manifest lives. It is kept outside
+// builds/<tag>/ so it isn't deployed along with the site.
+func manifestKey(tag string) string {
+	return "manifests/" + tag + ".json"
+}
+
+// scanBuildDir lists the files under dir with their sizes and hashes.
+// Symlinks are followed; anything else that isn't a regular file is an
+// error.
+func scanBuildDir(dir string) ([]manifestFile, error) {
+	var files []manifestFile
+	err := filepath.WalkDir(dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			return nil
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s: not a regular file", p)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		sum, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, manifestFile{Path: filepath.ToSlash(rel), Size: info.Size(), SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// webContentTypes covers extensions the mime package may not know, or
+// knows differently depending on the system's mime.types.
+var webContentTypes = map[string]string{
+	".html":        "text/html; charset=utf-8",
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".txt":         "text/plain; charset=utf-8",
+	".xml":         "application/xml",
+	".svg":         "image/svg+xml",
+	".ico":         "image/x-icon",
+	".png":         "image/png",
+	".jpg":         "image/jpeg",
+	".jpeg":        "image/jpeg",
+	".gif":         "image/gif",
+	".webp":        "image/webp",
+	".avif":        "image/avif",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".wasm":        "application/wasm",
+	".pdf":         "application/pdf",
+}
+
+// detectContentType picks a file's Content-Type from its extension, and
+// failing that from its first bytes.
+func detectContentType(name string, head []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ct, ok := webContentTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(head)
+}
+
+// gitMetadata is the git info attached to published objects as user
+// metadata. Values are Q-encoded, since S3 only takes ASCII header values,
+// and the message is cut to its first line.
+func gitMetadata(g gitInfo) map[string]string {
+	msg, _, _ := strings.Cut(g.Message, "\n")
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+	m := map[string]string{}
+	for k, v := range map[string]string{"git-branch": g.Branch, "git-sha": g.SHA, "git-author": g.Author, "git-message": msg} {
+		if v != "" {
+			m[k] = mime.QEncoding.Encode("utf-8", v)
+		}
+	}
+	return m
+}
+
+// gitFromMetadata reverses gitMetadata.
+func gitFromMetadata(m map[string]string) gitInfo {
+	dec := new(mime.WordDecoder)
+	get := func(k string) string {
+		v, err := dec.DecodeHeader(m[k])
+		if err != nil {
+			return m[k]
+		}
+		return v
+	}
+	return gitInfo{Branch: get("git-branch"), SHA: get("git-sha"), Author: get("git-author"), Message: get("git-message")}
+}
+
+// publish uploads the files of dir to builds/<tag>/ under keyPrefix in
+// bucket, then the manifest. Metadata rules are applied on upload, on top
+// of the detected Content-Type.
+func (p *staticPublisher) publish(ctx context.Context, bucket, keyPrefix, dir string, files []manifestFile, m buildManifest, rules []metadataRule) error {
+	const maxWorkers = 20
+
+	prefix := keyPrefix + "builds/" + m.Tag + "/"
+	sem := make(chan struct{}, maxWorkers)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, f := range files {
+		wg.Add(1)
+		go func(f manifestFile) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			err := p.upload(ctx, bucket, prefix+f.Path, filepath.Join(dir, filepath.FromSlash(f.Path)), f, m.Git, rules)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("uploading %s to s3://%s/%s%s: %w", f.Path, bucket, prefix, f.Path, err)
+				}
+				mu.Unlock()
+			}
+		}(f)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// The manifest goes last: a build without one was never fully
+	// uploaded.
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = p.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         aws.String(keyPrefix + manifestKey(m.Tag)),
+		Body:        strings.NewReader(string(data)),
+		ContentType: aws.String("application/json"),
+		Metadata:    gitMetadata(m.Git),
+	})
+	if err != nil {
+		return fmt.Errorf("writing manifest to s3://%s/%s%s: %w", bucket, keyPrefix, manifestKey(m.Tag), err)
+	}
+	return nil
+}
+
+func (p *staticPublisher) upload(ctx context.Context, bucket, key, file string, f manifestFile, git gitInfo, rules []metadataRule) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(fh, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	userMeta := gitMetadata(git)
+	userMeta[manifestSHA256Key] = f.SHA256
+	meta := objectMeta{ContentType: detectContentType(f.Path, head[:n]), Metadata: userMeta}
+	if want, ok := metadataFor(rules, f.Path); ok {
+		meta = meta.overlay(want)
+	}
+
+	partSize := p.partSize
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+	if f.Size > partSize {
+		return p.uploadParts(ctx, bucket, key, fh, f.Size, partSize, meta)
+	}
+
+	in := &s3.PutObjectInput{
+		Bucket:        &bucket,
+		Key:           &key,
+		Body:          io.NewSectionReader(fh, 0, f.Size),
+		ContentLength: aws.Int64(f.Size),
+	}
+	meta.applyToPut(in)
+	_, err = p.s3.PutObject(ctx, in)
+	return err
+}
+
+// uploadParts uploads a large file with a multipart upload, aborting it on
+// failure so S3 doesn't keep (and bill for) the parts.
+func (p *staticPublisher) uploadParts(ctx context.Context, bucket, key string, r io.ReaderAt, size, partSize int64, meta objectMeta) error {
+	in := &s3.CreateMultipartUploadInput{Bucket: &bucket, Key: &key}
+	meta.applyToMultipart(in)
+	out, err := p.s3.CreateMultipartUpload(ctx, in)
+	if err != nil {
+		return err
+	}
+	uploadID := out.UploadId
+
+	var parts []s3types.CompletedPart
+	for off, num := int64(0), int32(1); off < size; off, num = off+partSize, num+1 {
+		n := min(partSize, size-off)
+		part, err := p.s3.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        &bucket,
+			Key:           &key,
+			UploadId:      uploadID,
+			PartNumber:    aws.Int32(num),
+			Body:          io.NewSectionReader(r, off, n),
+			ContentLength: aws.Int64(n),
+		})
+		if err != nil {
+			p.abort(ctx, bucket, key, uploadID)
+			return fmt.Errorf("uploading part %d: %w", num, err)
+		}
+		parts = append(parts, s3types.CompletedPart{ETag: part.ETag, PartNumber: aws.Int32(num)})
+	}
+
+	_, err = p.s3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &bucket,
+		Key:             &key,
+		UploadId:        uploadID,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		p.abort(ctx, bucket, key, uploadID)
+		return fmt.Errorf("completing multipart upload: %w", err)
+	}
+	return nil
+}
+
+func (p *staticPublisher) abort(ctx context.Context, bucket, key string, uploadID *string) {
+	// Abort even if ctx was cancelled; the error is already being
+	// reported.
+	p.s3.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{Bucket: &bucket, Key: &key, UploadId: uploadID})
+}
+
+func (m objectMeta) applyToPut(in *s3.PutObjectInput) {
+	in.CacheControl = optString(m.CacheControl)
+	in.ContentType = optString(m.ContentType)
+	in.ContentEncoding = optString(m.ContentEncoding)
+	in.ContentDisposition = optString(m.ContentDisposition)
+	in.ContentLanguage = optString(m.ContentLanguage)
+	in.Metadata = m.Metadata
+}
+
+func (m objectMeta) applyToMultipart(in *s3.CreateMultipartUploadInput) {
+	in.CacheControl = optString(m.CacheControl)
+	in.ContentType = optString(m.ContentType)
+	in.ContentEncoding = optString(m.ContentEncoding)
+	in.ContentDisposition = optString(m.ContentDisposition)
+	in.ContentLanguage = optString(m.ContentLanguage)
+	in.Metadata = m.Metadata
+}
+
+type publishOpts struct {
+	Service string
+	Env     string // empty means every env's bucket
+	Dir     string
+	Tag     string
+	Git     gitInfo
+}
+
+// runPublish uploads a build directory to the buckets of a static
+// service's envs. Envs sharing a bucket and prefix get one upload.
+func runPublish(ctx context.Context, cfg config, p *staticPublisher, opts publishOpts, w io.Writer) error {
+	svc, ok := cfg.Services[opts.Service]
+	if !ok {
+		return fmt.Errorf("unknown service: %q", opts.Service)
+	}
+	if svc.Type != "static" {
+		return fmt.Errorf("service %q: publish only supports static services", opts.Service)
+	}
+	type target struct{ bucket, prefix string }
+	var targets []target
+	seen := map[target]bool{}
+	for env, ec := range svc.Env {
+		if opts.Env != "" && env != opts.Env {
+			continue
+		}
+		t := target{ec.Bucket, bucketPrefix(ec)}
+		if !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("service %q has no env %q", opts.Service, opts.Env)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].bucket != targets[j].bucket {
+			return targets[i].bucket < targets[j].bucket
+		}
+		return targets[i].prefix < targets[j].prefix
+	})
+
+	files, err := scanBuildDir(opts.Dir)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", opts.Dir, err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%s has no files to publish", opts.Dir)
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+
+	now := time.Now
+	if p.now != nil {
+		now = p.now
+	}
+	m := buildManifest{Tag: opts.Tag, Git: opts.Git, Published: now().UTC(), Files: files}
+	for _, t := range targets {
+		fmt.Fprintf(w, "Uploading %d files (%s) to s3://%s/%sbuilds/%s/...\n", len(files), formatBytes(total), t.bucket, t.prefix, opts.Tag)
+		if err := p.publish(ctx, t.bucket, t.prefix, opts.Dir, files, m, svc.Metadata); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "Published %s\n", opts.Tag)
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type stubS3Publish struct {
+	mu        sync.Mutex
+	puts      map[string]s3.PutObjectInput
+	bodies    map[string]string
+	order     []string
+	multipart map[string]*s3.CreateMultipartUploadInput
+	parts     map[string][]string
+	completed []string
+	aborted   []string
+	partErr   error
+}
+
+func (s *stubS3Publish) PutObject(_ context.Context, params *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body, _ := io.ReadAll(params.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.puts == nil {
+		s.puts = map[string]s3.PutObjectInput{}
+		s.bodies = map[string]string{}
+	}
+	s.puts[*params.Key] = *params
+	s.bodies[*params.Key] = string(body)
+	s.order = append(s.order, *params.Key)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (s *stubS3Publish) CreateMultipartUpload(_ context.Context, params *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.multipart == nil {
+		s.multipart = map[string]*s3.CreateMultipartUploadInput{}
+		s.parts = map[string][]string{}
+	}
+	s.multipart[*params.Key] = params
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("U1")}, nil
+}
+
+func (s *stubS3Publish) UploadPart(_ context.Context, params *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if s.partErr != nil {
+		return nil, s.partErr
+	}
+	body, _ := io.ReadAll(params.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.parts[*params.Key] = append(s.parts[*params.Key], string(body))
+	return &s3.UploadPartOutput{ETag: aws.String("etag")}, nil
+}
+
+func (s *stubS3Publish) CompleteMultipartUpload(_ context.Context, params *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(params.MultipartUpload.Parts) != len(s.parts[*params.Key]) {
+		return nil, errors.New("part count mismatch")
+	}
+	s.completed = append(s.completed, *params.Key)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (s *stubS3Publish) AbortMultipartUpload(_ context.Context, params *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aborted = append(s.aborted, *params.Key)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func writeBuildDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRunPublish(t *testing.T) {
+	dir := writeBuildDir(t, map[string]string{
+		"index.html":        "<html></html>",
+		"assets/app.js":     "console.log(1)",
+		"assets/font.woff2": "wOF2",
+		"data":              "\x89PNG\r\n\x1a\n",
+	})
+	cfg := metadataConfig(metadataRule{Match: "assets/**", CacheControl: "immutable"})
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Publish{}
+	p := &staticPublisher{s3: stub, now: func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }}
+
+	var out strings.Builder
+	err := runPublish(context.Background(), cfg, p, publishOpts{
+		Service: "frontend",
+		Dir:     dir,
+		Tag:     tag,
+		Git:     gitInfo{Branch: "main", SHA: "abc1234", Author: "Zoë", Message: "Fix the header\n\nLonger text"},
+	}, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	prefix := "builds/" + tag + "/"
+	types := map[string]string{
+		"index.html":        "text/html; charset=utf-8",
+		"assets/app.js":     "text/javascript; charset=utf-8",
+		"assets/font.woff2": "font/woff2",
+		"data":              "image/png",
+	}
+	for name, want := range types {
+		put, ok := stub.puts[prefix+name]
+		if !ok {
+			t.Errorf("%s not uploaded", name)
+			continue
+		}
+		if got := aws.ToString(put.ContentType); got != want {
+			t.Errorf("%s Content-Type = %q, want %q", name, got, want)
+		}
+		if got := gitFromMetadata(put.Metadata); got.Author != "Zoë" || got.Message != "Fix the header" {
+			t.Errorf("%s git metadata = %+v", name, got)
+		}
+	}
+	if got := aws.ToString(stub.puts[prefix+"assets/app.js"].CacheControl); got != "immutable" {
+		t.Errorf("assets/app.js Cache-Control = %q, want the metadata rule's", got)
+	}
+	if stub.puts[prefix+"index.html"].CacheControl != nil {
+		t.Error("index.html should not match the metadata rule")
+	}
+
+	if last := stub.order[len(stub.order)-1]; last != manifestKey(tag) {
+		t.Errorf("last upload = %s, want the manifest", last)
+	}
+	var m buildManifest
+	if err := json.Unmarshal([]byte(stub.bodies[manifestKey(tag)]), &m); err != nil {
+		t.Fatalf("decoding manifest: %v", err)
+	}
+	var paths []string
+	for _, f := range m.Files {
+		paths = append(paths, f.Path)
+	}
+	if strings.Join(paths, " ") != "assets/app.js assets/font.woff2 data index.html" {
+		t.Errorf("manifest files = %v", paths)
+	}
+	sum := sha256.Sum256([]byte("<html></html>"))
+	if f := m.Files[3]; f.Size != 13 || f.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("index.html entry = %+v", f)
+	}
+	if m.Tag != tag || m.Git.SHA != "abc1234" {
+		t.Errorf("manifest = %+v", m)
+	}
+	if !strings.Contains(out.String(), "Published "+tag) {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestPublishMultipart(t *testing.T) {
+	dir := writeBuildDir(t, map[string]string{"big.bin": "0123456789abcdefghijklmnopqrstuvwxyz"})
+	files, err := scanBuildDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &stubS3Publish{}
+	p := &staticPublisher{s3: stub, partSize: 10}
+	m := buildManifest{Tag: "main-abc1234-20250101000000", Files: files}
+	if err := p.publish(context.Background(), "bucket", "", dir, files, m, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	key := "builds/main-abc1234-20250101000000/big.bin"
+	if _, ok := stub.puts[key]; ok {
+		t.Error("large file should not be uploaded with PutObject")
+	}
+	if got := stub.parts[key]; strings.Join(got, "|") != "0123456789|abcdefghij|klmnopqrst|uvwxyz" {
+		t.Errorf("parts = %q", got)
+	}
+	if len(stub.completed) != 1 {
+		t.Errorf("completed = %v, want the upload completed", stub.completed)
+	}
+	if ct := aws.ToString(stub.multipart[key].ContentType); ct != "application/octet-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestPublishMultipartAbort(t *testing.T) {
+	dir := writeBuildDir(t, map[string]string{"big.bin": strings.Repeat("x", 30)})
+	files, _ := scanBuildDir(dir)
+	stub := &stubS3Publish{partErr: errors.New("connection reset")}
+	p := &staticPublisher{s3: stub, partSize: 10}
+	m := buildManifest{Tag: "main-abc1234-20250101000000", Files: files}
+	if err := p.publish(context.Background(), "bucket", "", dir, files, m, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(stub.aborted) != 1 {
+		t.Errorf("aborted = %v, want the upload aborted", stub.aborted)
+	}
+	if _, ok := stub.puts[manifestKey(m.Tag)]; ok {
+		t.Error("manifest should not be written for a failed upload")
+	}
+}
+
+func TestRunPublishBuckets(t *testing.T) {
+	dir := writeBuildDir(t, map[string]string{"index.html": "hi"})
+	cfg := testConfig()
+	svc := cfg.Services["frontend"]
+	svc.Env["production"] = envConfig{Bucket: "frontend-production", CloudFront: "E2"}
+	cfg.Services["frontend"] = svc
+
+	var out strings.Builder
+	p := &staticPublisher{s3: &stubS3Publish{}}
+	if err := runPublish(context.Background(), cfg, p, publishOpts{Service: "frontend", Dir: dir, Tag: "main-abc1234-20250101000000"}, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buckets []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if _, rest, ok := strings.Cut(line, "s3://"); ok {
+			b, _, _ := strings.Cut(rest, "/")
+			buckets = append(buckets, b)
+		}
+	}
+	sort.Strings(buckets)
+	if strings.Join(buckets, " ") != "frontend-production frontend-staging" {
+		t.Errorf("buckets = %v, want every env's bucket", buckets)
+	}
+
+	err := runPublish(context.Background(), cfg, p, publishOpts{Service: "frontend", Env: "qa", Dir: dir, Tag: "t"}, &out)
+	if err == nil {
+		t.Error("expected error for unknown env")
+	}
+	err = runPublish(context.Background(), cfg, p, publishOpts{Service: "frontend", Dir: t.TempDir(), Tag: "t"}, &out)
+	if err == nil {
+		t.Error("expected error for an empty build dir")
+	}
+}
+
+func TestGitMetadataRoundTrip(t *testing.T) {
+	g := gitInfo{Branch: "feat/ü", SHA: "abc1234", Author: "Zoë Brontë", Message: "Add ✓ marks\nbody"}
+	m := gitMetadata(g)
+	for k, v := range m {
+		for _, r := range v {
+			if r > 127 {
+				t.Errorf("%s = %q is not ASCII", k, v)
+				break
+			}
+		}
+	}
+	got := gitFromMetadata(m)
+	g.Message = "Add ✓ marks"
+	if got != g {
+		t.Errorf("round trip = %+v, want %+v", got, g)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// purge removes what a release changed from the env's CDN cache and
+// returns a summary of what it did. With noWait it doesn't wait for
+// CloudFront to finish.
+func (d *staticDeployer) purge(ctx context.Context, service, env, tag string, rel staticRelease, noWait bool) (string, error) {
+	ec := d.cfg.Services[service].Env[env]
+	switch purgeType(ec) {
+	case "none":
+		return "CDN not purged", nil
+	case "webhook":
+		return d.purgeWebhook(ctx, service, env, tag, ec.Purge, rel)
+	default:
+		return d.invalidateCloudFront(ctx, ec, tag, rel, noWait)
+	}
+}
+
+// purgeRequest is the JSON body a purge webhook receives. Paths are URL
+// paths like CloudFront's, e.g. "/assets/app.js"; when All is set the whole
+// site changed and Paths is empty.
+type purgeRequest struct {
+	Service string   `json:"service"`
+	Env     string   `json:"env"`
+	Tag     string   `json:"tag"`
+	All     bool     `json:"all"`
+	Paths   []string `json:"paths"`
+}
+
+func (d *staticDeployer) purgeWebhook(ctx context.Context, service, env, tag string, pc purgeConfig, rel staticRelease) (string, error) {
+	req := purgeRequest{Service: service, Env: env, Tag: tag, All: rel.all, Paths: []string{}}
+	if !rel.all {
+		req.Paths = invalidationPaths(rel.changed)
+		if len(req.Paths) == 0 {
+			return "nothing to purge", nil
+		}
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, pc.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("purge webhook: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for name, value := range pc.Headers {
+		httpReq.Header.Set(name, os.ExpandEnv(value))
+	}
+
+	client := d.http
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	reportProgress(ctx, "purging CDN cache...")
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("purge webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("purge webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if rel.all {
+		return "purged everything", nil
+	}
+	return "purged " + pluralize(len(req.Paths), "path"), nil
+}
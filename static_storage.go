@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newStorageClients returns S3 clients for the static services with a
+// storage config. Other services use the default client.
+func newStorageClients(ctx context.Context, cfg config, awsCfg aws.Config) (map[string]*s3.Client, error) {
+	clients := map[string]*s3.Client{}
+	for _, name := range sortedServiceNames(cfg) {
+		sc := cfg.Services[name].Storage
+		if cfg.Services[name].Type != "static" || sc == (storageConfig{}) {
+			continue
+		}
+		base := awsCfg
+		if sc.Profile != "" {
+			var err error
+			base, err = awsconfig.LoadDefaultConfig(ctx, awsconfig.WithSharedConfigProfile(sc.Profile))
+			if err != nil {
+				return nil, fmt.Errorf("service %q: loading AWS profile %q: %w", name, sc.Profile, err)
+			}
+		}
+		clients[name] = s3ClientFor(base, sc)
+	}
+	return clients, nil
+}
+
+// s3ClientFor returns an S3 client for sc. Stores other than AWS don't all
+// support the checksums the SDK adds by default, so custom endpoints only
+// get them where the API requires them.
+func s3ClientFor(awsCfg aws.Config, sc storageConfig) *s3.Client {
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if sc.Region != "" {
+			o.Region = sc.Region
+		}
+		if sc.Endpoint != "" {
+			o.BaseEndpoint = aws.String(sc.Endpoint)
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+			if o.Region == "" {
+				o.Region = "us-east-1"
+			}
+		}
+		o.UsePathStyle = sc.PathStyle
+	})
+}
+
+// storageAs converts storage clients to the API a provider uses.
+func storageAs[T any](clients map[string]*s3.Client) map[string]T {
+	m := make(map[string]T, len(clients))
+	for name, c := range clients {
+		m[name] = any(c).(T)
+	}
+	return m
+}
+
+// forService returns d using the service's storage client, if it has one.
+func (d *staticDeployer) forService(service string) *staticDeployer {
+	c, ok := d.storage[service]
+	if !ok {
+		return d
+	}
+	cp := *d
+	cp.s3 = c
+	return &cp
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// fakeS3 is a minimal path-style S3 stand-in: enough of ListObjectsV2,
+// GetObject, HeadObject, PutObject, CopyObject and DeleteObjects for the
+// static deploy path to run against a real SDK client.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject // "bucket/key"
+}
+
+type fakeObject struct {
+	body   string
+	header http.Header // Content-*, Cache-Control and x-amz-meta-*
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{objects: map[string]fakeObject{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func storedHeaders(h http.Header) http.Header {
+	out := http.Header{}
+	for name, values := range h {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "content-type") || lower == "cache-control" || lower == "content-encoding" ||
+			lower == "content-disposition" || lower == "content-language" || strings.HasPrefix(lower, "x-amz-meta-") {
+			out[name] = values
+		}
+	}
+	return out
+}
+
+func etag(body string) string {
+	sum := md5.Sum([]byte(body))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && key == "" && q.Get("list-type") == "2":
+		type content struct {
+			Key  string
+			ETag string
+			Size int
+		}
+		type commonPrefix struct{ Prefix string }
+		var res struct {
+			XMLName        xml.Name `xml:"ListBucketResult"`
+			Name           string
+			Prefix         string
+			KeyCount       int
+			IsTruncated    bool
+			Contents       []content
+			CommonPrefixes []commonPrefix
+		}
+		res.Name, res.Prefix = bucket, q.Get("prefix")
+		delimiter := q.Get("delimiter")
+		seen := map[string]bool{}
+		for full, obj := range f.objects {
+			k, ok := strings.CutPrefix(full, bucket+"/")
+			if !ok || !strings.HasPrefix(k, res.Prefix) {
+				continue
+			}
+			if i := strings.Index(k[len(res.Prefix):], delimiter); delimiter != "" && i >= 0 {
+				if cp := k[:len(res.Prefix)+i+len(delimiter)]; !seen[cp] {
+					seen[cp] = true
+					res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{cp})
+				}
+				continue
+			}
+			res.Contents = append(res.Contents, content{Key: k, ETag: etag(obj.body), Size: len(obj.body)})
+		}
+		sort.Slice(res.Contents, func(i, j int) bool { return res.Contents[i].Key < res.Contents[j].Key })
+		sort.Slice(res.CommonPrefixes, func(i, j int) bool { return res.CommonPrefixes[i].Prefix < res.CommonPrefixes[j].Prefix })
+		res.KeyCount = len(res.Contents) + len(res.CommonPrefixes)
+		xml.NewEncoder(w).Encode(res)
+
+	case r.Method == http.MethodPost && key == "" && q.Has("delete"):
+		var req struct {
+			Object []struct{ Key string }
+		}
+		xml.NewDecoder(r.Body).Decode(&req)
+		var res struct {
+			XMLName xml.Name `xml:"DeleteResult"`
+			Deleted []struct{ Key string }
+		}
+		for _, o := range req.Object {
+			delete(f.objects, bucket+"/"+o.Key)
+			res.Deleted = append(res.Deleted, struct{ Key string }{o.Key})
+		}
+		xml.NewEncoder(w).Encode(res)
+
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		obj, ok := f.objects[strings.TrimPrefix(src, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			obj.header = storedHeaders(r.Header)
+		}
+		f.objects[bucket+"/"+key] = obj
+		fmt.Fprintf(w, "<CopyObjectResult><ETag>%s</ETag></CopyObjectResult>", etag(obj.body))
+
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[bucket+"/"+key] = fakeObject{body: string(body), header: storedHeaders(r.Header)}
+		w.Header().Set("ETag", etag(string(body)))
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[bucket+"/"+key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			}
+			return
+		}
+		for name, values := range obj.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", etag(obj.body))
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.body)))
+		if r.Method == http.MethodGet {
+			io.WriteString(w, obj.body)
+		}
+
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) keys(bucket, prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for full := range f.objects {
+		if k, ok := strings.CutPrefix(full, bucket+"/"+prefix); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func storageTestConfig(endpoint string) config {
+	cfg := testConfig()
+	svc := cfg.Services["frontend"]
+	svc.Storage = storageConfig{Endpoint: endpoint, PathStyle: true, Region: "auto"}
+	ec := svc.Env["staging"]
+	ec.CloudFront = ""
+	ec.Purge = purgeConfig{Type: "none"}
+	svc.Env["staging"] = ec
+	cfg.Services["frontend"] = svc
+	return cfg
+}
+
+func TestStaticDeployS3Compatible(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	cfg := storageTestConfig(srv.URL)
+	awsCfg := aws.Config{Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "minio", SecretAccessKey: "minio123"}, nil
+	})}
+	client := s3ClientFor(awsCfg, cfg.Services["frontend"].Storage)
+	ctx := context.Background()
+
+	tag := "main-abc1234-20250101000000"
+	dir := writeBuildDir(t, map[string]string{"index.html": "<h1>hi</h1>", "assets/app.js": "run()"})
+	p := &staticPublisher{s3: client}
+	if err := runPublish(ctx, cfg, p, publishOpts{Service: "frontend", Dir: dir, Tag: tag}, io.Discard); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	// The default client must not be used for a service with its own
+	// storage.
+	d := &staticDeployer{cfg: cfg, s3: nil, storage: map[string]s3DeployAPI{"frontend": client}}
+	var summary string
+	if err := d.deploy(withSummary(ctx, func(s string) { summary = s }), "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if got := strings.Join(fake.keys("frontend-staging", "current/"), " "); got != "assets/app.js index.html" {
+		t.Errorf("current/ = %s", got)
+	}
+	if summary != "2 added, 0 updated, 0 removed, CDN not purged" {
+		t.Errorf("summary = %q", summary)
+	}
+	if ct := fake.objects["frontend-staging/current/index.html"].header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("current/index.html Content-Type = %q", ct)
+	}
+
+	var out strings.Builder
+	if err := runVerify(ctx, cfg, d, verifyOpts{Services: []string{"frontend"}, Env: "staging"}, &out); err != nil {
+		t.Fatalf("verify: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "0 problems") {
+		t.Errorf("verify output = %s", out.String())
+	}
+
+	// A file removed from the next build is deleted from current/.
+	tag2 := "main-def5678-20250102000000"
+	dir2 := writeBuildDir(t, map[string]string{"index.html": "<h1>hello</h1>"})
+	if err := runPublish(ctx, cfg, p, publishOpts{Service: "frontend", Dir: dir2, Tag: tag2}, io.Discard); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := d.deploy(withSummary(ctx, func(s string) { summary = s }), "frontend", "staging", tag2, tag, deployParams{}); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if got := strings.Join(fake.keys("frontend-staging", "current/"), " "); got != "index.html" {
+		t.Errorf("current/ = %s", got)
+	}
+	if summary != "0 added, 1 updated, 1 removed, CDN not purged" {
+		t.Errorf("summary = %q", summary)
+	}
+}
+
+func TestStaticDeployPurgeWebhook(t *testing.T) {
+	var got purgeRequest
+	var auth string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer hook.Close()
+	t.Setenv("PURGE_TOKEN", "s3cret")
+
+	cfg := testConfig()
+	svc := cfg.Services["frontend"]
+	ec := svc.Env["staging"]
+	ec.CloudFront = ""
+	ec.Purge = purgeConfig{Type: "webhook", URL: hook.URL, Headers: map[string]string{"Authorization": "Bearer ${PURGE_TOKEN}"}}
+	svc.Env["staging"] = ec
+	cfg.Services["frontend"] = svc
+
+	tag := "main-abc1234-20250101000000"
+	stub := &stubS3Deploy{objects: map[string]string{"builds/" + tag + "/index.html": `"i"`}}
+	var summary string
+	ctx := withSummary(context.Background(), func(s string) { summary = s })
+	d := &staticDeployer{cfg: cfg, s3: stub}
+	if err := d.deploy(ctx, "frontend", "staging", tag, "", deployParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if auth != "Bearer s3cret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if got.Service != "frontend" || got.Env != "staging" || got.Tag != tag || strings.Join(got.Paths, " ") != "/ /index.html" {
+		t.Errorf("request = %+v", got)
+	}
+	if summary != "1 added, 0 updated, 0 removed, purged 2 paths" {
+		t.Errorf("summary = %q", summary)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token", http.StatusForbidden)
+	}))
+	defer failing.Close()
+	ec.Purge.URL = failing.URL
+	svc.Env["staging"] = ec
+	stub.objects["builds/"+tag+"/app.js"] = `"a"`
+	err := d.deploy(context.Background(), "frontend", "staging", tag, "", deployParams{})
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "bad token") {
+		t.Errorf("err = %v, want the webhook's error", err)
+	}
+}
+
+func TestStaticDeploySharedBucket(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	cfg := storageTestConfig(srv.URL)
+	svc := cfg.Services["frontend"]
+	staging := svc.Env["staging"]
+	staging.Bucket, staging.Prefix = "shared", "frontend/staging"
+	prod := staging
+	prod.Prefix = "frontend/prod"
+	svc.Env = map[string]envConfig{"staging": staging, "prod": prod}
+	cfg.Services["frontend"] = svc
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+
+	awsCfg := aws.Config{Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "minio", SecretAccessKey: "minio123"}, nil
+	})}
+	client := s3ClientFor(awsCfg, svc.Storage)
+	ctx := context.Background()
+
+	tag1 := "main-abc1234-20250101000000"
+	tag2 := "main-def5678-20250102000000"
+	p := &staticPublisher{s3: client}
+	for tag, files := range map[string]map[string]string{
+		tag1: {"index.html": "<h1>one</h1>"},
+		tag2: {"index.html": "<h1>two</h1>", "app.js": "run()"},
+	} {
+		if err := runPublish(ctx, cfg, p, publishOpts{Service: "frontend", Dir: writeBuildDir(t, files), Tag: tag}, io.Discard); err != nil {
+			t.Fatalf("publish %s: %v", tag, err)
+		}
+	}
+
+	d := &staticDeployer{cfg: cfg, storage: map[string]s3DeployAPI{"frontend": client}}
+	if err := d.deploy(ctx, "frontend", "prod", tag1, "", deployParams{}); err != nil {
+		t.Fatalf("deploy prod: %v", err)
+	}
+	if err := d.deploy(ctx, "frontend", "staging", tag2, "", deployParams{}); err != nil {
+		t.Fatalf("deploy staging: %v", err)
+	}
+
+	// Deploying staging must leave prod's content and markers alone.
+	if got := strings.Join(fake.keys("shared", "frontend/prod/current/"), " "); got != "index.html" {
+		t.Errorf("prod current/ = %s", got)
+	}
+	if got := strings.Join(fake.keys("shared", "frontend/staging/current/"), " "); got != "app.js index.html" {
+		t.Errorf("staging current/ = %s", got)
+	}
+	if got := fake.keys("shared", "current"); len(got) != 0 {
+		t.Errorf("unprefixed keys written: %v", got)
+	}
+
+	h := &staticHistoryProvider{cfg: cfg, s3: client}
+	for env, want := range map[string]string{"prod": tag1, "staging": tag2} {
+		dep, err := h.current(ctx, "frontend", env)
+		if err != nil {
+			t.Fatalf("current %s: %v", env, err)
+		}
+		if dep.Tag != want {
+			t.Errorf("%s current tag = %q, want %q", env, dep.Tag, want)
+		}
+	}
+
+	b := &staticBuildsProvider{s3: client, bucket: "shared", prefix: bucketPrefix(prod)}
+	builds, err := b.listBuilds(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("listBuilds: %v", err)
+	}
+	if len(builds) != 2 || builds[0].Tag != tag2 {
+		t.Errorf("builds = %+v", builds)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// livePrefix returns where the objects an env serves live: current/, or
+// for the origin_path strategy the build of the current tag.
+func (d *staticDeployer) livePrefix(ctx context.Context, ec envConfig) (string, error) {
+	if ec.Strategy != "origin_path" {
+		return bucketKey(ec, "current/"), nil
+	}
+	tag, err := d.readMarker(ctx, ec.Bucket, bucketKey(ec, "current-tag"))
+	if err != nil {
+		return "", err
+	}
+	if tag == "" {
+		return "", fmt.Errorf("nothing deployed to s3://%s yet", ec.Bucket)
+	}
+	return bucketKey(ec, "builds/"+strings.TrimSpace(tag)+"/"), nil
+}
+
+// verify checks the objects an env serves against the manifest of the live
+// build, if it has one, and the service's metadata rules, printing each
+// problem to w. It returns the number of problems found.
+func (d *staticDeployer) verify(ctx context.Context, service, env string, w io.Writer) (int, error) {
+	d = d.forService(service)
+	svc := d.cfg.Services[service]
+	ec := svc.Env[env]
+
+	prefix, err := d.livePrefix(ctx, ec)
+	if err != nil {
+		return 0, err
+	}
+	objects, err := d.listObjects(ctx, ec.Bucket, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("listing s3://%s/%s: %w", ec.Bucket, prefix, err)
+	}
+
+	var problems []string
+	sums := map[string]string{} // manifest SHA-256 by key
+	tag, err := d.readMarker(ctx, ec.Bucket, bucketKey(ec, "current-tag"))
+	if err != nil {
+		return 0, err
+	}
+	tag = strings.TrimSpace(tag)
+	m, hasManifest, err := d.readManifest(ctx, ec, tag)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case hasManifest:
+		problems = append(problems, m.check(objects)...)
+		for _, f := range m.Files {
+			if _, ok := objects[f.Path]; ok {
+				sums[f.Path] = f.SHA256
+			}
+		}
+	case svc.Manifest == "required":
+		problems = append(problems, fmt.Sprintf("no manifest for live build %s", tag))
+	}
+
+	const maxWorkers = 20
+	sem := make(chan struct{}, maxWorkers)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	checked := 0
+	for key := range objects {
+		want, hasRule := metadataFor(svc.Metadata, key)
+		sum, hasSum := sums[key]
+		if !hasRule && !hasSum {
+			continue
+		}
+		checked++
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			full := prefix + key
+			head, err := d.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &ec.Bucket, Key: &full})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("reading metadata of s3://%s/%s: %w", ec.Bucket, full, err)
+				}
+				return
+			}
+			have := headMeta(head)
+			if hasRule {
+				for _, v := range want.violations(have) {
+					problems = append(problems, fmt.Sprintf("%s: %s", key, v))
+				}
+			}
+			if hasSum {
+				switch got := have.Metadata[manifestSHA256Key]; got {
+				case sum:
+				case "":
+					problems = append(problems, key+": no sha256 recorded")
+				default:
+					problems = append(problems, key+": sha256 differs from manifest")
+				}
+			}
+		}(key)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+
+	sort.Strings(problems)
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s %s: %s%s\n", service, env, prefix, p)
+	}
+	against := fmt.Sprintf("%d metadata rules", len(svc.Metadata))
+	if hasManifest {
+		against = fmt.Sprintf("the manifest of %s and %s", tag, against)
+	}
+	fmt.Fprintf(w, "%s %s: checked %d of %d objects in s3://%s/%s against %s, %d problems\n",
+		service, env, checked, len(objects), ec.Bucket, prefix, against, len(problems))
+	return len(problems), nil
+}
+
+type verifyOpts struct {
+	Services []string // empty means all static services
+	Env      string   // empty means all envs
+}
+
+// runVerify verifies every selected static service and env and fails if
+// any of them has problems.
+func runVerify(ctx context.Context, cfg config, d *staticDeployer, opts verifyOpts, w io.Writer) error {
+	services := opts.Services
+	if len(services) == 0 {
+		for _, name := range sortedServiceNames(cfg) {
+			if cfg.Services[name].Type == "static" {
+				services = append(services, name)
+			}
+		}
+	}
+	if len(services) == 0 {
+		return fmt.Errorf("no static services to verify")
+	}
+
+	var failed []string
+	for _, name := range services {
+		svc, ok := cfg.Services[name]
+		if !ok {
+			return fmt.Errorf("unknown service: %q", name)
+		}
+		if svc.Type != "static" {
+			return fmt.Errorf("service %q: verify only supports static services", name)
+		}
+		var envs []string
+		for env := range svc.Env {
+			envs = append(envs, env)
+		}
+		sort.Strings(envs)
+		if opts.Env != "" {
+			if _, ok := svc.Env[opts.Env]; !ok {
+				return fmt.Errorf("service %q has no env %q", name, opts.Env)
+			}
+			envs = []string{opts.Env}
+		}
+		for _, env := range envs {
+			n, err := d.verify(ctx, name, env, w)
+			if err != nil {
+				fmt.Fprintf(w, "%s %s: %v\n", name, env, err)
+			}
+			if err != nil || n > 0 {
+				failed = append(failed, name+" "+env)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("verify failed for: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -15,6 +15,10 @@ type statusRow struct {
 	Tag     string
 	Uptime  time.Duration
 	Health  string
+	Digest  string // digest recorded on the running container
+	// DigestDrift is set when the tag no longer points at Digest in the
+	// registry, or when that couldn't be checked.
+	DigestDrift string
 }
 
 func getStatus(ctx context.Context, cfg config, p providers, envFilter string) ([]statusRow, error) {
@@ -68,10 +72,23 @@ func getStatus(ctx context.Context, cfg config, p providers, envFilter string) (
 				Env:     q.env,
 				Tag:     cur.Tag,
 				Uptime:  cur.Uptime,
+				Digest:  cur.Digest,
 			}
-			if q.svc.Type == "server" {
+			if dr, ok := p.builds[q.svc.Type].(digestResolver); ok && cur.Digest != "" {
+				latest, err := dr.resolveDigest(ctx, q.svc.Image, cur.Tag)
+				switch {
+				case err != nil:
+					row.DigestDrift = "unverified"
+				case latest != "" && latest != cur.Digest:
+					row.DigestDrift = "tag moved"
+				}
+			}
+			switch q.svc.Type {
+			case "server":
 				row.Health = "healthy"
-			} else {
+			case "worker", "compose", "binary":
+				row.Health = "running"
+			default:
 				row.Health = "-"
 			}
 			results[i] = result{row: row}
@@ -124,10 +141,20 @@ func formatStatusTable(rows []statusRow) string {
 	}
 
 	var b strings.Builder
-	fmt.Fprintf(&b, "%-*s  %-*s  %-*s  %-*s\n", svcW, "SERVICE", tagW, "TAG", upW, "UPTIME", healthW, "HEALTH")
+	fmt.Fprintf(&b, "%-*s  %-*s  %-*s  %-*s  %s\n", svcW, "SERVICE", tagW, "TAG", upW, "UPTIME", healthW, "HEALTH", "DIGEST")
 	for _, r := range rows {
 		label := r.Service + "-" + r.Env
-		fmt.Fprintf(&b, "%-*s  %-*s  %-*s  %-*s\n", svcW, label, tagW, r.Tag, upW, formatUptime(r.Uptime), healthW, r.Health)
+		fmt.Fprintf(&b, "%-*s  %-*s  %-*s  %-*s  %s\n", svcW, label, tagW, r.Tag, upW, formatUptime(r.Uptime), healthW, r.Health, formatDigest(r))
 	}
 	return b.String()
 }
+
+func formatDigest(r statusRow) string {
+	if r.Digest == "" {
+		return "-"
+	}
+	if r.DigestDrift != "" {
+		return fmt.Sprintf("%s (%s)", shortDigest(r.Digest), r.DigestDrift)
+	}
+	return shortDigest(r.Digest)
+}
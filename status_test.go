@@ -94,6 +94,35 @@ func TestGetStatusHealthValues(t *testing.T) {
 	}
 }
 
+func TestGetStatusWorkerHealth(t *testing.T) {
+	cfg := testConfig()
+	cfg.Services["jobs"] = serviceConfig{
+		Type:  "worker",
+		Image: "myapp/jobs",
+		Env:   map[string]envConfig{"staging": {Node: "web1", EnvFile: "/etc/jobs/staging.env"}},
+	}
+	deploys := map[string]deploy{
+		"jobs:staging": {Service: "jobs", Env: "staging", Tag: "tag1", Uptime: time.Hour},
+	}
+	p, _ := testProviders(nil, deploys)
+	p.history["worker"] = p.history["server"]
+
+	rows, err := getStatus(context.Background(), cfg, p, "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, r := range rows {
+		if r.Service == "jobs" {
+			if r.Health != "running" {
+				t.Errorf("expected jobs health 'running', got %q", r.Health)
+			}
+			return
+		}
+	}
+	t.Fatal("expected a row for the worker service")
+}
+
 func TestGetStatusMissingDeploy(t *testing.T) {
 	cfg := testConfig()
 	// Only backend:staging has a deploy; frontend:staging returns zero deploy (not deployed yet)
@@ -180,6 +209,64 @@ func TestFormatStatusTable(t *testing.T) {
 	}
 }
 
+func TestGetStatusDigestDrift(t *testing.T) {
+	cfg := testConfig()
+	deploys := map[string]deploy{
+		"backend:staging":    {Service: "backend", Env: "staging", Tag: "t1", Digest: "sha256:aaaa"},
+		"backend:production": {Service: "backend", Env: "production", Tag: "t2", Digest: "sha256:bbbb"},
+	}
+	p, _ := testProviders(nil, deploys)
+	p.builds["server"] = &stubDigestBuilds{digests: map[string]string{
+		"myapp/backend:t1": "sha256:aaaa",
+		"myapp/backend:t2": "sha256:cccc",
+	}}
+
+	rows, err := getStatus(context.Background(), cfg, p, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, r := range rows {
+		if r.Service != "backend" {
+			continue
+		}
+		want := ""
+		if r.Env == "production" {
+			want = "tag moved"
+		}
+		if r.DigestDrift != want {
+			t.Errorf("%s: digest drift = %q, want %q", r.Env, r.DigestDrift, want)
+		}
+	}
+
+	// Images that can't be pinned resolve to no digest, which isn't drift.
+	p.builds["server"] = &stubDigestBuilds{}
+	rows, err = getStatus(context.Background(), cfg, p, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, r := range rows {
+		if r.DigestDrift != "" {
+			t.Errorf("%s/%s: digest drift = %q, want none", r.Service, r.Env, r.DigestDrift)
+		}
+	}
+}
+
+func TestFormatDigest(t *testing.T) {
+	tests := []struct {
+		row  statusRow
+		want string
+	}{
+		{statusRow{}, "-"},
+		{statusRow{Digest: "sha256:0123456789abcdef0123"}, "0123456789ab"},
+		{statusRow{Digest: "sha256:0123456789abcdef0123", DigestDrift: "tag moved"}, "0123456789ab (tag moved)"},
+	}
+	for _, tt := range tests {
+		if got := formatDigest(tt.row); got != tt.want {
+			t.Errorf("formatDigest(%+v) = %q, want %q", tt.row, got, tt.want)
+		}
+	}
+}
+
 func TestFormatStatusTableEmpty(t *testing.T) {
 	output := formatStatusTable(nil)
 	if output != "No services found.\n" {
@@ -23,6 +23,18 @@ type serviceStatus struct {
 
 type serviceStatusMsg serviceStatus
 
+// serviceProgressMsg carries an in-flight status line for a service.
+type serviceProgressMsg struct {
+	service string
+	detail  string
+}
+
+// serviceSummaryMsg carries a line shown next to a service once it's done.
+type serviceSummaryMsg struct {
+	service string
+	summary string
+}
+
 type rollbackChoice int
 
 const (
@@ -34,6 +46,8 @@ const (
 type deployModel struct {
 	services       []string
 	results        map[string]*serviceStatus
+	progress       map[string]string
+	summary        map[string]string
 	pending        int
 	phase          deployPhase
 	spinner        spinner.Model
@@ -49,6 +63,8 @@ func newDeployModel(services []string) deployModel {
 	return deployModel{
 		services: services,
 		results:  results,
+		progress: make(map[string]string, len(services)),
+		summary:  make(map[string]string, len(services)),
 		pending:  len(services),
 		phase:    phaseDeploying,
 		spinner:  s,
@@ -78,6 +94,14 @@ func (m deployModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 
+	case serviceProgressMsg:
+		m.progress[msg.service] = msg.detail
+		return m, nil
+
+	case serviceSummaryMsg:
+		m.summary[msg.service] = msg.summary
+		return m, nil
+
 	case tea.KeyMsg:
 		if m.phase == phaseRollbackPrompt {
 			switch msg.String() {
@@ -126,18 +150,22 @@ func (m deployModel) View() string {
 		for _, svc := range m.services {
 			status, ok := m.results[svc]
 			if !ok {
-				fmt.Fprintf(&b, "  %s  deploying...\n", svc)
+				detail := m.progress[svc]
+				if detail == "" {
+					detail = "deploying..."
+				}
+				fmt.Fprintf(&b, "  %s  %s\n", svc, detail)
 			} else if status.err != nil {
 				fmt.Fprintf(&b, "  %s  FAILED: %v\n", svc, status.err)
 			} else {
-				fmt.Fprintf(&b, "  %s  done\n", svc)
+				fmt.Fprintf(&b, "  %s  %s\n", svc, m.done(svc))
 			}
 		}
 
 	case phaseComplete:
 		b.WriteString("Deploy complete!\n\n")
 		for _, svc := range m.services {
-			fmt.Fprintf(&b, "  %s  done\n", svc)
+			fmt.Fprintf(&b, "  %s  %s\n", svc, m.done(svc))
 		}
 
 	case phaseRollbackPrompt:
@@ -147,7 +175,7 @@ func (m deployModel) View() string {
 			if status.err != nil {
 				fmt.Fprintf(&b, "  %s  FAILED: %v\n", svc, status.err)
 			} else {
-				fmt.Fprintf(&b, "  %s  done\n", svc)
+				fmt.Fprintf(&b, "  %s  %s\n", svc, m.done(svc))
 			}
 		}
 		b.WriteString("\nRollback? [Y/n/s] (Y=all, n=leave, s=failed only) ")
@@ -155,3 +183,11 @@ func (m deployModel) View() string {
 
 	return b.String()
 }
+
+// done is the status shown for a service that deployed successfully.
+func (m deployModel) done(svc string) string {
+	if s := m.summary[svc]; s != "" {
+		return "done (" + s + ")"
+	}
+	return "done"
+}
@@ -133,3 +133,33 @@ func TestDeployViewRollbackPrompt(t *testing.T) {
 		t.Fatal("should show rollback prompt")
 	}
 }
+
+func TestDeployProgressShownWhileDeploying(t *testing.T) {
+	m := newDeployModel([]string{"frontend", "backend"})
+
+	m, _ = updateDeploy(m, serviceProgressMsg{service: "backend", detail: "draining old container (5s/30s)..."})
+
+	view := m.View()
+	if !strings.Contains(view, "backend  draining old container (5s/30s)...") {
+		t.Errorf("expected backend progress in view, got:\n%s", view)
+	}
+	if !strings.Contains(view, "frontend  deploying...") {
+		t.Errorf("expected default status for frontend, got:\n%s", view)
+	}
+}
+
+func TestDeploySummaryShownWhenDone(t *testing.T) {
+	m := newDeployModel([]string{"frontend", "backend"})
+
+	m, _ = updateDeploy(m, serviceSummaryMsg{service: "frontend", summary: "3 added, 1 updated, 2 removed"})
+	m, _ = updateDeploy(m, serviceStatusMsg{service: "frontend"})
+	m, _ = updateDeploy(m, serviceStatusMsg{service: "backend"})
+
+	view := m.View()
+	if !strings.Contains(view, "frontend  done (3 added, 1 updated, 2 removed)") {
+		t.Errorf("expected frontend summary in view, got:\n%s", view)
+	}
+	if !strings.Contains(view, "backend  done\n") {
+		t.Errorf("expected plain done for backend, got:\n%s", view)
+	}
+}